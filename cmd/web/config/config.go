@@ -1,74 +1,233 @@
 package config
 
 import (
-	"errors"
 	"fmt"
+	"github.com/thisisjab/snippetbox-go/internal/password"
 	"os"
-	"reflect"
+	"strconv"
 	"strings"
-	"unicode"
+	"time"
 )
 
 type Config struct {
-	databasePath   string
-	migrationsPath string
-	tlsCertPath    string
-	tlsKeyPath     string
+	argon2Iterations      int
+	argon2Memory          int
+	argon2Parallelism     int
+	avatarDir             string
+	baseUrl               string
+	breachedPasswordsPath string
+	databasePath          string
+	migrationsPath        string
+	oidcClientId          string
+	oidcClientSecret      string
+	oidcIssuer            string
+	oidcProviderName      string
+	passwordMinEntropy    int
+	passwordMinLength     int
+	previewsPath          string
+	rememberMeLifetime    time.Duration
+	secretKey             string
+	sessionIdleTimeout    time.Duration
+	sessionLifetime       time.Duration
+	signupDomains         string
+	signupMode            string
+	smtpHost              string
+	smtpPort              string
+	smtpUsername          string
+	smtpPassword          string
+	smtpSender            string
+	tlsCertPath           string
+	tlsKeyPath            string
 }
 
-func (c *Config) DatabasePath() string   { return c.databasePath }
-func (c *Config) MigrationsPath() string { return c.migrationsPath }
-func (c *Config) TLSCertPath() string    { return c.tlsCertPath }
-func (c *Config) TLSKeyPath() string     { return c.tlsKeyPath }
+// Argon2Memory, Argon2Iterations and Argon2Parallelism set the cost of
+// hashing passwords. Memory is in KiB. Raising them upgrades existing hashes as
+// their users log in.
+func (c *Config) Argon2Memory() int      { return c.argon2Memory }
+func (c *Config) Argon2Iterations() int  { return c.argon2Iterations }
+func (c *Config) Argon2Parallelism() int { return c.argon2Parallelism }
+
+// AvatarDir is a directory uploaded avatars are stored in. If it's empty
+// they're stored in the database.
+func (c *Config) AvatarDir() string { return c.avatarDir }
+
+// BaseURL is the scheme and host the site is publicly reachable at, such as
+// "https://snippets.example.com". It's used wherever an absolute link is needed,
+// and must be set, as links in emails are only ever built from it.
+func (c *Config) BaseURL() string { return strings.TrimSuffix(c.baseUrl, "/") }
+
+// BreachedPasswordsPath is a directory holding a copy of the Pwned Passwords
+// list split into files by hash prefix, which users' new passwords are checked
+// against. The check is skipped if it's empty.
+func (c *Config) BreachedPasswordsPath() string { return c.breachedPasswordsPath }
+func (c *Config) DatabasePath() string          { return c.databasePath }
+func (c *Config) MigrationsPath() string        { return c.migrationsPath }
+
+// OIDCIssuer is the OpenID Connect provider users can sign in with, such as
+// "https://accounts.google.com". Single sign-on is disabled if it's empty.
+func (c *Config) OIDCIssuer() string       { return c.oidcIssuer }
+func (c *Config) OIDCClientID() string     { return c.oidcClientId }
+func (c *Config) OIDCClientSecret() string { return c.oidcClientSecret }
+
+// OIDCProviderName is shown on the login page's single sign-on button.
+func (c *Config) OIDCProviderName() string { return c.oidcProviderName }
+
+// PasswordMinEntropy is how many bits of entropy new passwords must be
+// estimated to have.
+func (c *Config) PasswordMinEntropy() int { return c.passwordMinEntropy }
+func (c *Config) PasswordMinLength() int  { return c.passwordMinLength }
+func (c *Config) PreviewsPath() string    { return c.previewsPath }
+
+// RememberMeLifetime is how long a session lasts when the user ticks "remember
+// me" on login. Such sessions aren't ended by the idle timeout.
+func (c *Config) RememberMeLifetime() time.Duration { return c.rememberMeLifetime }
+
+// SecretKey signs links which are trusted without a database lookup, such as
+// email verification links. If it's empty a random key is used, so links stop
+// working when the server restarts.
+func (c *Config) SecretKey() string { return c.secretKey }
+
+// SessionIdleTimeout logs users out of sessions they haven't used for this
+// long, unless they asked to be remembered. Zero disables it.
+func (c *Config) SessionIdleTimeout() time.Duration { return c.sessionIdleTimeout }
+func (c *Config) SessionLifetime() time.Duration    { return c.sessionLifetime }
+
+// Signup modes decide who may create an account.
+const (
+	SignupOpen   = "open"
+	SignupClosed = "closed"
+	SignupInvite = "invite"
+	SignupDomain = "domain"
+)
+
+// SignupMode is one of SignupOpen, SignupClosed, SignupInvite, where new users
+// need an invite code issued by an admin for their address, or SignupDomain,
+// where only addresses at SignupDomains may sign up.
+func (c *Config) SignupMode() string { return c.signupMode }
+
+// SignupDomains are the email domains which may sign up in SignupDomain mode,
+// set as a comma-separated list such as "example.com,example.org".
+func (c *Config) SignupDomains() []string {
+	var domains []string
+
+	for _, d := range strings.Split(c.signupDomains, ",") {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+
+	return domains
+}
+
+// SMTPHost is the server emails are delivered through. If it's empty, emails
+// are written to standard output instead.
+func (c *Config) SMTPHost() string     { return c.smtpHost }
+func (c *Config) SMTPPort() string     { return c.smtpPort }
+func (c *Config) SMTPUsername() string { return c.smtpUsername }
+func (c *Config) SMTPPassword() string { return c.smtpPassword }
+func (c *Config) SMTPSender() string   { return c.smtpSender }
+func (c *Config) TLSCertPath() string  { return c.tlsCertPath }
+func (c *Config) TLSKeyPath() string   { return c.tlsKeyPath }
 
 func LoadConfig() (*Config, error) {
 	cfg := &Config{
-		databasePath:   "./db.sql",
-		migrationsPath: "./cmd/web/db/versions",
-		tlsCertPath:    "./tls/cert.pem",
-		tlsKeyPath:     "./tls/key.pem",
+		argon2Iterations:   int(password.DefaultParams.Iterations),
+		argon2Memory:       int(password.DefaultParams.Memory),
+		argon2Parallelism:  int(password.DefaultParams.Parallelism),
+		databasePath:       "./db.sql",
+		migrationsPath:     "./cmd/web/db/versions",
+		oidcProviderName:   "SSO",
+		passwordMinEntropy: 40,
+		passwordMinLength:  8,
+		previewsPath:       "./cache/previews",
+		rememberMeLifetime: 30 * 24 * time.Hour,
+		sessionIdleTimeout: 2 * time.Hour,
+		sessionLifetime:    12 * time.Hour,
+		signupMode:         SignupOpen,
+		smtpPort:           "587",
+		smtpSender:         "Snippetbox <no-reply@snippetbox.local>",
+		tlsCertPath:        "./tls/cert.pem",
+		tlsKeyPath:         "./tls/key.pem",
 	}
 
-	v := reflect.ValueOf(cfg).Elem()
-	t := v.Type()
+	for _, v := range cfg.envVars() {
+		value := os.Getenv(v.name)
+		if value == "" {
+			continue
+		}
 
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		envVarName, err := toUpperSnakeCase(field.Name)
+		err := setField(v.field, value)
 		if err != nil {
-			return nil, fmt.Errorf("error converting field name '%s': %w", field.Name, err) // Return error immediately
+			return nil, fmt.Errorf("error setting %s: %w", v.name, err)
 		}
+	}
 
-		envVarValue := os.Getenv(envVarName)
+	return cfg, nil
+}
 
-		if envVarValue != "" {
-			fieldValue := v.Field(i)
-			if fieldValue.Kind() == reflect.String {
-				fieldValue.SetString(envVarValue)
-			} else {
-				return nil, fmt.Errorf("field '%s' is not a string, cannot set from env var", field.Name)
-			}
-		}
+// envVar is an environment variable and the field of Config it sets.
+type envVar struct {
+	name  string
+	field any
+}
 
+// envVars lists the environment variables settings are read from. The fields
+// are unexported so that the config can't be changed after loading.
+func (c *Config) envVars() []envVar {
+	return []envVar{
+		{"ARGON2_ITERATIONS", &c.argon2Iterations},
+		{"ARGON2_MEMORY", &c.argon2Memory},
+		{"ARGON2_PARALLELISM", &c.argon2Parallelism},
+		{"AVATAR_DIR", &c.avatarDir},
+		{"BASE_URL", &c.baseUrl},
+		{"BREACHED_PASSWORDS_PATH", &c.breachedPasswordsPath},
+		{"DATABASE_PATH", &c.databasePath},
+		{"MIGRATIONS_PATH", &c.migrationsPath},
+		{"OIDC_CLIENT_ID", &c.oidcClientId},
+		{"OIDC_CLIENT_SECRET", &c.oidcClientSecret},
+		{"OIDC_ISSUER", &c.oidcIssuer},
+		{"OIDC_PROVIDER_NAME", &c.oidcProviderName},
+		{"PASSWORD_MIN_ENTROPY", &c.passwordMinEntropy},
+		{"PASSWORD_MIN_LENGTH", &c.passwordMinLength},
+		{"PREVIEWS_PATH", &c.previewsPath},
+		{"REMEMBER_ME_LIFETIME", &c.rememberMeLifetime},
+		{"SECRET_KEY", &c.secretKey},
+		{"SESSION_IDLE_TIMEOUT", &c.sessionIdleTimeout},
+		{"SESSION_LIFETIME", &c.sessionLifetime},
+		{"SIGNUP_DOMAINS", &c.signupDomains},
+		{"SIGNUP_MODE", &c.signupMode},
+		{"SMTP_HOST", &c.smtpHost},
+		{"SMTP_PORT", &c.smtpPort},
+		{"SMTP_USERNAME", &c.smtpUsername},
+		{"SMTP_PASSWORD", &c.smtpPassword},
+		{"SMTP_SENDER", &c.smtpSender},
+		{"TLS_CERT_PATH", &c.tlsCertPath},
+		{"TLS_KEY_PATH", &c.tlsKeyPath},
 	}
-
-	return cfg, nil
 }
 
-func toUpperSnakeCase(key string) (string, error) {
-	result := ""
-	for i, char := range key {
-		if !(unicode.IsLetter(char) || unicode.IsNumber(char)) {
-			return "", errors.New("key must only contain letters and numbers")
+// setField parses value into the field it points to. Durations use
+// time.ParseDuration's format, such as "12h" or "30m".
+func setField(field any, value string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
 		}
-
-		if unicode.IsUpper(char) {
-			if i > 0 {
-				result += "_"
-			}
+		*f = n
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
 		}
-		result += string(char)
+		*f = d
+	default:
+		return fmt.Errorf("unsupported type %T", field)
 	}
 
-	return strings.ToUpper(result), nil
+	return nil
 }
@@ -2,4 +2,8 @@ package main
 
 type contextKey string
 
-const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const (
+	isAuthenticatedContextKey   = contextKey("isAuthenticated")
+	authenticatedUserContextKey = contextKey("authenticatedUser")
+	apiTokenContextKey          = contextKey("apiToken")
+)
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -25,7 +26,8 @@ type migration struct {
 }
 
 // RunMigrations takes a db connection, target migration version, and direction (upgrade/downgrade) and runs migrations.
-// It's assumed that migration files are sorted based on their target version.
+// It's assumed that migration files are sorted based on their target version. Applied versions are recorded in the
+// schema_migrations table so each migration runs at most once, and downgrades revert the newest migrations first.
 func (ms *MigrationSet) RunMigrations(db *sql.DB, targetVersion int, upgrade bool) error {
 	tx, err := db.Begin()
 	if err != nil {
@@ -36,17 +38,27 @@ func (ms *MigrationSet) RunMigrations(db *sql.DB, targetVersion int, upgrade boo
 		_ = tx.Rollback()
 	}()
 
-	for _, m := range ms.migrations {
+	applied, err := appliedVersions(tx)
+	if err != nil {
+		return fmt.Errorf("reading applied migrations: %w", err)
+	}
+
+	migrations := slices.Clone(ms.migrations)
+	if !upgrade {
+		slices.Reverse(migrations)
+	}
+
+	for _, m := range migrations {
 
 		var migrationFilePath string
 		if upgrade {
-			if targetVersion < m.version {
+			if targetVersion < m.version || applied[m.version] {
 				continue
 			}
 
 			migrationFilePath = filepath.Join(ms.basePath, m.upFileName)
 		} else {
-			if targetVersion >= m.version {
+			if targetVersion >= m.version || !applied[m.version] {
 				continue
 			}
 
@@ -62,11 +74,49 @@ func (ms *MigrationSet) RunMigrations(db *sql.DB, targetVersion int, upgrade boo
 		if err != nil {
 			return fmt.Errorf("executing migration %s: %w", migrationFilePath, err)
 		}
+
+		if upgrade {
+			_, err = tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", m.version)
+		} else {
+			_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.version)
+		}
+		if err != nil {
+			return fmt.Errorf("recording migration %s: %w", migrationFilePath, err)
+		}
 	}
 
 	return tx.Commit()
 }
 
+// appliedVersions creates the schema_migrations table if needed and returns the set of versions already applied.
+func appliedVersions(tx *sql.Tx) (map[int]bool, error) {
+	_, err := tx.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY)")
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+
+	for rows.Next() {
+		var version int
+
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
 // LoadMigrations finds all migrations in given path.
 func (ms *MigrationSet) LoadMigrations(path string) error {
 
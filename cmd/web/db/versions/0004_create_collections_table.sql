@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    title VARCHAR(100) NOT NULL,
+    description TEXT NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_collections_user_id ON collections(user_id);
+
+CREATE TABLE IF NOT EXISTS collection_snippets (
+    collection_id INTEGER NOT NULL REFERENCES collections(id),
+    snippet_id INTEGER NOT NULL REFERENCES snippets(id),
+    position INTEGER NOT NULL,
+    PRIMARY KEY (collection_id, snippet_id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS collection_snippets;
+DROP INDEX IF EXISTS idx_collections_user_id;
+DROP TABLE IF EXISTS collections;
\ No newline at end of file
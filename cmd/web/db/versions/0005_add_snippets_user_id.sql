@@ -0,0 +1,3 @@
+ALTER TABLE snippets ADD COLUMN user_id INTEGER REFERENCES users(id);
+
+CREATE INDEX IF NOT EXISTS idx_snippets_user_id ON snippets(user_id);
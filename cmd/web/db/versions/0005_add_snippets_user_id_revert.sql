@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_snippets_user_id;
+ALTER TABLE snippets DROP COLUMN user_id;
\ No newline at end of file
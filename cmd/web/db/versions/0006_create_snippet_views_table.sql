@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS snippet_views (
+    snippet_id INTEGER NOT NULL REFERENCES snippets(id),
+    day DATE NOT NULL,
+    views INTEGER NOT NULL,
+    PRIMARY KEY (snippet_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_snippet_views_day ON snippet_views(day);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_snippet_views_day;
+DROP TABLE IF EXISTS snippet_views;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS tokens (
+    hash BLOB NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    expiry DATETIME NOT NULL,
+    scope TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tokens_user_id ON tokens(user_id);
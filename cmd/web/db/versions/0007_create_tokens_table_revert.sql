@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_tokens_user_id;
+DROP TABLE IF EXISTS tokens;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN verification_sent DATETIME;
+
+-- Accounts created before verification existed are trusted as they are.
+UPDATE users SET verified = TRUE;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN verification_sent;
+ALTER TABLE users DROP COLUMN verified;
\ No newline at end of file
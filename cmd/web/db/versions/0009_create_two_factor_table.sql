@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS two_factor (
+    user_id INTEGER NOT NULL PRIMARY KEY REFERENCES users(id),
+    secret TEXT NOT NULL,
+    last_step INTEGER NOT NULL DEFAULT 0,
+    created DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    hash BLOB NOT NULL,
+    PRIMARY KEY (user_id, hash)
+);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id BLOB NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    name TEXT NOT NULL,
+    public_key BLOB NOT NULL,
+    sign_count INTEGER NOT NULL DEFAULT 0,
+    created DATETIME NOT NULL,
+    last_used DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys(user_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS passkeys;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS invites (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    hash BLOB NOT NULL UNIQUE,
+    email TEXT NOT NULL,
+    created_by INTEGER NOT NULL REFERENCES users(id),
+    created DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    used_by INTEGER REFERENCES users(id),
+    used DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_invites_email ON invites(email);
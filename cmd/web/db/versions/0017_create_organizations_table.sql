@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    organization_id INTEGER NOT NULL REFERENCES organizations(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    role TEXT NOT NULL,
+    created DATETIME NOT NULL,
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_members_user_id ON organization_members(user_id);
+
+ALTER TABLE snippets ADD COLUMN organization_id INTEGER REFERENCES organizations(id);
+ALTER TABLE snippets ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public';
+
+CREATE INDEX IF NOT EXISTS idx_snippets_organization_id ON snippets(organization_id);
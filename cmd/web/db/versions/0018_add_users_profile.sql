@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN username TEXT;
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users(username);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_username;
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN username;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS avatars (
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    size INTEGER NOT NULL,
+    image BLOB NOT NULL,
+    PRIMARY KEY (user_id, size)
+);
+
+ALTER TABLE users ADD COLUMN avatar_updated DATETIME;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN avatar_updated;
+DROP TABLE IF EXISTS avatars;
\ No newline at end of file
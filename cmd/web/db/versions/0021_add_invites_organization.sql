@@ -0,0 +1,4 @@
+ALTER TABLE invites ADD COLUMN organization_id INTEGER REFERENCES organizations(id);
+ALTER TABLE invites ADD COLUMN organization_role TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_invites_organization_id ON invites(organization_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_invites_organization_id;
+ALTER TABLE invites DROP COLUMN organization_role;
+ALTER TABLE invites DROP COLUMN organization_id;
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN has_password BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Accounts created through single sign-on were given a random password, and
+-- their identity was linked in the same second.
+UPDATE users SET has_password = FALSE WHERE id IN (
+    SELECT user_id FROM user_identities WHERE user_identities.created = users.created
+);
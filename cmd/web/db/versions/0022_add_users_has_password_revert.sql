@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN has_password;
\ No newline at end of file
@@ -1,12 +1,32 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/thisisjab/snippetbox-go/cmd/web/config"
+	"github.com/thisisjab/snippetbox-go/internal/avatar"
+	"github.com/thisisjab/snippetbox-go/internal/identicon"
 	"github.com/thisisjab/snippetbox-go/internal/model"
+	"github.com/thisisjab/snippetbox-go/internal/oidc"
+	"github.com/thisisjab/snippetbox-go/internal/totp"
 	"github.com/thisisjab/snippetbox-go/internal/validator"
+	"github.com/thisisjab/snippetbox-go/internal/webauthn"
+	"html"
+	"io"
 	"net/http"
+	"net/url"
+	"rsc.io/qr"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func (app *application) ping(w http.ResponseWriter, r *http.Request) {
@@ -27,8 +47,15 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	popular, err := app.snippets.Popular(7, 10)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
+	data.PopularSnippets = popular
 
 	app.render(w, r, http.StatusOK, "home.gohtml", data)
 }
@@ -49,25 +76,268 @@ func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID := app.authenticatedUserID(r)
+
+	canView, err := app.canViewSnippet(userID, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !canView {
+		http.NotFound(w, r)
+		return
+	}
+
+	canEdit, err := app.canEditSnippet(userID, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Owners looking at their own snippets don't count as views.
+	if userID == 0 || userID != snippet.UserID {
+		app.views.Add(app.viewerKey(r), snippet.ID)
+	}
+
+	snippet.Views += app.views.Pending(snippet.ID)
+
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
+	data.CanEdit = canEdit
 
 	app.render(w, r, http.StatusOK, "view.gohtml", data)
 }
 
+func (app *application) snippetPreviewImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Previews and embeds are fetched without the viewer's session, so only
+	// public snippets have them.
+	if !snippet.Public() {
+		http.NotFound(w, r)
+		return
+	}
+
+	img, err := app.previews.Snippet(snippet.Title, snippet.Content)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(img)
+}
+
+func (app *application) embedSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if !snippet.Public() {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := templateData{
+		Snippet: snippet,
+		BaseURL: app.baseURL(r),
+	}
+
+	app.render(w, r, http.StatusOK, "snippet_embed.gohtml", data)
+}
+
+// embedScript inserts an iframe showing the snippet in place of the script tag
+// which loaded it. It's formatted with the JSON encoded iframe URL and title.
+const embedScript = `(function () {
+  var script = document.currentScript;
+  var frame = document.createElement("iframe");
+  frame.src = %s;
+  frame.title = %s;
+  frame.width = "100%%";
+  frame.height = "300";
+  frame.style.border = "0";
+  script.parentNode.insertBefore(frame, script);
+})();
+`
+
+func (app *application) embedSnippetScript(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if !snippet.Public() {
+		http.NotFound(w, r)
+		return
+	}
+
+	src, err := json.Marshal(fmt.Sprintf("%s/snippets/embed/%d", app.baseURL(r), snippet.ID))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	title, err := json.Marshal(snippet.Title)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	fmt.Fprintf(w, embedScript, src, title)
+}
+
+type oEmbedResponse struct {
+	Version      string `json:"version"`
+	Type         string `json:"type"`
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// oEmbed implements the provider side of https://oembed.com for snippet view
+// URLs, answering with a rich response which embeds the snippet in an iframe.
+func (app *application) oEmbed(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if format := query.Get("format"); format != "" && format != "json" {
+		app.clientError(w, http.StatusNotImplemented)
+		return
+	}
+
+	baseURL := app.baseURL(r)
+
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	target, err := url.Parse(query.Get("url"))
+	if err != nil || target.Host != base.Host {
+		http.NotFound(w, r)
+		return
+	}
+
+	idPath, found := strings.CutPrefix(target.Path, "/snippets/view/")
+	id, err := strconv.Atoi(idPath)
+	if !found || err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if !snippet.Public() {
+		http.NotFound(w, r)
+		return
+	}
+
+	width, height := 600, 300
+	if maxWidth, err := strconv.Atoi(query.Get("maxwidth")); err == nil && maxWidth > 0 {
+		width = min(width, maxWidth)
+	}
+	if maxHeight, err := strconv.Atoi(query.Get("maxheight")); err == nil && maxHeight > 0 {
+		height = min(height, maxHeight)
+	}
+
+	embedHTML := fmt.Sprintf(`<iframe src="%s/snippets/embed/%d" title="%s" width="%d" height="%d" style="border: 0"></iframe>`,
+		html.EscapeString(baseURL), snippet.ID, html.EscapeString(snippet.Title), width, height)
+
+	app.writeJSON(w, r, http.StatusOK, oEmbedResponse{
+		Version:      "1.0",
+		Type:         "rich",
+		Title:        snippet.Title,
+		AuthorName:   snippet.Author,
+		ProviderName: "Snippetbox",
+		ProviderURL:  baseURL,
+		HTML:         embedHTML,
+		Width:        width,
+		Height:       height,
+	})
+}
+
 func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = snippetCreateForm{
-		Expires: 365,
+	// Organization pages link here with the organization picked.
+	orgID, _ := strconv.Atoi(r.URL.Query().Get("org"))
+
+	app.renderCreateSnippet(w, r, http.StatusOK, snippetCreateForm{
+		Expires:    365,
+		OrgID:      orgID,
+		Visibility: model.VisibilityPublic,
+	})
+}
+
+// renderCreateSnippet shows the snippet form along with the organizations the
+// snippet can be created under.
+func (app *application) renderCreateSnippet(w http.ResponseWriter, r *http.Request, status int, form snippetCreateForm) {
+	orgs, err := app.organizations.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
 	}
 
-	app.render(w, r, http.StatusOK, "create.gohtml", data)
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Organizations = orgs
+
+	app.render(w, r, status, "create.gohtml", data)
 }
 
 type snippetCreateForm struct {
 	Title               string `form:"title"`
 	Content             string `form:"content"`
 	Expires             int    `form:"expires"`
+	OrgID               int    `form:"orgID"`
+	Visibility          string `form:"visibility"`
 	validator.Validator `form:"-"`
 }
 
@@ -91,39 +361,118 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
 	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
 	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+	form.CheckField(validator.PermittedValue(form.Visibility, model.VisibilityPublic, model.VisibilityOrganization), "visibility", "This field must be public or organization")
+
+	if form.OrgID != 0 {
+		member, err := app.isOrgMember(form.OrgID, app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		form.CheckField(member, "orgID", "You aren't a member of this organization")
+	} else {
+		form.CheckField(form.Visibility != model.VisibilityOrganization, "visibility", "Only snippets created under an organization can be limited to it")
+	}
 
 	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl", data)
+		app.renderCreateSnippet(w, r, http.StatusUnprocessableEntity, form)
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	id, err := app.snippets.Insert(app.authenticatedUserID(r), form.OrgID, form.Title, form.Content, form.Expires, form.Visibility)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	app.audit(r, model.AuditSnippetCreate, app.authenticatedUserID(r), 0, map[string]string{"snippet": strconv.Itoa(id), "title": form.Title})
+
 	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
 
 	http.Redirect(w, r, fmt.Sprintf("/snippets/view/%d", id), http.StatusSeeOther)
 }
 
-type userSignupForm struct {
-	FullName            string `form:"fullName"`
-	Email               string `form:"email"`
-	Password            string `form:"password"`
+type snippetEditForm struct {
+	Title               string `form:"title"`
+	Content             string `form:"content"`
+	Visibility          string `form:"visibility"`
 	validator.Validator `form:"-"`
 }
 
-func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+// editableSnippet loads the snippet named by the {id} path value and makes
+// sure the current user may edit it. If they can't, an error response has
+// already been written and ok is false.
+func (app *application) editableSnippet(w http.ResponseWriter, r *http.Request) (snippet model.Snippet, ok bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return model.Snippet{}, false
+	}
+
+	snippet, err = app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return model.Snippet{}, false
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	canView, err := app.canViewSnippet(userID, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return model.Snippet{}, false
+	}
+
+	if !canView {
+		http.NotFound(w, r)
+		return model.Snippet{}, false
+	}
+
+	canEdit, err := app.canEditSnippet(userID, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return model.Snippet{}, false
+	}
+
+	if !canEdit {
+		app.clientError(w, http.StatusForbidden)
+		return model.Snippet{}, false
+	}
+
+	return snippet, true
+}
+
+func (app *application) editSnippet(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.editableSnippet(w, r)
+	if !ok {
+		return
+	}
+
 	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
-	app.render(w, r, http.StatusOK, "signup.gohtml", data)
+	data.Snippet = snippet
+	data.Form = snippetEditForm{
+		Title:      snippet.Title,
+		Content:    snippet.Content,
+		Visibility: snippet.Visibility,
+	}
+
+	app.render(w, r, http.StatusOK, "edit.gohtml", data)
 }
-func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
-	var form userSignupForm
+
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+
+	snippet, ok := app.editableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var form snippetEditForm
 
 	err := app.decodePostForm(r, &form)
 	if err != nil {
@@ -131,51 +480,66 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.FullName), "fullName", "This field cannot be blank")
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validator.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(validator.PermittedValue(form.Visibility, model.VisibilityPublic, model.VisibilityOrganization), "visibility", "This field must be public or organization")
+	form.CheckField(snippet.OrgID != 0 || form.Visibility != model.VisibilityOrganization, "visibility", "Only snippets created under an organization can be limited to it")
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
+		data.Snippet = snippet
 		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "signup.gohtml", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "edit.gohtml", data)
 		return
 	}
 
-	err = app.users.Insert(form.FullName, form.Email, form.Password)
+	err = app.snippets.Update(snippet.ID, form.Title, form.Content, form.Visibility)
 	if err != nil {
-		if errors.Is(err, model.ErrDuplicateEmail) {
-			form.AddFieldError("email", "Email address is already in use")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "signup.gohtml", data)
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
-
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully updated!")
 
+	http.Redirect(w, r, fmt.Sprintf("/snippets/view/%d", snippet.ID), http.StatusSeeOther)
 }
 
-type userLoginForm struct {
+type userSignupForm struct {
+	FullName            string `form:"fullName"`
+	Username            string `form:"username"`
 	Email               string `form:"email"`
 	Password            string `form:"password"`
+	Invite              string `form:"invite"`
 	validator.Validator `form:"-"`
 }
 
-func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	form := userSignupForm{}
+
+	// Invite emails link here with the code filled in.
+	if app.config.SignupMode() == config.SignupInvite {
+		form.Invite = r.URL.Query().Get("invite")
+
+		invite, err := app.invites.Get(form.Invite)
+		if err == nil {
+			form.Email = invite.Email
+		} else if !errors.Is(err, model.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
 	data := app.newTemplateData(r)
-	data.Form = userLoginForm{}
-	app.render(w, r, http.StatusOK, "login.gohtml", data)
+	data.Form = form
+	app.render(w, r, http.StatusOK, "signup.gohtml", data)
 }
-func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
-	form := userLoginForm{}
+func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
+	var form userSignupForm
 
 	err := app.decodePostForm(r, &form)
 	if err != nil {
@@ -183,20 +547,341 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
-	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
-	if !form.Valid() {
+	if app.config.SignupMode() == config.SignupClosed {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "login.gohtml", data)
+		app.render(w, r, http.StatusForbidden, "signup.gohtml", data)
 		return
 	}
 
-	userID, err := app.users.Authenticate(form.Email, form.Password)
-	if err != nil {
-		if errors.Is(err, model.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is invalid.")
+	form.Username = strings.ToLower(strings.TrimSpace(form.Username))
+
+	form.CheckField(validator.NotBlank(form.FullName), "fullName", "This field cannot be blank")
+	checkUsername(&form.Validator, form.Username)
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+	if app.config.SignupMode() == config.SignupInvite {
+		form.CheckField(validator.NotBlank(form.Invite), "invite", "This field cannot be blank")
+	}
+
+	err = app.passwordPolicy.Check(&form.Validator, "password", form.Password, form.FullName, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var invite model.Invite
+
+	if form.Valid() {
+		invite, err = app.checkSignup(form.Email, form.Invite)
+		switch {
+		case errors.Is(err, errSignupDomain):
+			form.AddFieldError("email", "Signup is limited to addresses at "+strings.Join(app.config.SignupDomains(), ", "))
+		case errors.Is(err, errSignupInvite):
+			form.AddFieldError("invite", "This invite code is invalid, has expired or is for a different email address")
+		case err != nil:
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.gohtml", data)
+		return
+	}
+
+	id, err := app.users.Insert(form.FullName, form.Username, form.Email, form.Password)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrDuplicateEmail):
+			form.AddFieldError("email", "Email address is already in use")
+		case errors.Is(err, model.ErrDuplicateUsername):
+			form.AddFieldError("username", "Username is already taken")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.gohtml", data)
+		return
+	}
+
+	if invite.ID != 0 {
+		err = app.invites.MarkUsed(invite.ID, id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.audit(r, model.AuditSignup, id, 0, map[string]string{"email": form.Email, "method": "password"})
+
+	err = app.sendVerificationEmail(model.User{ID: id, FullName: form.FullName, Email: form.Email})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Check your inbox to verify your email address, then log in.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+}
+
+// checkUsername validates a username which has already been lowercased and
+// trimmed.
+func checkUsername(v *validator.Validator, username string) {
+	v.CheckField(validator.NotBlank(username), "username", "This field cannot be blank")
+	v.CheckField(validator.Matches(username, validator.UsernameRX), "username", "Usernames are 3 to 30 lowercase letters, digits, hyphens or underscores, starting with a letter or digit")
+	v.CheckField(validator.NotReservedUsername(username), "username", "This username is reserved")
+}
+
+var (
+	errSignupClosed = errors.New("signup is closed")
+	errSignupDomain = errors.New("email domain isn't allowed to sign up")
+	errSignupInvite = errors.New("no usable invite for email address")
+)
+
+// checkSignup reports whether the configured signup mode lets a new account
+// be created for email. When signup is invite-only it returns the invite which
+// allows it, so that it can be marked as used: the one with the given code,
+// or if there's no code, as with single sign-on, any outstanding invite for
+// the address.
+func (app *application) checkSignup(email, code string) (model.Invite, error) {
+	switch app.config.SignupMode() {
+	case config.SignupClosed:
+		return model.Invite{}, errSignupClosed
+	case config.SignupDomain:
+		_, domain, _ := strings.Cut(email, "@")
+		if !slices.Contains(app.config.SignupDomains(), strings.ToLower(domain)) {
+			return model.Invite{}, errSignupDomain
+		}
+	case config.SignupInvite:
+		var (
+			invite model.Invite
+			err    error
+		)
+
+		if code != "" {
+			invite, err = app.invites.Get(code)
+		} else {
+			invite, err = app.invites.GetByEmail(email)
+		}
+		if err != nil {
+			if errors.Is(err, model.ErrNoRecord) {
+				return model.Invite{}, errSignupInvite
+			}
+
+			return model.Invite{}, err
+		}
+
+		if !strings.EqualFold(invite.Email, email) {
+			return model.Invite{}, errSignupInvite
+		}
+
+		return invite, nil
+	}
+
+	return model.Invite{}, nil
+}
+
+const (
+	// verificationTTL is how long an email verification link stays usable.
+	verificationTTL = 24 * time.Hour
+	// verificationResendInterval is how long users have to wait before they
+	// can ask for another verification email.
+	verificationResendInterval = 5 * time.Minute
+	// verificationPurpose is what verification links are signed for.
+	verificationPurpose = "email-verification"
+)
+
+// sendVerificationEmail emails the user a signed link which proves they own
+// their address when followed.
+func (app *application) sendVerificationEmail(user model.User) error {
+	token := app.signer.Sign(verificationPurpose, fmt.Sprintf("%d:%s", user.ID, user.Email), time.Now().Add(verificationTTL))
+
+	err := app.users.SetVerificationSent(user.ID)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"Name":      user.FullName,
+		"VerifyURL": app.emailURL("/user/verify?token=" + url.QueryEscape(token)),
+		"TTL":       "24 hours",
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(user.Email, "email/verify_email.tmpl", data)
+		if err != nil {
+			app.logger.Error("Error sending verification email", "error", err)
+		}
+	})
+
+	return nil
+}
+
+func (app *application) userVerify(w http.ResponseWriter, r *http.Request) {
+	const invalidLink = "This verification link is invalid or has expired."
+
+	payload, err := app.signer.Verify(verificationPurpose, r.URL.Query().Get("token"), time.Now())
+	if err != nil {
+		app.sessionManager.Put(r.Context(), "flash", invalidLink)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	idPart, email, _ := strings.Cut(payload, ":")
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		app.sessionManager.Put(r.Context(), "flash", invalidLink)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil && !errors.Is(err, model.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The link is for the address the user had when it was sent; if they've
+	// changed it since, the new one still needs verifying.
+	if err != nil || user.Email != email {
+		app.sessionManager.Put(r.Context(), "flash", invalidLink)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if !user.Verified {
+		err = app.users.SetVerified(user.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been verified.")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) userVerifyResendPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	switch {
+	case user.Verified:
+		app.sessionManager.Put(r.Context(), "flash", "Your email address is already verified.")
+	case time.Since(user.VerificationSent) < verificationResendInterval:
+		app.sessionManager.Put(r.Context(), "flash", "We've only just sent you a verification email. Please wait a few minutes before asking for another.")
+	default:
+		err = app.sendVerificationEmail(user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), "flash", "We've sent another verification email to "+user.Email+".")
+	}
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+type userLoginForm struct {
+	Email               string `form:"email"`
+	Password            string `form:"password"`
+	RememberMe          bool   `form:"rememberMe"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userLoginForm{}
+	app.render(w, r, http.StatusOK, "login.gohtml", data)
+}
+func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
+	form := userLoginForm{}
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login.gohtml", data)
+		return
+	}
+
+	ip := clientIP(r)
+
+	if until := app.ipThrottle.LockedUntil(ip); !until.IsZero() {
+		app.auditLoginFailure(r, 0, form.Email, "ip locked out")
+		form.AddNonFieldError(lockoutMessage(until))
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login.gohtml", data)
+		return
+	}
+
+	// The account is looked up first so that a locked account turns away even
+	// the right password.
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil && !errors.Is(err, model.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	accountExists := err == nil
+
+	// Addresses without an account lock out the same way, or the lockout would
+	// tell who has one.
+	emailKey := strings.ToLower(form.Email)
+	lockedUntil := user.LockedUntil
+	if !accountExists {
+		lockedUntil = app.emailThrottle.LockedUntil(emailKey)
+	}
+
+	if time.Now().Before(lockedUntil) {
+		app.auditLoginFailure(r, user.ID, form.Email, "account locked out")
+		form.AddNonFieldError(lockoutMessage(lockedUntil))
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login.gohtml", data)
+		return
+	}
+
+	userID, err := app.users.Authenticate(form.Email, form.Password)
+	if err != nil {
+		if errors.Is(err, model.ErrInvalidCredentials) {
+			app.auditLoginFailure(r, user.ID, form.Email, "invalid credentials")
+
+			if accountExists {
+				err = app.recordLoginFailure(r, user)
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+			} else {
+				app.recordIPLoginFailure(r)
+				app.emailThrottle.Fail(emailKey)
+			}
+
+			form.AddNonFieldError("Email or password is invalid.")
 			data := app.newTemplateData(r)
 			data.Form = form
 			app.render(w, r, http.StatusUnauthorized, "login.gohtml", data)
@@ -207,26 +892,3723 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.sessionManager.RenewToken(r.Context())
+	if user.Disabled {
+		app.auditLoginFailure(r, user.ID, form.Email, "account disabled")
+		form.AddNonFieldError(accountDisabledMessage)
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusForbidden, "login.gohtml", data)
+		return
+	}
+
+	err = app.renewToken(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Users with two-factor authentication aren't logged in until they've also
+	// entered a code, so for now the session only remembers who they claim to be.
+	_, err = app.twoFactor.Secret(userID)
+	if err == nil {
+		app.sessionManager.Put(r.Context(), "twoFactorUserID", userID)
+		app.sessionManager.Put(r.Context(), "twoFactorStarted", app.now().Unix())
+		app.sessionManager.Put(r.Context(), "twoFactorRememberMe", form.RememberMe)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	if !errors.Is(err, model.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.users.ResetLoginFailures(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.startSession(r, userID, form.RememberMe, "password")
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "userID", userID)
 	app.sessionManager.Put(r.Context(), "flash", "You successfully logged in.")
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	err := app.sessionManager.RenewToken(r.Context())
+
+// auditLoginFailure records a failed login in the audit log. userID is the
+// account it was for, or 0 if there's no such account, in which case the email
+// address which was tried is all there is to go on.
+func (app *application) auditLoginFailure(r *http.Request, userID int, email, reason string) {
+	details := map[string]string{"reason": reason}
+	if email != "" {
+		details["email"] = email
+	}
+
+	app.audit(r, model.AuditLoginFailed, 0, userID, details)
+}
+
+// recordIPLoginFailure counts a failed login against the client's IP address,
+// blocking it for a while once there have been too many.
+func (app *application) recordIPLoginFailure(r *http.Request) {
+	ip := clientIP(r)
+
+	if until := app.ipThrottle.Fail(ip); !until.IsZero() {
+		app.logger.Warn("IP address locked out after failed logins", "ip", ip, "until", until)
+	}
+}
+
+// recordLoginFailure counts a failed login against both the client's IP
+// address and the account, locking the account with exponential backoff once
+// it has failed too many times in a row.
+func (app *application) recordLoginFailure(r *http.Request, user model.User) error {
+	app.recordIPLoginFailure(r)
+
+	failures, err := app.users.RecordLoginFailure(user.ID)
 	if err != nil {
-		app.serverError(w, r, err)
+		return err
+	}
+
+	d := lockoutDuration(failures, accountLockoutThreshold)
+	if d == 0 {
+		return nil
+	}
+
+	app.logger.Warn("Account locked out after failed logins", "userID", user.ID, "failures", failures, "ip", clientIP(r), "duration", d)
+
+	return app.users.Lock(user.ID, d)
+}
+
+// checkLockout reports whether the client's IP address or user's account is
+// locked out after failed logins, adding an error to v if so.
+func (app *application) checkLockout(r *http.Request, user model.User, v *validator.Validator) bool {
+	if until := app.ipThrottle.LockedUntil(clientIP(r)); !until.IsZero() {
+		v.AddNonFieldError(lockoutMessage(until))
+		return true
+	}
+
+	if app.now().Before(user.LockedUntil) {
+		v.AddNonFieldError(lockoutMessage(user.LockedUntil))
+		return true
+	}
+
+	return false
+}
+
+// confirmPassword checks the password a logged in user entered to confirm a
+// change to their account. Wrong ones count towards the same lockouts as
+// failed logins, or a stolen session could be used to guess it. Unless it's
+// confirmed, an error is added to v, for key if the password is wrong. Either
+// way, the status to show the form with if v isn't valid is returned.
+func (app *application) confirmPassword(r *http.Request, user model.User, v *validator.Validator, key, password string) (int, error) {
+	if app.checkLockout(r, user, v) {
+		return http.StatusTooManyRequests, nil
+	}
+
+	id, err := app.users.Authenticate(user.Email, password)
+	if err != nil && !errors.Is(err, model.ErrInvalidCredentials) {
+		return 0, err
+	}
+	if err == nil && id == user.ID {
+		return http.StatusUnprocessableEntity, nil
+	}
+
+	app.auditLoginFailure(r, user.ID, "", "invalid password")
+
+	err = app.recordLoginFailure(r, user)
+	if err != nil {
+		return 0, err
+	}
+
+	v.AddFieldError(key, "Password is incorrect")
+	return http.StatusUnprocessableEntity, nil
+}
+
+type userForgotPasswordForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userForgotPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userForgotPasswordForm{}
+	app.render(w, r, http.StatusOK, "forgot.gohtml", data)
+}
+
+// passwordResetTTL is how long a password reset link stays usable.
+const passwordResetTTL = time.Hour
+
+func (app *application) userForgotPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form userForgotPasswordForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	app.sessionManager.Remove(r.Context(), "userID")
-	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot.gohtml", data)
+		return
+	}
+
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil && !errors.Is(err, model.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Unknown addresses get the same response as known ones so that the form
+	// can't be used to find out who has an account.
+	if err == nil {
+		token, err := app.tokens.New(user.ID, passwordResetTTL, model.ScopePasswordReset)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := map[string]any{
+			"Name":     user.FullName,
+			"ResetURL": app.emailURL("/user/reset/" + token),
+			"TTL":      "1 hour",
+		}
+
+		app.background(func() {
+			err := app.mailer.Send(user.Email, "email/password_reset.tmpl", data)
+			if err != nil {
+				app.logger.Error("Error sending password reset email", "error", err)
+			}
+		})
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "If an account exists for that email address, we've sent it a link to reset the password.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+type userResetPasswordForm struct {
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
+func (app *application) userResetPassword(w http.ResponseWriter, r *http.Request) {
+	_, err := app.tokens.UserID(r.PathValue("token"), model.ScopePasswordReset)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This password reset link is invalid or has expired.")
+			http.Redirect(w, r, "/user/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = userResetPasswordForm{}
+	data.Token = r.PathValue("token")
+	app.render(w, r, http.StatusOK, "reset.gohtml", data)
+}
+
+func (app *application) userResetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form userResetPasswordForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// The user is looked up without using up the token, so that they can try
+	// again if their new password is turned down.
+	userID, err := app.tokens.UserID(r.PathValue("token"), model.ScopePasswordReset)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This password reset link is invalid or has expired.")
+			http.Redirect(w, r, "/user/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "This field cannot be blank")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
+
+	err = app.passwordPolicy.Check(&form.Validator, "newPassword", form.NewPassword, user.FullName, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.Token = r.PathValue("token")
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.gohtml", data)
+		return
+	}
+
+	userID, err = app.tokens.Consume(r.PathValue("token"), model.ScopePasswordReset)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This password reset link is invalid or has expired.")
+			http.Redirect(w, r, "/user/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.users.PasswordSet(userID, form.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditPasswordReset, userID, 0, nil)
+
+	// Any other links which were sent out are now stale, and whoever may have
+	// been using the old password is logged out.
+	err = app.tokens.DeleteAllForUser(userID, model.ScopePasswordReset)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.renewToken(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.destroyOtherSessions(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been reset. Please log in.")
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	err := app.endSession(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditLogout, userID, 0, nil)
+
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (app *application) collectionList(w http.ResponseWriter, r *http.Request) {
+	collections, err := app.collections.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collections = collections
+
+	app.render(w, r, http.StatusOK, "collections.gohtml", data)
+}
+
+func (app *application) showCollection(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	collection, err := app.collections.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collection = collection
+
+	app.render(w, r, http.StatusOK, "collection_view.gohtml", data)
+}
+
+type collectionCreateForm struct {
+	Title               string `form:"title"`
+	Description         string `form:"description"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) createCollection(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = collectionCreateForm{}
+
+	app.render(w, r, http.StatusOK, "collection_create.gohtml", data)
+}
+
+func (app *application) collectionCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form collectionCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.MaxChars(form.Description, 1000), "description", "This field cannot be more than 1000 characters long")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "collection_create.gohtml", data)
+		return
+	}
+
+	id, err := app.collections.Insert(app.authenticatedUserID(r), form.Title, form.Description)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Collection successfully created!")
+
+	http.Redirect(w, r, fmt.Sprintf("/collections/edit/%d", id), http.StatusSeeOther)
+}
+
+// ownedCollection loads the collection named by the {id} path value and makes
+// sure it belongs to the current user. If it doesn't, an error response has
+// already been written and ok is false.
+func (app *application) ownedCollection(w http.ResponseWriter, r *http.Request) (collection model.Collection, ok bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return model.Collection{}, false
+	}
+
+	collection, err = app.collections.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return model.Collection{}, false
+	}
+
+	if collection.UserID != app.authenticatedUserID(r) {
+		app.clientError(w, http.StatusForbidden)
+		return model.Collection{}, false
+	}
+
+	return collection, true
+}
+
+type collectionSnippetForm struct {
+	SnippetID           int `form:"snippetID"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) editCollection(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Collection = collection
+	data.Form = collectionSnippetForm{}
+
+	app.render(w, r, http.StatusOK, "collection_edit.gohtml", data)
+}
+
+func (app *application) collectionAddSnippetPost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var form collectionSnippetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Only snippets which are currently visible to everyone can be added, so
+	// that the collection doesn't end up referencing something its viewers
+	// can't see.
+	snippet, err := app.snippets.Get(form.SnippetID)
+	if err != nil {
+		if !errors.Is(err, model.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+
+		form.AddFieldError("snippetID", "There is no snippet with this ID")
+	} else if !snippet.Public() {
+		canView, err := app.canViewSnippet(app.authenticatedUserID(r), snippet)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if canView {
+			form.AddFieldError("snippetID", "Snippets limited to an organization can't be added to collections")
+		} else {
+			form.AddFieldError("snippetID", "There is no snippet with this ID")
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Collection = collection
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "collection_edit.gohtml", data)
+		return
+	}
+
+	err = app.collections.AddSnippet(collection.ID, form.SnippetID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/collections/edit/%d", collection.ID), http.StatusSeeOther)
+}
+
+func (app *application) collectionRemoveSnippetPost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var form collectionSnippetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.collections.RemoveSnippet(collection.ID, form.SnippetID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/collections/edit/%d", collection.ID), http.StatusSeeOther)
+}
+
+type collectionReorderForm struct {
+	Positions map[int]int `form:"position"`
+}
+
+func (app *application) collectionReorderPost(w http.ResponseWriter, r *http.Request) {
+	collection, ok := app.ownedCollection(w, r)
+	if !ok {
+		return
+	}
+
+	var form collectionReorderForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Every member has to be given a position. Any left out would otherwise
+	// be sorted to the top as if they'd been given position 0, which is what
+	// happens when a snippet is added in another tab before saving.
+	if len(form.Positions) != len(collection.Snippets) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	for _, s := range collection.Snippets {
+		if _, ok := form.Positions[s.ID]; !ok {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
+	snippets := collection.Snippets
+	sort.SliceStable(snippets, func(i, j int) bool {
+		return form.Positions[snippets[i].ID] < form.Positions[snippets[j].ID]
+	})
+
+	snippetIDs := make([]int, len(snippets))
+	for i, s := range snippets {
+		snippetIDs[i] = s.ID
+	}
+
+	err = app.collections.Reorder(collection.ID, snippetIDs)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Collection order saved.")
+
+	http.Redirect(w, r, fmt.Sprintf("/collections/edit/%d", collection.ID), http.StatusSeeOther)
+}
+
+func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	_, err = app.twoFactor.Secret(user.ID)
+	if err != nil && !errors.Is(err, model.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	twoFactorEnabled := err == nil
+
+	sessions, err := app.userSessions.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.TwoFactorEnabled = twoFactorEnabled
+	data.Sessions = sessions
+	data.CurrentSessionToken = app.sessionManager.Token(r.Context())
+
+	app.render(w, r, http.StatusOK, "account.gohtml", data)
+}
+
+// profileSnippetsLimit caps how many snippets a profile page lists.
+const profileSnippetsLimit = 20
+
+func (app *application) userProfile(w http.ResponseWriter, r *http.Request) {
+	// Usernames are always lowercase, but links to them might not be.
+	user, err := app.users.GetByUsername(strings.ToLower(r.PathValue("username")))
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	snippets, err := app.snippets.ForUser(user.ID, profileSnippetsLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Snippets = snippets
+
+	app.render(w, r, http.StatusOK, "profile.gohtml", data)
+}
+
+// userAvatar serves the avatar the user uploaded at the size given by the "s"
+// query parameter, or their identicon if they haven't uploaded one. Identicons
+// are keyed by the user's ID rather than their username so that they stay the
+// same if the user is renamed.
+func (app *application) userAvatar(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.GetByUsername(strings.ToLower(r.PathValue("username")))
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	size := avatar.Large
+	if s := r.URL.Query().Get("s"); s != "" {
+		size, err = strconv.Atoi(s)
+		if err != nil || !slices.Contains(avatar.Sizes, size) {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	var img []byte
+
+	if !user.AvatarUpdated.IsZero() {
+		img, err = app.avatars.Get(user.ID, size)
+		if err != nil && !errors.Is(err, model.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if img == nil {
+		img, err = identicon.PNG(fmt.Sprintf("user:%d", user.ID), size)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	// Links to uploaded avatars carry a version which changes with each
+	// upload, so what the current version points to never changes. Links
+	// with an old or made up version get the same short lifetime as
+	// unversioned ones, so they can't pin a replaced avatar in caches.
+	w.Header().Set("Content-Type", "image/png")
+	if !user.AvatarUpdated.IsZero() && r.URL.Query().Get("v") == strconv.FormatInt(user.AvatarUpdated.Unix(), 10) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	w.Write(img)
+}
+
+type accountProfileForm struct {
+	FullName            string `form:"fullName"`
+	Username            string `form:"username"`
+	Bio                 string `form:"bio"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) accountProfile(w http.ResponseWriter, r *http.Request) {
+	app.renderAccountProfile(w, r, http.StatusOK, nil)
+}
+
+// renderAccountProfile shows the profile and avatar forms. If form is nil, the
+// profile form is filled in with the user's current profile.
+func (app *application) renderAccountProfile(w http.ResponseWriter, r *http.Request, status int, form *accountProfileForm) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if form == nil {
+		form = &accountProfileForm{FullName: user.FullName, Username: user.Username, Bio: user.Bio}
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = form
+
+	app.render(w, r, status, "account_profile.gohtml", data)
+}
+
+func (app *application) accountProfilePost(w http.ResponseWriter, r *http.Request) {
+	var form accountProfileForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.Username = strings.ToLower(strings.TrimSpace(form.Username))
+	form.Bio = strings.TrimSpace(form.Bio)
+
+	form.CheckField(validator.NotBlank(form.FullName), "fullName", "This field cannot be blank")
+	checkUsername(&form.Validator, form.Username)
+	form.CheckField(validator.MaxChars(form.Bio, 500), "bio", "This field cannot be more than 500 characters long")
+
+	if form.Valid() {
+		err = app.users.UpdateProfile(app.authenticatedUserID(r), form.FullName, form.Username, form.Bio)
+		if errors.Is(err, model.ErrDuplicateUsername) {
+			form.AddFieldError("username", "Username is already taken")
+		} else if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		app.renderAccountProfile(w, r, http.StatusUnprocessableEntity, &form)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your profile has been updated.")
+
+	http.Redirect(w, r, "/u/"+form.Username, http.StatusSeeOther)
+}
+
+// avatarMaxBytes is the largest image which can be uploaded as an avatar.
+const avatarMaxBytes = 2 << 20
+
+func (app *application) accountAvatarPost(w http.ResponseWriter, r *http.Request) {
+	var form accountProfileForm
+
+	file, header, err := r.FormFile("avatar")
+	switch {
+	case errors.Is(err, http.ErrMissingFile):
+		form.AddFieldError("avatar", "Choose an image to upload")
+	case err != nil:
+		app.clientError(w, http.StatusBadRequest)
+		return
+	case header.Size > avatarMaxBytes:
+		file.Close()
+		form.AddFieldError("avatar", "Images must be "+humanBytes(avatarMaxBytes)+" or smaller")
+	default:
+		defer file.Close()
+
+		b, err := io.ReadAll(file)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		images, err := avatar.Process(b)
+		switch {
+		case errors.Is(err, avatar.ErrUnsupportedFormat):
+			form.AddFieldError("avatar", "Avatars must be PNG, JPEG or GIF images")
+		case errors.Is(err, avatar.ErrTooLarge):
+			form.AddFieldError("avatar", "This image is too large")
+		case err != nil:
+			form.AddFieldError("avatar", "This image couldn't be read")
+		default:
+			err = app.saveAvatar(app.authenticatedUserID(r), images)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+	}
+
+	if !form.Valid() {
+		// Keep what's in the profile form rather than what was submitted, since
+		// only the avatar was.
+		user, err := app.users.Get(app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		form.FullName, form.Username, form.Bio = user.FullName, user.Username, user.Bio
+
+		app.renderAccountProfile(w, r, http.StatusUnprocessableEntity, &form)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your avatar has been updated.")
+
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
+}
+
+// saveAvatar stores the user's new avatar and records when it was uploaded,
+// which changes the links to it.
+func (app *application) saveAvatar(userID int, images map[int][]byte) error {
+	err := app.avatars.Save(userID, images)
+	if err != nil {
+		return err
+	}
+
+	return app.users.SetAvatar(userID, true)
+}
+
+func (app *application) accountAvatarDeletePost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUserID(r)
+
+	err := app.users.SetAvatar(userID, false)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.avatars.Delete(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your avatar has been removed.")
+
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
+}
+
+type accountPasswordUpdateForm struct {
+	CurrentPassword         string `form:"currentPassword"`
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
+func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountPasswordUpdateForm{}
+
+	app.render(w, r, http.StatusOK, "password.gohtml", data)
+}
+
+func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasswordUpdateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "This field cannot be blank")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
+
+	err = app.passwordPolicy.Check(&form.Validator, "newPassword", form.NewPassword, user.FullName, user.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	status := http.StatusUnprocessableEntity
+	if form.Valid() {
+		status, err = app.confirmPassword(r, user, &form.Validator, "currentPassword", form.CurrentPassword)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, status, "password.gohtml", data)
+		return
+	}
+
+	err = app.users.PasswordUpdate(userID, form.CurrentPassword, form.NewPassword)
+	if err != nil {
+		if errors.Is(err, model.ErrInvalidCredentials) {
+			form.AddFieldError("currentPassword", "Current password is incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "password.gohtml", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, model.AuditPasswordChange, userID, 0, nil)
+
+	// A password change should lock out anyone who got hold of the old one, so
+	// every other session is logged out and this one gets a fresh token.
+	err = app.renewToken(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.destroyOtherSessions(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been updated!")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// emailChangeTTL is how long the link confirming a new email address works.
+const emailChangeTTL = 24 * time.Hour
+
+// emailChangePurpose is what email change links are signed for. Their payload
+// is the user's ID and old and new addresses, separated by newlines.
+const emailChangePurpose = "email-change"
+
+// emailChangeApprovalPurpose is what links approving an email change, sent to
+// the old address of users without a password, are signed for. Their payload
+// is the same as that of email change links.
+const emailChangeApprovalPurpose = "email-change-approval"
+
+type accountEmailForm struct {
+	NewEmail            string `form:"newEmail"`
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) accountEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = accountEmailForm{}
+
+	app.render(w, r, http.StatusOK, "account_email.gohtml", data)
+}
+
+// accountEmailPost sends a link to the new address. The address only changes
+// once it's followed, so that nobody can be locked out of their account by a
+// typo. Users without a password to confirm with must first approve the change
+// through a link sent to their old address.
+func (app *application) accountEmailPost(w http.ResponseWriter, r *http.Request) {
+	var form accountEmailForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.NewEmail = strings.TrimSpace(form.NewEmail)
+
+	form.CheckField(validator.NotBlank(form.NewEmail), "newEmail", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.NewEmail, validator.EmailRX), "newEmail", "This field must be a valid email address")
+	form.CheckField(form.NewEmail != user.Email, "newEmail", "This is already your email address")
+	if user.HasPassword {
+		form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+	}
+
+	// Accounts can't move to addresses which couldn't have signed up.
+	if form.Valid() && app.config.SignupMode() == config.SignupDomain {
+		_, err = app.checkSignup(form.NewEmail, "")
+		form.CheckField(err == nil, "newEmail", "Email addresses are limited to ones at "+strings.Join(app.config.SignupDomains(), ", "))
+	}
+
+	status := http.StatusUnprocessableEntity
+	if form.Valid() && user.HasPassword {
+		status, err = app.confirmPassword(r, user, &form.Validator, "password", form.Password)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if form.Valid() {
+		_, err = app.users.GetByEmail(form.NewEmail)
+		if err != nil && !errors.Is(err, model.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(err != nil, "newEmail", "Email address is already in use")
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.User = user
+		data.Form = form
+		app.render(w, r, status, "account_email.gohtml", data)
+		return
+	}
+
+	// The links carry the old address too, so they stop working once the
+	// address has changed, whether through these links or others.
+	payload := strings.Join([]string{strconv.Itoa(user.ID), user.Email, form.NewEmail}, "\n")
+
+	if !user.HasPassword {
+		token := app.signer.Sign(emailChangeApprovalPurpose, payload, time.Now().Add(emailChangeTTL))
+
+		data := map[string]any{
+			"Name":       user.FullName,
+			"NewEmail":   form.NewEmail,
+			"ApproveURL": app.emailURL("/account/email/approve?token=" + url.QueryEscape(token)),
+			"TTL":        "24 hours",
+		}
+
+		app.background(func() {
+			err := app.mailer.Send(user.Email, "email/email_change_approve.tmpl", data)
+			if err != nil {
+				app.logger.Error("Error sending email change approval", "error", err)
+			}
+		})
+
+		app.sessionManager.Put(r.Context(), "flash",
+			fmt.Sprintf("We've sent a link to %s. Follow it to approve the change to %s.", user.Email, form.NewEmail))
+
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	app.sendEmailChangeLink(r, user, payload, form.NewEmail)
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// accountEmailApprove is where the link accountEmailPost sends to the old
+// address of users without a password leads. Following it sends the link
+// confirming the new address, as if they had entered their password.
+func (app *application) accountEmailApprove(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	payload, err := app.signer.Verify(emailChangeApprovalPurpose, r.URL.Query().Get("token"), time.Now())
+
+	fields := strings.Split(payload, "\n")
+	if err != nil || len(fields) != 3 || fields[0] != strconv.Itoa(user.ID) || fields[1] != user.Email {
+		app.sessionManager.Put(r.Context(), "flash", "This email change link is invalid or has expired.")
+		http.Redirect(w, r, "/account/email", http.StatusSeeOther)
+		return
+	}
+
+	app.sendEmailChangeLink(r, user, payload, fields[2])
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// sendEmailChangeLink sends the link which confirms the user's new address to
+// it, and lets them know it's on its way. payload is the signed payload of
+// emailChangePurpose links.
+func (app *application) sendEmailChangeLink(r *http.Request, user model.User, payload, newEmail string) {
+	token := app.signer.Sign(emailChangePurpose, payload, time.Now().Add(emailChangeTTL))
+
+	data := map[string]any{
+		"Name":       user.FullName,
+		"NewEmail":   newEmail,
+		"ConfirmURL": app.emailURL("/user/email/confirm?token=" + url.QueryEscape(token)),
+		"TTL":        "24 hours",
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(newEmail, "email/email_change.tmpl", data)
+		if err != nil {
+			app.logger.Error("Error sending email change confirmation", "error", err)
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), "flash",
+		fmt.Sprintf("We've sent a link to %s. Your email address will change once you follow it.", newEmail))
+}
+
+// userEmailConfirm swaps in the new email address from a link sent by
+// accountEmailPost and lets the old address know. Like verification links, it
+// works without logging in.
+func (app *application) userEmailConfirm(w http.ResponseWriter, r *http.Request) {
+	const invalidLink = "This email change link is invalid or has expired."
+
+	payload, err := app.signer.Verify(emailChangePurpose, r.URL.Query().Get("token"), time.Now())
+	if err != nil {
+		app.sessionManager.Put(r.Context(), "flash", invalidLink)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	fields := strings.Split(payload, "\n")
+	if len(fields) != 3 {
+		app.sessionManager.Put(r.Context(), "flash", invalidLink)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	oldEmail, newEmail := fields[1], fields[2]
+
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		app.sessionManager.Put(r.Context(), "flash", invalidLink)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil && !errors.Is(err, model.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if err == nil {
+		err = app.users.UpdateEmail(id, oldEmail, newEmail)
+	}
+
+	switch {
+	case errors.Is(err, model.ErrNoRecord):
+		app.sessionManager.Put(r.Context(), "flash", invalidLink)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	case errors.Is(err, model.ErrDuplicateEmail):
+		app.sessionManager.Put(r.Context(), "flash", newEmail+" is already in use by another account.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Password reset links already sent went to the old address, which might
+	// be why it was changed.
+	err = app.tokens.DeleteAllForUser(user.ID, model.ScopePasswordReset)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditEmailChange, user.ID, 0, map[string]string{"from": oldEmail, "to": newEmail})
+
+	data := map[string]any{
+		"Name":     user.FullName,
+		"NewEmail": newEmail,
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(oldEmail, "email/email_changed.tmpl", data)
+		if err != nil {
+			app.logger.Error("Error sending email change notice", "error", err)
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), "flash", "Your email address has been changed to "+newEmail+".")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// The files of a personal data export. Times are in UTC, as they're stored.
+type (
+	exportProfile struct {
+		ID       int       `json:"id"`
+		FullName string    `json:"full_name"`
+		Username string    `json:"username,omitempty"`
+		Email    string    `json:"email"`
+		Verified bool      `json:"verified"`
+		Bio      string    `json:"bio,omitempty"`
+		Role     string    `json:"role"`
+		Created  time.Time `json:"created"`
+	}
+	exportSnippet struct {
+		ID           int       `json:"id"`
+		Title        string    `json:"title"`
+		Content      string    `json:"content"`
+		Created      time.Time `json:"created"`
+		Expires      time.Time `json:"expires"`
+		Views        int       `json:"views"`
+		Organization string    `json:"organization,omitempty"`
+		Visibility   string    `json:"visibility"`
+	}
+	exportCollection struct {
+		ID          int       `json:"id"`
+		Title       string    `json:"title"`
+		Description string    `json:"description"`
+		Created     time.Time `json:"created"`
+		SnippetIDs  []int     `json:"snippet_ids"`
+	}
+	exportOrganization struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	exportCredential struct {
+		Name     string     `json:"name"`
+		Scope    string     `json:"scope,omitempty"`
+		Created  time.Time  `json:"created"`
+		Expires  *time.Time `json:"expires,omitempty"`
+		LastUsed *time.Time `json:"last_used,omitempty"`
+	}
+	exportSession struct {
+		UserAgent string    `json:"user_agent"`
+		IP        string    `json:"ip"`
+		Created   time.Time `json:"created"`
+		LastSeen  time.Time `json:"last_seen"`
+	}
+)
+
+// accountExport sends the user a zip of everything the site holds about them,
+// as JSON files and their avatar. Secrets, such as password hashes and keys,
+// are left out.
+func (app *application) accountExport(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	files, err := app.exportFiles(user)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The zip is built in memory so that an error can still be reported
+	// properly rather than cutting off the download.
+	var buf bytes.Buffer
+
+	zw := zip.NewWriter(&buf)
+
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		_, err = fw.Write(f.content)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	filename := fmt.Sprintf("snippetbox-%s.zip", time.Now().UTC().Format("2006-01-02"))
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+// optionalTime leaves times which were never set out of exported JSON.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+type exportFile struct {
+	name    string
+	content []byte
+}
+
+// exportFiles gathers the files of the user's data export.
+func (app *application) exportFiles(user model.User) ([]exportFile, error) {
+	var files []exportFile
+
+	add := func(name string, v any) error {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		files = append(files, exportFile{name: name, content: b})
+		return nil
+	}
+
+	err := add("profile.json", exportProfile{
+		ID:       user.ID,
+		FullName: user.FullName,
+		Username: user.Username,
+		Email:    user.Email,
+		Verified: user.Verified,
+		Bio:      user.Bio,
+		Role:     user.Role,
+		Created:  user.Created,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snippets, err := app.snippets.AllForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exportSnippets := []exportSnippet{}
+	for _, s := range snippets {
+		exportSnippets = append(exportSnippets, exportSnippet{
+			ID:           s.ID,
+			Title:        s.Title,
+			Content:      s.Content,
+			Created:      s.Created,
+			Expires:      s.Expires,
+			Views:        s.Views,
+			Organization: s.OrgName,
+			Visibility:   s.Visibility,
+		})
+	}
+
+	err = add("snippets.json", exportSnippets)
+	if err != nil {
+		return nil, err
+	}
+
+	collections, err := app.collections.ForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exportCollections := []exportCollection{}
+	for _, c := range collections {
+		c, err = app.collections.Get(c.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		ec := exportCollection{ID: c.ID, Title: c.Title, Description: c.Description, Created: c.Created, SnippetIDs: []int{}}
+		for _, s := range c.Snippets {
+			ec.SnippetIDs = append(ec.SnippetIDs, s.ID)
+		}
+
+		exportCollections = append(exportCollections, ec)
+	}
+
+	err = add("collections.json", exportCollections)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs, err := app.organizations.ForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exportOrgs := []exportOrganization{}
+	for _, o := range orgs {
+		exportOrgs = append(exportOrgs, exportOrganization{ID: o.ID, Name: o.Name, Role: o.Role})
+	}
+
+	err = add("organizations.json", exportOrgs)
+	if err != nil {
+		return nil, err
+	}
+
+	apiTokens, err := app.apiTokens.ForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exportTokens := []exportCredential{}
+	for _, t := range apiTokens {
+		exportTokens = append(exportTokens, exportCredential{Name: t.Name, Scope: t.Scope, Created: t.Created,
+			Expires: optionalTime(t.Expires), LastUsed: optionalTime(t.LastUsed)})
+	}
+
+	err = add("api_tokens.json", exportTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys, err := app.passkeys.ForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exportPasskeys := []exportCredential{}
+	for _, p := range passkeys {
+		exportPasskeys = append(exportPasskeys, exportCredential{Name: p.Name, Created: p.Created,
+			LastUsed: optionalTime(p.LastUsed)})
+	}
+
+	err = add("passkeys.json", exportPasskeys)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := app.userSessions.ForUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	exportSessions := []exportSession{}
+	for _, s := range sessions {
+		exportSessions = append(exportSessions, exportSession{UserAgent: s.UserAgent, IP: s.IP, Created: s.Created,
+			LastSeen: s.LastSeen})
+	}
+
+	err = add("sessions.json", exportSessions)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.AvatarUpdated.IsZero() {
+		img, err := app.avatars.Get(user.ID, avatar.Large)
+		if err != nil && !errors.Is(err, model.ErrNoRecord) {
+			return nil, err
+		}
+		if err == nil {
+			files = append(files, exportFile{name: "avatar.png", content: img})
+		}
+	}
+
+	return files, nil
+}
+
+// accountDeletePurpose is the signer purpose of links confirming an account
+// deletion. The payload is the user's ID and email address, separated by a
+// newline, so that links stop working if the address changes.
+const accountDeletePurpose = "account-deletion"
+
+// accountDeleteTTL is how long the link confirming an account deletion works.
+const accountDeleteTTL = time.Hour
+
+// Users without a password confirm deleting their account with a token from a
+// link emailed to them instead.
+type accountDeleteForm struct {
+	Password            string `form:"password"`
+	Token               string `form:"token"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) accountDelete(w http.ResponseWriter, r *http.Request) {
+	app.renderAccountDelete(w, r, http.StatusOK, "account_delete.gohtml", accountDeleteForm{})
+}
+
+// accountDeleteConfirm is where the link sent to users without a password
+// leads. It asks them to confirm once more, as following a link shouldn't
+// delete anything by itself.
+func (app *application) accountDeleteConfirm(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form := accountDeleteForm{Token: r.URL.Query().Get("token")}
+
+	if !app.accountDeleteTokenValid(form.Token, user) {
+		app.sessionManager.Put(r.Context(), "flash", "This account deletion link is invalid or has expired.")
+		http.Redirect(w, r, "/account/delete", http.StatusSeeOther)
+		return
+	}
+
+	app.renderAccountDelete(w, r, http.StatusOK, "account_delete_confirm.gohtml", form)
+}
+
+// renderAccountDelete shows a deletion form, along with any organizations
+// which stop the account from being deleted.
+func (app *application) renderAccountDelete(w http.ResponseWriter, r *http.Request, status int, page string, form accountDeleteForm) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	orgs, err := app.orphanedOrganizations(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = form
+	data.Organizations = orgs
+
+	app.render(w, r, status, page, data)
+}
+
+// accountDeleteTokenValid reports whether token comes from an unexpired link
+// confirming that user is to be deleted.
+func (app *application) accountDeleteTokenValid(token string, user model.User) bool {
+	payload, err := app.signer.Verify(accountDeletePurpose, token, time.Now())
+
+	return err == nil && payload == accountDeletePayload(user)
+}
+
+func accountDeletePayload(user model.User) string {
+	return strconv.Itoa(user.ID) + "\n" + user.Email
+}
+
+// orphanedOrganizations returns the organizations the user is the only owner
+// of while others still belong to them. Deleting the account would leave them
+// without anyone to manage them, so ownership has to be handed over first.
+func (app *application) orphanedOrganizations(userID int) ([]model.Organization, error) {
+	orgs, err := app.organizations.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphaned []model.Organization
+
+	for _, o := range orgs {
+		if o.Role != model.OrgRoleOwner {
+			continue
+		}
+
+		members, err := app.organizations.Members(o.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(members) > 1 && lastOwner(members, userID) {
+			orphaned = append(orphaned, o)
+		}
+	}
+
+	return orphaned, nil
+}
+
+func (app *application) accountDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form accountDeleteForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Organizations are checked first, so that nobody gets as far as
+	// confirming a deletion which can't happen.
+	orphaned, err := app.orphanedOrganizations(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if len(orphaned) > 0 {
+		app.renderAccountDelete(w, r, http.StatusUnprocessableEntity, "account_delete.gohtml", form)
+		return
+	}
+
+	switch {
+	case form.Token != "":
+		if !app.accountDeleteTokenValid(form.Token, user) {
+			app.sessionManager.Put(r.Context(), "flash", "This account deletion link is invalid or has expired.")
+			http.Redirect(w, r, "/account/delete", http.StatusSeeOther)
+			return
+		}
+
+	case !user.HasPassword:
+		// Users who signed up through single sign-on have no password to
+		// confirm with, so they're sent a link instead.
+		token := app.signer.Sign(accountDeletePurpose, accountDeletePayload(user), time.Now().Add(accountDeleteTTL))
+
+		data := map[string]any{
+			"Name":       user.FullName,
+			"ConfirmURL": app.emailURL("/account/delete/confirm?token=" + url.QueryEscape(token)),
+			"TTL":        "1 hour",
+		}
+
+		app.background(func() {
+			err := app.mailer.Send(user.Email, "email/account_delete.tmpl", data)
+			if err != nil {
+				app.logger.Error("Error sending account deletion confirmation", "error", err)
+			}
+		})
+
+		app.sessionManager.Put(r.Context(), "flash",
+			fmt.Sprintf("We've sent a link to %s. Your account will be deleted once you follow it.", user.Email))
+
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+
+	default:
+		form.CheckField(validator.NotBlank(form.Password), "password", "This field cannot be blank")
+
+		status := http.StatusUnprocessableEntity
+		if form.Valid() {
+			status, err = app.confirmPassword(r, user, &form.Validator, "password", form.Password)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+
+		if !form.Valid() {
+			app.renderAccountDelete(w, r, status, "account_delete.gohtml", form)
+			return
+		}
+	}
+
+	// Deleting the user forgets which sessions they had, so they're logged out
+	// everywhere else first.
+	err = app.destroyOtherSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.users.Delete(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditAccountDelete, user.ID, 0, map[string]string{"email": user.Email})
+
+	err = app.avatars.Delete(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.endSession(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(user.Email, "email/account_deleted.tmpl", map[string]any{"Name": user.FullName})
+		if err != nil {
+			app.logger.Error("Error sending account deletion email", "error", err)
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), "flash", "Your account has been deleted.")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// twoFactorLoginTTL is how long someone who got the password right has to
+// enter their second factor before they must start over.
+const twoFactorLoginTTL = 5 * time.Minute
+
+type twoFactorCodeForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) userLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if app.sessionManager.GetInt(r.Context(), "twoFactorUserID") == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = twoFactorCodeForm{}
+	app.render(w, r, http.StatusOK, "login_2fa.gohtml", data)
+}
+
+func (app *application) userLoginTwoFactorPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+	started := time.Unix(app.sessionManager.GetInt64(r.Context(), "twoFactorStarted"), 0)
+
+	if userID == 0 || app.now().Sub(started) > twoFactorLoginTTL {
+		app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+		app.sessionManager.Remove(r.Context(), "twoFactorStarted")
+		app.sessionManager.Remove(r.Context(), "twoFactorRememberMe")
+		app.sessionManager.Put(r.Context(), "flash", "Your login has expired. Please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.Disabled {
+		app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+		app.sessionManager.Remove(r.Context(), "twoFactorStarted")
+		app.sessionManager.Remove(r.Context(), "twoFactorRememberMe")
+		app.sessionManager.Put(r.Context(), "flash", accountDisabledMessage)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// Codes are short enough to guess, so wrong ones count towards the same
+	// lockout as wrong passwords.
+	if time.Now().Before(user.LockedUntil) {
+		app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+		app.sessionManager.Remove(r.Context(), "twoFactorStarted")
+		app.sessionManager.Remove(r.Context(), "twoFactorRememberMe")
+		app.sessionManager.Put(r.Context(), "flash", lockoutMessage(user.LockedUntil))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorCodeForm
+
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if form.Valid() {
+		ok, err := app.checkTwoFactorCode(userID, form.Code)
+		if err == nil && !ok {
+			app.auditLoginFailure(r, userID, "", "invalid two-factor code")
+			err = app.recordLoginFailure(r, user)
+		}
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(ok, "code", "This code is invalid")
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login_2fa.gohtml", data)
+		return
+	}
+
+	err = app.users.ResetLoginFailures(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.renewToken(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	rememberMe := app.sessionManager.PopBool(r.Context(), "twoFactorRememberMe")
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorStarted")
+
+	err = app.startSession(r, userID, rememberMe, "two-factor")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You successfully logged in.")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// checkTwoFactorCode reports whether code is either a current code from the
+// user's authenticator app or one of their unused recovery codes. Either kind
+// is used up by a successful check.
+func (app *application) checkTwoFactorCode(userID int, code string) (bool, error) {
+	secret, err := app.twoFactor.Secret(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(secret, code, app.now()); ok {
+		return app.twoFactor.UseStep(userID, step)
+	}
+
+	return app.twoFactor.UseRecoveryCode(userID, code)
+}
+
+func (app *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = twoFactorCodeForm{}
+
+	_, err := app.twoFactor.Secret(app.authenticatedUserID(r))
+	if err == nil {
+		data.TwoFactorEnabled = true
+		app.render(w, r, http.StatusOK, "two_factor.gohtml", data)
+		return
+	}
+	if !errors.Is(err, model.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The secret is kept in the session until the user proves their app has it,
+	// so that abandoning enrollment halfway never locks them out.
+	secret := app.sessionManager.GetString(r.Context(), "pendingTOTPSecret")
+	if secret == "" {
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "pendingTOTPSecret", secret)
+	}
+
+	data.Token = secret
+	app.render(w, r, http.StatusOK, "two_factor.gohtml", data)
+}
+
+func (app *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "pendingTOTPSecret")
+	if secret == "" {
+		app.notFound(w)
+		return
+	}
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	code, err := qr.Encode(totp.ProvisioningURI(secret, "Snippetbox", user.Email), qr.M)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(code.PNG())
+}
+
+// recoveryCodeCount is how many recovery codes are handed out when two-factor
+// authentication is enabled.
+const recoveryCodeCount = 10
+
+func (app *application) accountTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	secret := app.sessionManager.GetString(r.Context(), "pendingTOTPSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorCodeForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	step, ok := totp.Validate(secret, form.Code, app.now())
+	form.CheckField(ok, "code", "This code is invalid")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.Token = secret
+		app.render(w, r, http.StatusUnprocessableEntity, "two_factor.gohtml", data)
+		return
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	err = app.twoFactor.Enable(userID, secret, codes)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The code which was just used shouldn't also work for logging in.
+	_, err = app.twoFactor.UseStep(userID, step)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "pendingTOTPSecret")
+
+	// Recovery codes are only stored hashed, so this is the one time they can be
+	// shown.
+	data := app.newTemplateData(r)
+	data.Flash = "Two-factor authentication is now enabled."
+	data.TwoFactorEnabled = true
+	data.RecoveryCodes = codes
+	data.Form = twoFactorCodeForm{}
+	app.render(w, r, http.StatusOK, "two_factor.gohtml", data)
+}
+
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	var form twoFactorCodeForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	// Otherwise a stolen session could be used to guess codes without the
+	// lockouts that apply when logging in.
+	status := http.StatusUnprocessableEntity
+	if form.Valid() && app.checkLockout(r, user, &form.Validator) {
+		status = http.StatusTooManyRequests
+	}
+
+	if form.Valid() {
+		ok, err := app.checkTwoFactorCode(userID, form.Code)
+		if err == nil && !ok {
+			app.auditLoginFailure(r, userID, "", "invalid two-factor code")
+			err = app.recordLoginFailure(r, user)
+		}
+		if err != nil {
+			if errors.Is(err, model.ErrNoRecord) {
+				http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+		form.CheckField(ok, "code", "This code is invalid")
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.TwoFactorEnabled = true
+		app.render(w, r, status, "two_factor.gohtml", data)
+		return
+	}
+
+	err = app.twoFactor.Disable(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been disabled.")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// passkeyTimeout is how long the browser gives the user to complete a passkey
+// ceremony.
+const passkeyTimeout = 5 * time.Minute
+
+// base64URL is binary data which is sent to and from the browser as unpadded
+// base64url, the encoding WebAuthn uses.
+type base64URL []byte
+
+func (b base64URL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *base64URL) UnmarshalJSON(data []byte) error {
+	var s string
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	*b, err = base64.RawURLEncoding.DecodeString(s)
+
+	return err
+}
+
+type passkeyCredentialDescriptor struct {
+	Type string    `json:"type"`
+	ID   base64URL `json:"id"`
+}
+
+func (app *application) accountPasskeys(w http.ResponseWriter, r *http.Request) {
+	passkeys, err := app.passkeys.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Passkeys = passkeys
+
+	app.render(w, r, http.StatusOK, "passkeys.gohtml", data)
+}
+
+// accountPasskeyRegisterBeginPost returns the options for the browser's
+// navigator.credentials.create() call, keeping the challenge in the session.
+func (app *application) accountPasskeyRegisterBeginPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	passkeys, err := app.passkeys.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	rp, err := app.relyingParty(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "passkeyRegistrationChallenge", challenge)
+
+	// Listing the user's existing passkeys stops an authenticator from being
+	// registered twice.
+	exclude := []passkeyCredentialDescriptor{}
+	for _, p := range passkeys {
+		exclude = append(exclude, passkeyCredentialDescriptor{Type: "public-key", ID: p.ID})
+	}
+
+	app.writeJSON(w, r, http.StatusOK, map[string]any{
+		"challenge": base64URL(challenge),
+		"rp":        map[string]any{"id": rp.ID, "name": rp.Name},
+		"user": map[string]any{
+			"id":          base64URL(strconv.Itoa(user.ID)),
+			"name":        user.Email,
+			"displayName": user.FullName,
+		},
+		"pubKeyCredParams":   []map[string]any{{"type": "public-key", "alg": webauthn.AlgES256}},
+		"excludeCredentials": exclude,
+		"authenticatorSelection": map[string]any{
+			"residentKey":      "required",
+			"userVerification": "required",
+		},
+		"attestation": "none",
+		"timeout":     passkeyTimeout.Milliseconds(),
+	})
+}
+
+type passkeyRegistrationRequest struct {
+	Name              string    `json:"name"`
+	ClientDataJSON    base64URL `json:"clientDataJSON"`
+	AttestationObject base64URL `json:"attestationObject"`
+}
+
+func (app *application) accountPasskeyRegisterFinishPost(w http.ResponseWriter, r *http.Request) {
+	var input passkeyRegistrationRequest
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Each challenge can only be answered once.
+	challenge := app.sessionManager.PopBytes(r.Context(), "passkeyRegistrationChallenge")
+
+	rp, err := app.relyingParty(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	credential, err := rp.VerifyRegistration(challenge, input.ClientDataJSON, input.AttestationObject)
+	if err != nil {
+		app.logger.Warn("Passkey registration failed", "error", err)
+		app.writeJSON(w, r, http.StatusUnprocessableEntity, map[string]string{"error": "The passkey couldn't be verified."})
+		return
+	}
+
+	name := truncate(strings.TrimSpace(input.Name), 100)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	err = app.passkeys.Insert(app.authenticatedUserID(r), name, credential.ID, credential.PublicKey, credential.SignCount)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your passkey has been added.")
+
+	app.writeJSON(w, r, http.StatusOK, map[string]string{"redirect": "/account/passkeys"})
+}
+
+func (app *application) accountPasskeyDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := base64.RawURLEncoding.DecodeString(r.PathValue("id"))
+	if err != nil {
+		app.notFound(w)
+		return
+	}
+
+	err = app.passkeys.Delete(app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your passkey has been removed.")
+
+	http.Redirect(w, r, "/account/passkeys", http.StatusSeeOther)
+}
+
+// userLoginPasskeyBeginPost returns the options for the browser's
+// navigator.credentials.get() call. No credentials are listed, so the browser
+// offers whichever passkeys it has for the site and nobody can find out which
+// accounts have one.
+func (app *application) userLoginPasskeyBeginPost(w http.ResponseWriter, r *http.Request) {
+	rp, err := app.relyingParty(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "passkeyLoginChallenge", challenge)
+
+	app.writeJSON(w, r, http.StatusOK, map[string]any{
+		"challenge":        base64URL(challenge),
+		"rpId":             rp.ID,
+		"userVerification": "required",
+		"timeout":          passkeyTimeout.Milliseconds(),
+	})
+}
+
+type passkeyLoginRequest struct {
+	ID                base64URL `json:"id"`
+	ClientDataJSON    base64URL `json:"clientDataJSON"`
+	AuthenticatorData base64URL `json:"authenticatorData"`
+	Signature         base64URL `json:"signature"`
+	RememberMe        bool      `json:"rememberMe"`
+}
+
+func (app *application) userLoginPasskeyFinishPost(w http.ResponseWriter, r *http.Request) {
+	var input passkeyLoginRequest
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	challenge := app.sessionManager.PopBytes(r.Context(), "passkeyLoginChallenge")
+
+	rp, err := app.relyingParty(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	passkey, err := app.passkeys.Get(input.ID)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.writeJSON(w, r, http.StatusUnauthorized, map[string]string{"error": "This passkey isn't registered."})
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	credential := webauthn.Credential{ID: passkey.ID, PublicKey: passkey.PublicKey, SignCount: passkey.SignCount}
+
+	signCount, err := rp.VerifyLogin(challenge, credential, input.ClientDataJSON, input.AuthenticatorData, input.Signature)
+	if err != nil {
+		app.logger.Warn("Passkey login failed", "userID", passkey.UserID, "error", err)
+		app.auditLoginFailure(r, passkey.UserID, "", "invalid passkey")
+		app.writeJSON(w, r, http.StatusUnauthorized, map[string]string{"error": "The passkey couldn't be verified."})
+		return
+	}
+
+	err = app.passkeys.UpdateSignCount(passkey.ID, signCount)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	user, err := app.users.Get(passkey.UserID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.Disabled {
+		app.writeJSON(w, r, http.StatusForbidden, map[string]string{"error": accountDisabledMessage})
+		return
+	}
+
+	err = app.renewToken(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// A passkey proves possession of a device on top of whatever unlocked it, so
+	// it stands in for both the password and the two-factor code.
+	err = app.startSession(r, passkey.UserID, input.RememberMe, "passkey")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You successfully logged in.")
+
+	app.writeJSON(w, r, http.StatusOK, map[string]string{"redirect": "/"})
+}
+
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	token, err := app.userSessions.Delete(app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.sessionManager.Store.Delete(token)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The session has been logged out.")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) accountSessionRevokeOthersPost(w http.ResponseWriter, r *http.Request) {
+	err := app.destroyOtherSessions(r.Context(), app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "All your other sessions have been logged out.")
+
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// userLoginSSO sends the user to the identity provider to sign in. The state,
+// nonce and PKCE verifier stay in the session so the callback can check that
+// the response belongs to this login attempt.
+func (app *application) userLoginSSO(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	var values [3]string
+
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		values[i] = value
+	}
+
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := app.oidc.AuthCodeURL(r.Context(), app.ssoRedirectURL(r), state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+func (app *application) ssoRedirectURL(r *http.Request) string {
+	return app.baseURL(r) + "/user/login/sso/callback"
+}
+
+func (app *application) userLoginSSOCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+
+	query := r.URL.Query()
+
+	if query.Get("error") != "" {
+		app.logger.Warn("Single sign-on was refused", "error", query.Get("error"), "description", query.Get("error_description"))
+		app.sessionManager.Put(r.Context(), "flash", "Single sign-on was cancelled or refused.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		app.sessionManager.Put(r.Context(), "flash", "Your login has expired. Please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// A code the provider won't exchange, or a token which doesn't check out,
+	// is down to the request rather than to us, so it only needs the user to
+	// try again.
+	ssoFailed := func(err error) {
+		if errors.Is(err, oidc.ErrTokenExchange) || errors.Is(err, oidc.ErrInvalidToken) {
+			app.logger.Warn("Single sign-on failed", "error", err)
+			app.sessionManager.Put(r.Context(), "flash", "Single sign-on failed, please try again.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		app.serverError(w, r, err)
+	}
+
+	rawIDToken, err := app.oidc.Exchange(r.Context(), app.ssoRedirectURL(r), query.Get("code"), verifier)
+	if err != nil {
+		ssoFailed(err)
+		return
+	}
+
+	claims, err := app.oidc.Verify(r.Context(), rawIDToken, nonce)
+	if err != nil {
+		ssoFailed(err)
+		return
+	}
+
+	userID, err := app.ssoUser(r, claims)
+	if err != nil {
+		var flash string
+
+		switch {
+		case errors.Is(err, errSSOUnverifiedEmail):
+			flash = "Your email address must be verified with your identity provider before you can sign in with it."
+		case errors.Is(err, errSignupClosed):
+			flash = "Signup is closed, so only existing accounts can sign in."
+		case errors.Is(err, errSignupDomain):
+			flash = "Signup is limited to addresses at " + strings.Join(app.config.SignupDomains(), ", ") + "."
+		case errors.Is(err, errSignupInvite):
+			flash = "You need an invite for your email address to sign up."
+		}
+
+		if flash != "" {
+			app.sessionManager.Put(r.Context(), "flash", flash)
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		app.serverError(w, r, err)
+		return
+	}
+
+	user, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.Disabled {
+		app.sessionManager.Put(r.Context(), "flash", accountDisabledMessage)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = app.renewToken(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The provider only vouches for who the user is, so a two-factor code is
+	// still needed for accounts which have one.
+	_, err = app.twoFactor.Secret(userID)
+	if err == nil {
+		app.sessionManager.Put(r.Context(), "twoFactorUserID", userID)
+		app.sessionManager.Put(r.Context(), "twoFactorStarted", app.now().Unix())
+		app.sessionManager.Put(r.Context(), "twoFactorRememberMe", false)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	if !errors.Is(err, model.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.startSession(r, userID, false, "sso")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You successfully logged in.")
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+var errSSOUnverifiedEmail = errors.New("identity provider hasn't verified the email address")
+
+// ssoUser returns the user an identity provider account belongs to. Accounts
+// are matched by email address the first time they're used, and a new user is
+// created if there isn't one yet. Either way the address must have been
+// verified by the provider, or anyone could take over an account by signing
+// up at the provider with its address.
+func (app *application) ssoUser(r *http.Request, claims oidc.Claims) (int, error) {
+	user, err := app.users.GetByIdentity(claims.Issuer, claims.Subject)
+	if err == nil {
+		return user.ID, nil
+	}
+	if !errors.Is(err, model.ErrNoRecord) {
+		return 0, err
+	}
+
+	if !claims.EmailVerified || !validator.Matches(claims.Email, validator.EmailRX) {
+		return 0, errSSOUnverifiedEmail
+	}
+
+	user, err = app.users.GetByEmail(claims.Email)
+	if err == nil {
+		err = app.users.LinkIdentity(user.ID, claims.Issuer, claims.Subject)
+		if err != nil {
+			return 0, err
+		}
+
+		// The provider has confirmed the address, so there's no need to send
+		// a verification email as well.
+		if !user.Verified {
+			err = app.users.SetVerified(user.ID)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		return user.ID, nil
+	}
+	if !errors.Is(err, model.ErrNoRecord) {
+		return 0, err
+	}
+
+	// Signing in for the first time creates an account, so it's subject to
+	// the same restrictions as signing up.
+	invite, err := app.checkSignup(claims.Email, "")
+	if err != nil {
+		return 0, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	id, err := app.users.InsertFromIdentity(name, claims.Email, true, claims.Issuer, claims.Subject)
+	if err != nil {
+		return 0, err
+	}
+
+	if invite.ID != 0 {
+		err = app.invites.MarkUsed(invite.ID, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	app.audit(r, model.AuditSignup, id, 0, map[string]string{"email": claims.Email, "method": "sso"})
+
+	return id, nil
+}
+
+type apiTokenCreateForm struct {
+	Name                string `form:"name"`
+	Scope               string `form:"scope"`
+	Expires             int    `form:"expires"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) accountAPITokens(w http.ResponseWriter, r *http.Request) {
+	app.renderAPITokens(w, r, http.StatusOK, apiTokenCreateForm{Scope: model.APIScopeRead, Expires: 30}, "")
+}
+
+// renderAPITokens shows the user's API tokens. plaintext is a token which was
+// just created, or empty.
+func (app *application) renderAPITokens(w http.ResponseWriter, r *http.Request, status int, form apiTokenCreateForm, plaintext string) {
+	tokens, err := app.apiTokens.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.APITokens = tokens
+	data.Token = plaintext
+
+	app.render(w, r, status, "api_tokens.gohtml", data)
+}
+
+func (app *application) accountAPITokenCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form apiTokenCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.PermittedValue(form.Scope, model.APIScopeRead, model.APIScopeWrite), "scope", "This field must be read or write")
+	form.CheckField(validator.PermittedValue(form.Expires, 7, 30, 90, 365), "expires", "This field must equal 7, 30, 90 or 365")
+
+	if !form.Valid() {
+		app.renderAPITokens(w, r, http.StatusUnprocessableEntity, form, "")
+		return
+	}
+
+	plaintext, err := app.apiTokens.Insert(app.authenticatedUserID(r), form.Name, form.Scope, time.Duration(form.Expires)*24*time.Hour)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The token is shown straight away rather than after a redirect, so that
+	// it's never stored in the session.
+	app.renderAPITokens(w, r, http.StatusOK, apiTokenCreateForm{Scope: form.Scope, Expires: form.Expires}, plaintext)
+}
+
+func (app *application) accountAPITokenDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.apiTokens.Delete(app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your API token has been revoked.")
+
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
+
+// apiSnippet is how snippets are represented in the API.
+type apiSnippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Author  string    `json:"author,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	URL     string    `json:"url"`
+}
+
+func (app *application) newAPISnippet(r *http.Request, s model.Snippet) apiSnippet {
+	return apiSnippet{
+		ID:      s.ID,
+		Title:   s.Title,
+		Content: s.Content,
+		Author:  s.Author,
+		Created: s.Created,
+		Expires: s.Expires,
+		URL:     fmt.Sprintf("%s/snippets/view/%d", app.baseURL(r), s.ID),
+	}
+}
+
+func (app *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	snippets, err := app.snippets.Latest(limit)
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+		return
+	}
+
+	list := make([]apiSnippet, 0, len(snippets))
+	for _, s := range snippets {
+		list = append(list, app.newAPISnippet(r, s))
+	}
+
+	app.writeJSON(w, r, http.StatusOK, map[string]any{"snippets": list})
+}
+
+func (app *application) apiSnippetView(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, r, http.StatusNotFound, "Snippet not found.")
+		return
+	}
+
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.errorJSON(w, r, http.StatusNotFound, "Snippet not found.")
+		} else {
+			app.serverErrorJSON(w, r, err)
+		}
+		return
+	}
+
+	canView, err := app.canViewSnippet(app.apiToken(r).UserID, snippet)
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+		return
+	}
+
+	if !canView {
+		app.errorJSON(w, r, http.StatusNotFound, "Snippet not found.")
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, map[string]any{"snippet": app.newAPISnippet(r, snippet)})
+}
+
+type apiSnippetCreateRequest struct {
+	Title               string `json:"title"`
+	Content             string `json:"content"`
+	Expires             int    `json:"expires"`
+	validator.Validator `json:"-"`
+}
+
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	var input apiSnippetCreateRequest
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJSON(w, r, http.StatusBadRequest, "The request body must be a JSON object with title, content and expires.")
+		return
+	}
+
+	user, err := app.users.Get(app.apiToken(r).UserID)
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+		return
+	}
+
+	if !user.Verified {
+		app.errorJSON(w, r, http.StatusForbidden, "Please verify your email address first.")
+		return
+	}
+
+	input.CheckField(validator.NotBlank(input.Title), "title", "This field cannot be blank")
+	input.CheckField(validator.MaxChars(input.Title, 100), "title", "This field cannot be more than 100 characters long")
+	input.CheckField(validator.NotBlank(input.Content), "content", "This field cannot be blank")
+	input.CheckField(validator.PermittedValue(input.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+
+	if !input.Valid() {
+		app.writeJSON(w, r, http.StatusUnprocessableEntity, map[string]any{"errors": input.FieldErrors})
+		return
+	}
+
+	id, err := app.snippets.Insert(user.ID, 0, input.Title, input.Content, input.Expires, model.VisibilityPublic)
+	if err != nil {
+		app.serverErrorJSON(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditSnippetCreate, user.ID, 0, map[string]string{"snippet": strconv.Itoa(id), "title": input.Title,
+		"api_token": strconv.Itoa(app.apiToken(r).ID)})
+
+	now := time.Now().UTC().Truncate(time.Second)
+
+	snippet := model.Snippet{
+		ID:      id,
+		UserID:  user.ID,
+		Author:  user.FullName,
+		Title:   input.Title,
+		Content: input.Content,
+		Created: now,
+		Expires: now.AddDate(0, 0, input.Expires),
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/snippets/%d", id))
+
+	app.writeJSON(w, r, http.StatusCreated, map[string]any{"snippet": app.newAPISnippet(r, snippet)})
+}
+
+// accountDisabledMessage is shown to users of disabled accounts when they try
+// to log in.
+const accountDisabledMessage = "This account has been disabled."
+
+// adminListLimit is how many users or snippets the admin area lists at once.
+// Filters narrow the lists down further.
+const adminListLimit = 100
+
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.stats.Get()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Stats = stats
+
+	app.render(w, r, http.StatusOK, "admin.gohtml", data)
+}
+
+type adminUserFilterForm struct {
+	Query    string `form:"q"`
+	Role     string `form:"role"`
+	Disabled bool   `form:"disabled"`
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	var form adminUserFilterForm
+
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	users, err := app.users.List(model.UserFilter{Query: form.Query, Role: form.Role, Disabled: form.Disabled}, adminListLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Users = users
+	data.User = app.authenticatedUser(r)
+
+	app.render(w, r, http.StatusOK, "admin_users.gohtml", data)
+}
+
+// adminTargetUser returns the user an admin action is about. Admins can't act
+// on their own account, so that they can't lock themselves out of the admin
+// area by mistake.
+func (app *application) adminTargetUser(w http.ResponseWriter, r *http.Request) (user model.User, ok bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return model.User{}, false
+	}
+
+	if id == app.authenticatedUserID(r) {
+		app.sessionManager.Put(r.Context(), "flash", "You can't change your own account from here.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return model.User{}, false
+	}
+
+	user, err = app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return model.User{}, false
+	}
+
+	return user, true
+}
+
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.users.SetDisabled(user.ID, true)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The admin's own session belongs to someone else, so this logs the user
+	// out everywhere.
+	err = app.destroyOtherSessions(r.Context(), user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditAdminUserDisable, app.authenticatedUserID(r), user.ID, nil)
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s's account has been disabled.", user.FullName))
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminUserEnablePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.users.SetDisabled(user.ID, false)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditAdminUserEnable, app.authenticatedUserID(r), user.ID, nil)
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s's account has been enabled.", user.FullName))
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminUserUnlockPost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.users.ResetLoginFailures(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditAdminUserUnlock, app.authenticatedUserID(r), user.ID, nil)
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s's account has been unlocked.", user.FullName))
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+type adminUserRoleForm struct {
+	Role string `form:"role"`
+}
+
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.adminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var form adminUserRoleForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil || !model.ValidRole(form.Role) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetRole(user.ID, form.Role)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditAdminUserRole, app.authenticatedUserID(r), user.ID, map[string]string{"from": user.Role, "to": form.Role})
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s is now a %s.", user.FullName, form.Role))
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+type adminSnippetFilterForm struct {
+	Query   string `form:"q"`
+	Author  string `form:"author"`
+	Expired bool   `form:"expired"`
+}
+
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	var form adminSnippetFilterForm
+
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, err := app.snippets.List(model.SnippetFilter{Query: form.Query, Author: form.Author, Expired: form.Expired}, adminListLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Snippets = snippets
+
+	app.render(w, r, http.StatusOK, "admin_snippets.gohtml", data)
+}
+
+func (app *application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.snippets.Delete(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, model.AuditSnippetDelete, app.authenticatedUserID(r), 0, map[string]string{"snippet": strconv.Itoa(id)})
+
+	app.sessionManager.Put(r.Context(), "flash", "The snippet has been deleted.")
+
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+// inviteTTL is how long an invite stays usable.
+const inviteTTL = 7 * 24 * time.Hour
+
+type adminInviteForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) adminInvites(w http.ResponseWriter, r *http.Request) {
+	app.renderInvites(w, r, http.StatusOK, adminInviteForm{}, "")
+}
+
+// renderInvites shows the invites page. inviteURL is the signup link for an
+// invite which was just issued, if there is one.
+func (app *application) renderInvites(w http.ResponseWriter, r *http.Request, status int, form adminInviteForm, inviteURL string) {
+	invites, err := app.invites.List()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Invites = invites
+	data.Token = inviteURL
+
+	app.render(w, r, status, "admin_invites.gohtml", data)
+}
+
+func (app *application) adminInviteCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form adminInviteForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		app.renderInvites(w, r, http.StatusUnprocessableEntity, form, "")
+		return
+	}
+
+	code, err := app.invites.Insert(form.Email, app.authenticatedUserID(r), inviteTTL)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, model.AuditAdminInviteCreate, app.authenticatedUserID(r), 0, map[string]string{"email": form.Email})
+
+	inviteURL := app.emailURL("/user/signup?invite=" + url.QueryEscape(code))
+
+	data := map[string]any{
+		"InvitedBy": app.authenticatedUser(r).FullName,
+		"SignupURL": inviteURL,
+		"TTL":       "7 days",
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(form.Email, "email/invite.tmpl", data)
+		if err != nil {
+			app.logger.Error("Error sending invite email", "error", err)
+		}
+	})
+
+	// The link is shown straight away as well, so that it can be passed on
+	// another way if the email doesn't arrive.
+	app.renderInvites(w, r, http.StatusOK, adminInviteForm{}, inviteURL)
+}
+
+func (app *application) adminInviteDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.invites.Delete(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, model.AuditAdminInviteDelete, app.authenticatedUserID(r), 0, map[string]string{"invite": strconv.Itoa(id)})
+
+	app.sessionManager.Put(r.Context(), "flash", "The invite has been revoked.")
+
+	http.Redirect(w, r, "/admin/invites", http.StatusSeeOther)
+}
+
+// auditExportLimit caps how many events an export holds, so that it can be
+// built in memory. Older events can be exported by narrowing the dates.
+const auditExportLimit = 100_000
+
+type adminAuditFilterForm struct {
+	Action string `form:"action"`
+	User   string `form:"user"`
+	IP     string `form:"ip"`
+	// From and To are dates, both of which are included.
+	From   string `form:"from"`
+	To     string `form:"to"`
+	Format string `form:"format"`
+}
+
+// auditFilter reads the audit log's filters from the query string. ok is false
+// if they're malformed, in which case an error response has been written.
+func (app *application) auditFilter(w http.ResponseWriter, r *http.Request) (form adminAuditFilterForm, filter model.AuditFilter, ok bool) {
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return form, filter, false
+	}
+
+	filter = model.AuditFilter{Action: form.Action, User: strings.TrimSpace(form.User), IP: strings.TrimSpace(form.IP)}
+
+	if form.From != "" {
+		filter.From, err = time.Parse(time.DateOnly, form.From)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return form, filter, false
+		}
+	}
+
+	if form.To != "" {
+		to, err := time.Parse(time.DateOnly, form.To)
+		if err != nil {
+			app.clientError(w, http.StatusBadRequest)
+			return form, filter, false
+		}
+		filter.Until = to.AddDate(0, 0, 1)
+	}
+
+	return form, filter, true
+}
+
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	form, filter, ok := app.auditFilter(w, r)
+	if !ok {
+		return
+	}
+
+	events, err := app.auditLog.List(filter, adminListLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.AuditEvents = events
+	data.AuditActions = model.AuditActions
+
+	app.render(w, r, http.StatusOK, "admin_audit.gohtml", data)
+}
+
+// auditExportEvent is how an event is written to a JSON lines export.
+type auditExportEvent struct {
+	ID          int               `json:"id"`
+	Time        time.Time         `json:"time"`
+	Action      string            `json:"action"`
+	ActorID     int               `json:"actor_id,omitempty"`
+	ActorEmail  string            `json:"actor_email,omitempty"`
+	TargetID    int               `json:"target_id,omitempty"`
+	TargetEmail string            `json:"target_email,omitempty"`
+	IP          string            `json:"ip"`
+	UserAgent   string            `json:"user_agent"`
+	Details     map[string]string `json:"details"`
+}
+
+// adminAuditExport downloads the events matching the filters as CSV or JSON
+// lines, newest first.
+func (app *application) adminAuditExport(w http.ResponseWriter, r *http.Request) {
+	form, filter, ok := app.auditFilter(w, r)
+	if !ok {
+		return
+	}
+
+	if form.Format != "csv" && form.Format != "jsonl" {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	events, err := app.auditLog.List(filter, auditExportLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var buf bytes.Buffer
+
+	contentType := "text/csv; charset=utf-8"
+	if form.Format == "csv" {
+		err = writeAuditCSV(&buf, events)
+	} else {
+		contentType = "application/x-ndjson"
+		err = writeAuditJSONLines(&buf, events)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	filename := fmt.Sprintf("audit-log-%s.%s", time.Now().UTC().Format("2006-01-02"), form.Format)
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+}
+
+func writeAuditCSV(w io.Writer, events []model.AuditEvent) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write([]string{"id", "time", "action", "actor_id", "actor_email", "target_id", "target_email", "ip", "user_agent", "details"})
+	if err != nil {
+		return err
+	}
+
+	optionalID := func(id int) string {
+		if id == 0 {
+			return ""
+		}
+		return strconv.Itoa(id)
+	}
+
+	for _, e := range events {
+		details, err := json.Marshal(e.Details)
+		if err != nil {
+			return err
+		}
+
+		record := []string{
+			strconv.Itoa(e.ID),
+			e.Created.UTC().Format(time.RFC3339),
+			e.Action,
+			optionalID(e.ActorID),
+			csvCell(e.ActorEmail),
+			optionalID(e.TargetID),
+			csvCell(e.TargetEmail),
+			csvCell(e.IP),
+			csvCell(e.UserAgent),
+			string(details),
+		}
+
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// csvCell defuses a value which a spreadsheet would take for a formula, such
+// as a user agent of "=HYPERLINK(...)", by making it start with a quote.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+func writeAuditJSONLines(w io.Writer, events []model.AuditEvent) error {
+	enc := json.NewEncoder(w)
+
+	for _, e := range events {
+		details := e.Details
+		if details == nil {
+			details = map[string]string{}
+		}
+
+		err := enc.Encode(auditExportEvent{
+			ID:          e.ID,
+			Time:        e.Created.UTC(),
+			Action:      e.Action,
+			ActorID:     e.ActorID,
+			ActorEmail:  e.ActorEmail,
+			TargetID:    e.TargetID,
+			TargetEmail: e.TargetEmail,
+			IP:          e.IP,
+			UserAgent:   e.UserAgent,
+			Details:     details,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// orgSnippetLimit is how many snippets an organization's page lists.
+const orgSnippetLimit = 100
+
+type orgCreateForm struct {
+	Name                string `form:"name"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) orgList(w http.ResponseWriter, r *http.Request) {
+	app.renderOrgList(w, r, http.StatusOK, orgCreateForm{})
+}
+
+func (app *application) renderOrgList(w http.ResponseWriter, r *http.Request, status int, form orgCreateForm) {
+	orgs, err := app.organizations.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Organizations = orgs
+
+	app.render(w, r, status, "orgs.gohtml", data)
+}
+
+func (app *application) orgCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form orgCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+
+	if !form.Valid() {
+		app.renderOrgList(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	id, err := app.organizations.Insert(form.Name, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Organization successfully created!")
+
+	http.Redirect(w, r, fmt.Sprintf("/orgs/view/%d", id), http.StatusSeeOther)
+}
+
+type orgMemberForm struct {
+	Email               string `form:"email"`
+	Role                string `form:"role"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) showOrg(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	org, err := app.organizations.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.renderOrg(w, r, http.StatusOK, org, orgMemberForm{Role: model.OrgRoleMember})
+}
+
+// renderOrg shows the organization's page. Members see all of its snippets
+// and who else belongs to it; everyone else only sees its public snippets.
+func (app *application) renderOrg(w http.ResponseWriter, r *http.Request, status int, org model.Organization, form orgMemberForm) {
+	role, err := app.orgRole(org.ID, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	snippets, err := app.snippets.ForOrganization(org.ID, role != "", orgSnippetLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	var members []model.OrganizationMember
+
+	if role != "" {
+		members, err = app.organizations.Members(org.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Organization = org
+	data.OrgRole = role
+	data.Snippets = snippets
+	data.Members = members
+	data.Form = form
+	data.User = app.authenticatedUser(r)
+
+	app.render(w, r, status, "org_view.gohtml", data)
+}
+
+// orgMembership loads the organization named by the {id} path value along
+// with its members, and makes sure the current user belongs to it. If they
+// don't, an error response has already been written and ok is false.
+func (app *application) orgMembership(w http.ResponseWriter, r *http.Request) (org model.Organization, members []model.OrganizationMember, role string, ok bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return model.Organization{}, nil, "", false
+	}
+
+	org, err = app.organizations.Get(id)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return model.Organization{}, nil, "", false
+	}
+
+	members, err = app.organizations.Members(org.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return model.Organization{}, nil, "", false
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	for _, m := range members {
+		if m.UserID == userID {
+			return org, members, m.Role, true
+		}
+	}
+
+	app.clientError(w, http.StatusForbidden)
+	return model.Organization{}, nil, "", false
+}
+
+// lastOwner reports whether userID is the only owner among members. An
+// organization always keeps at least one owner, so that someone can manage it.
+func lastOwner(members []model.OrganizationMember, userID int) bool {
+	owners := 0
+	isOwner := false
+
+	for _, m := range members {
+		if m.Role == model.OrgRoleOwner {
+			owners++
+			isOwner = isOwner || m.UserID == userID
+		}
+	}
+
+	return isOwner && owners == 1
+}
+
+// orgInviteTTL is how long an invitation to join an organization stays usable.
+const orgInviteTTL = 7 * 24 * time.Hour
+
+// orgInvitePost emails an invitation to join the organization, which the
+// recipient has to accept. The invitation is sent and the response is the same
+// whether or not the address has an account, so that owners can't use it to
+// find out who has signed up.
+func (app *application) orgInvitePost(w http.ResponseWriter, r *http.Request) {
+	org, _, role, ok := app.orgMembership(w, r)
+	if !ok {
+		return
+	}
+
+	if role != model.OrgRoleOwner {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	var form orgMemberForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "This field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRX), "email", "This field must be a valid email address")
+	form.CheckField(validator.PermittedValue(form.Role, model.OrgRoleOwner, model.OrgRoleMember), "role", "This field must be owner or member")
+
+	if !form.Valid() {
+		app.renderOrg(w, r, http.StatusUnprocessableEntity, org, form)
+		return
+	}
+
+	code, err := app.invites.InsertForOrganization(org.ID, form.Role, form.Email, app.authenticatedUserID(r), orgInviteTTL)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := map[string]any{
+		"InvitedBy":    app.authenticatedUser(r).FullName,
+		"Organization": org.Name,
+		"AcceptURL":    app.emailURL("/orgs/invites/accept?code=" + url.QueryEscape(code)),
+		"TTL":          "7 days",
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(form.Email, "email/org_invite.tmpl", data)
+		if err != nil {
+			app.logger.Error("Error sending organization invite email", "error", err)
+		}
+	})
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("An invitation to join has been sent to %s.", form.Email))
+
+	http.Redirect(w, r, fmt.Sprintf("/orgs/view/%d", org.ID), http.StatusSeeOther)
+}
+
+type orgInviteAcceptForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// orgInvite loads the organization invite with code, and makes sure it was
+// sent to the current user's email address. If it wasn't, or it can't be used,
+// the user has already been redirected and ok is false.
+func (app *application) orgInvite(w http.ResponseWriter, r *http.Request, code string) (invite model.Invite, org model.Organization, ok bool) {
+	const invalidInvite = "This invitation is invalid, has expired or was sent to another email address."
+
+	invite, err := app.invites.GetForOrganization(code)
+	if err == nil && !strings.EqualFold(invite.Email, app.authenticatedUser(r).Email) {
+		err = model.ErrNoRecord
+	}
+	if err == nil {
+		org, err = app.organizations.Get(invite.OrganizationID)
+	}
+
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", invalidInvite)
+			http.Redirect(w, r, "/orgs", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return model.Invite{}, model.Organization{}, false
+	}
+
+	return invite, org, true
+}
+
+func (app *application) orgInviteAccept(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+
+	invite, org, ok := app.orgInvite(w, r, code)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Organization = org
+	data.OrgRole = invite.OrganizationRole
+	data.Form = orgInviteAcceptForm{Code: code}
+
+	app.render(w, r, http.StatusOK, "org_invite.gohtml", data)
+}
+
+func (app *application) orgInviteAcceptPost(w http.ResponseWriter, r *http.Request) {
+	var form orgInviteAcceptForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	invite, org, ok := app.orgInvite(w, r, form.Code)
+	if !ok {
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+
+	// Using up the invite first means it can't be accepted twice at once.
+	err = app.invites.MarkUsed(invite.ID, userID)
+	if err == nil {
+		err = app.organizations.AddMember(org.ID, userID, invite.OrganizationRole)
+	}
+
+	switch {
+	case errors.Is(err, model.ErrNoRecord):
+		app.sessionManager.Put(r.Context(), "flash", "This invitation has already been used.")
+	case errors.Is(err, model.ErrDuplicateMember):
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You're already a member of %s.", org.Name))
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	default:
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You've joined %s.", org.Name))
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/orgs/view/%d", org.ID), http.StatusSeeOther)
+}
+
+func (app *application) orgMemberRolePost(w http.ResponseWriter, r *http.Request) {
+	org, members, role, ok := app.orgMembership(w, r)
+	if !ok {
+		return
+	}
+
+	if role != model.OrgRoleOwner {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil || userID < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	newRole := r.PostForm.Get("role")
+	if !validator.PermittedValue(newRole, model.OrgRoleOwner, model.OrgRoleMember) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if newRole != model.OrgRoleOwner && lastOwner(members, userID) {
+		app.sessionManager.Put(r.Context(), "flash", "An organization needs at least one owner.")
+		http.Redirect(w, r, fmt.Sprintf("/orgs/view/%d", org.ID), http.StatusSeeOther)
+		return
+	}
+
+	err = app.organizations.SetMemberRole(org.ID, userID, newRole)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Member's role has been changed.")
+
+	http.Redirect(w, r, fmt.Sprintf("/orgs/view/%d", org.ID), http.StatusSeeOther)
+}
+
+// orgMemberRemovePost takes someone out of an organization. Owners can remove
+// anyone, and members can remove themselves to leave.
+func (app *application) orgMemberRemovePost(w http.ResponseWriter, r *http.Request) {
+	org, members, role, ok := app.orgMembership(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil || userID < 1 {
+		app.notFound(w)
+		return
+	}
+
+	self := userID == app.authenticatedUserID(r)
+
+	if role != model.OrgRoleOwner && !self {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	if lastOwner(members, userID) {
+		app.sessionManager.Put(r.Context(), "flash", "An organization needs at least one owner.")
+		http.Redirect(w, r, fmt.Sprintf("/orgs/view/%d", org.ID), http.StatusSeeOther)
+		return
+	}
+
+	err = app.organizations.RemoveMember(org.ID, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if self {
+		app.sessionManager.Put(r.Context(), "flash", "You have left the organization.")
+		http.Redirect(w, r, "/orgs", http.StatusSeeOther)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Member has been removed.")
+
+	http.Redirect(w, r, fmt.Sprintf("/orgs/view/%d", org.ID), http.StatusSeeOther)
 }
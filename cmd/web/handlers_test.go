@@ -1,13 +1,39 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
 	"net/http"
 	"net/url"
+	"regexp"
+	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/go-playground/assert"
+	"github.com/thisisjab/snippetbox-go/internal/mailer"
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"github.com/thisisjab/snippetbox-go/internal/model/mock"
+	"github.com/thisisjab/snippetbox-go/internal/ogimage"
+	"github.com/thisisjab/snippetbox-go/internal/oidc"
+	"github.com/thisisjab/snippetbox-go/internal/oidc/oidctest"
+	"github.com/thisisjab/snippetbox-go/internal/totp"
+	"github.com/thisisjab/snippetbox-go/internal/validator"
+	"github.com/thisisjab/snippetbox-go/internal/webauthn/webauthntest"
+	"github.com/thisisjab/snippetbox-go/ui"
 )
 
 func TestPing(t *testing.T) {
@@ -197,3 +223,2497 @@ func TestUserSignup(t *testing.T) {
     }
 
 }
+
+func TestCollectionView(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid ID",
+			urlPath:  "/collections/view/1",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/collections/view/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "String ID",
+			urlPath:  "/collections/view/foo",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.MatchRegex(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCollectionReorder(t *testing.T) {
+	app := newTestApplication(t)
+
+	collections := &reorderCollectionModel{}
+	app.collections = collections
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/collections/edit/1")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name      string
+		positions map[string]string
+		wantCode  int
+		wantOrder []int
+	}{
+		{
+			name:      "Every member",
+			positions: map[string]string{"1": "2", "2": "1"},
+			wantCode:  http.StatusSeeOther,
+			wantOrder: []int{2, 1},
+		},
+		{
+			name:      "Member left out",
+			positions: map[string]string{"2": "1"},
+			wantCode:  http.StatusBadRequest,
+		},
+		{
+			name:      "Non-member",
+			positions: map[string]string{"1": "2", "3": "1"},
+			wantCode:  http.StatusBadRequest,
+		},
+		{
+			name:      "Extra non-member",
+			positions: map[string]string{"1": "2", "2": "1", "3": "3"},
+			wantCode:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collections.order = nil
+
+			form := url.Values{}
+			for id, position := range tt.positions {
+				form.Add("position["+id+"]", position)
+			}
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, "/collections/edit/1/reorder", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, collections.order, tt.wantOrder)
+		})
+	}
+}
+
+// reorderCollectionModel has a second snippet in the mock collection and
+// remembers the order it was last saved in.
+type reorderCollectionModel struct {
+	mock.CollectionModel
+	order []int
+}
+
+func (m *reorderCollectionModel) Get(id int) (model.Collection, error) {
+	collection, err := m.CollectionModel.Get(id)
+	if err != nil {
+		return collection, err
+	}
+
+	second := collection.Snippets[0]
+	second.ID = 2
+	collection.Snippets = append(slices.Clone(collection.Snippets), second)
+	return collection, nil
+}
+
+func (m *reorderCollectionModel) Reorder(collectionID int, snippetIDs []int) error {
+	m.order = snippetIDs
+	return nil
+}
+
+func TestSnippetEmbed(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Iframe", func(t *testing.T) {
+		code, headers, body := ts.get(t, "/snippets/embed/1")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, headers.Get("X-Frame-Options"), "")
+		assert.MatchRegex(t, headers.Get("Content-Security-Policy"), "frame-ancestors \\*")
+		assert.MatchRegex(t, body, "An old silent pond...")
+	})
+
+	t.Run("Script", func(t *testing.T) {
+		code, headers, body := ts.get(t, "/snippets/embed/1/script.js")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, headers.Get("Content-Type"), "text/javascript; charset=utf-8")
+		assert.MatchRegex(t, body, ts.URL+"/snippets/embed/1")
+	})
+
+	t.Run("Other pages can't be framed", func(t *testing.T) {
+		_, headers, _ := ts.get(t, "/snippets/view/1")
+
+		assert.Equal(t, headers.Get("X-Frame-Options"), "deny")
+	})
+
+	t.Run("Non-existent ID", func(t *testing.T) {
+		code, _, _ := ts.get(t, "/snippets/embed/2")
+
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestOEmbed(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		target   string
+		format   string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid URL",
+			target:   ts.URL + "/snippets/view/1",
+			wantCode: http.StatusOK,
+			wantBody: `"author_name":"Alice"`,
+		},
+		{
+			name:     "JSON format",
+			target:   ts.URL + "/snippets/view/1",
+			format:   "json",
+			wantCode: http.StatusOK,
+			wantBody: `"type":"rich"`,
+		},
+		{
+			name:     "XML format",
+			target:   ts.URL + "/snippets/view/1",
+			format:   "xml",
+			wantCode: http.StatusNotImplemented,
+		},
+		{
+			name:     "Non-existent ID",
+			target:   ts.URL + "/snippets/view/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Other site",
+			target:   "https://example.com/snippets/view/1",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Not a snippet",
+			target:   ts.URL + "/user/login",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			query.Add("url", tt.target)
+			if tt.format != "" {
+				query.Add("format", tt.format)
+			}
+
+			code, _, body := ts.get(t, "/oembed?"+query.Encode())
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				if !strings.Contains(body, tt.wantBody) {
+					t.Fatalf("Wanted %v, but got %v.", tt.wantBody, body)
+				}
+			}
+		})
+	}
+}
+
+func TestSnippetPreviewImage(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, body := ts.get(t, "/snippets/view/1/og.png")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "image/png")
+
+	img, err := png.Decode(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, img.Bounds().Dx(), ogimage.Width)
+	assert.Equal(t, img.Bounds().Dy(), ogimage.Height)
+
+	code, _, _ = ts.get(t, "/snippets/view/2/og.png")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestAccountPasswordUpdate(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+	other.login(t, "alice@example.com", "pa$$word")
+
+	code, _, body := ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+	assert.MatchRegex(t, body, "alice@example.com")
+
+	_, _, body = ts.get(t, "/account/password/update")
+	csrfToken := extractCSRFToken(t, body)
+
+	breachedHash := fmt.Sprintf("%X", sha1.Sum([]byte("Breached!Pa55word")))
+	app.passwordPolicy.Breached = validator.NewBreachedPasswords(fstest.MapFS{
+		breachedHash[:5] + ".txt": {Data: []byte(breachedHash[5:] + ":42\r\n")},
+	})
+
+	tests := []struct {
+		name, currentPassword, newPassword, confirmation string
+		wantCode                                         int
+	}{
+		{
+			name:            "Wrong current password",
+			currentPassword: "wrong",
+			newPassword:     "newPa$$word",
+			confirmation:    "newPa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "Short new password",
+			currentPassword: "pa$$word",
+			newPassword:     "tiny",
+			confirmation:    "tiny",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "Guessable new password",
+			currentPassword: "pa$$word",
+			newPassword:     "abcdefgh1234",
+			confirmation:    "abcdefgh1234",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "New password contains name",
+			currentPassword: "pa$$word",
+			newPassword:     "AliceRocks!2024",
+			confirmation:    "AliceRocks!2024",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "Breached new password",
+			currentPassword: "pa$$word",
+			newPassword:     "Breached!Pa55word",
+			confirmation:    "Breached!Pa55word",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "Mismatched confirmation",
+			currentPassword: "pa$$word",
+			newPassword:     "newPa$$word",
+			confirmation:    "otherPa$$word",
+			wantCode:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:            "Valid submission",
+			currentPassword: "pa$$word",
+			newPassword:     "newPa$$word",
+			confirmation:    "newPa$$word",
+			wantCode:        http.StatusSeeOther,
+		},
+	}
+
+	update := func(t *testing.T, currentPassword, newPassword, confirmation string) (int, string) {
+		form := url.Values{}
+		form.Add("currentPassword", currentPassword)
+		form.Add("newPassword", newPassword)
+		form.Add("newPasswordConfirmation", confirmation)
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.postForm(t, "/account/password/update", form)
+		return code, body
+	}
+
+	t.Run("Lockout", func(t *testing.T) {
+		defer app.users.ResetLoginFailures(1)
+
+		for range accountLockoutThreshold {
+			code, body := update(t, "wrong", "newPa$$word", "newPa$$word")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.MatchRegex(t, body, "Password is incorrect")
+		}
+
+		code, body := update(t, "pa$$word", "newPa$$word", "newPa$$word")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.MatchRegex(t, body, "Too many failed login attempts")
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := update(t, tt.currentPassword, tt.newPassword, tt.confirmation)
+
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	// The session used to change the password stays logged in, the other one
+	// has been logged out.
+	code, _, _ = ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+
+	code, headers, _ := other.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusFound)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+}
+
+func TestUserResetPassword(t *testing.T) {
+	app := newTestApplication(t)
+
+	var mail bytes.Buffer
+	app.mailer = mailer.NewLog(ui.Files, &mail, "test@example.com")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/forgot")
+	csrfToken := extractCSRFToken(t, body)
+
+	t.Run("Unknown email", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("email", "nobody@example.com")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/user/forgot", form)
+		app.wg.Wait()
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, mail.Len(), 0)
+	})
+
+	t.Run("Known email", func(t *testing.T) {
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, _ := ts.postForm(t, "/user/forgot", form)
+		app.wg.Wait()
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.MatchRegex(t, mail.String(), "To: alice@example.com")
+		assert.MatchRegex(t, mail.String(), "/user/reset/"+mock.MockToken)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/user/reset/INVALID")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/forgot")
+	})
+
+	t.Run("Valid token", func(t *testing.T) {
+		code, _, body := ts.get(t, "/user/reset/"+mock.MockToken)
+		assert.Equal(t, code, http.StatusOK)
+
+		form := url.Values{}
+		form.Add("newPassword", "newPa$$word")
+		form.Add("newPasswordConfirmation", "newPa$$word")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, headers, _ := ts.postForm(t, "/user/reset/"+mock.MockToken, form)
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+}
+
+func TestUserResetPasswordForgedHost(t *testing.T) {
+	t.Setenv("BASE_URL", "https://snippets.example.com")
+	app := newTestApplication(t)
+
+	var mail bytes.Buffer
+	app.mailer = mailer.NewLog(ui.Files, &mail, "test@example.com")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/forgot")
+
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/user/forgot", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Host = "evil.example"
+
+	// The cookie jar would look the cookies up for the forged host.
+	for _, cookie := range ts.Client().Jar.Cookies(req.URL) {
+		req.AddCookie(cookie)
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	app.wg.Wait()
+
+	assert.Equal(t, rs.StatusCode, http.StatusSeeOther)
+	assert.MatchRegex(t, mail.String(), "https://snippets.example.com/user/reset/"+mock.MockToken)
+	assert.Equal(t, strings.Contains(mail.String(), "evil.example"), false)
+}
+
+func TestUserVerify(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	validToken := app.signer.Sign(verificationPurpose, "2:bob@example.com", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name      string
+		token     string
+		wantFlash string
+	}{
+		{
+			name:      "Valid token",
+			token:     validToken,
+			wantFlash: "Your email address has been verified.",
+		},
+		{
+			name:      "Expired token",
+			token:     app.signer.Sign(verificationPurpose, "2:bob@example.com", time.Now().Add(-time.Hour)),
+			wantFlash: "This verification link is invalid or has expired.",
+		},
+		{
+			name:      "Tampered token",
+			token:     strings.Replace(validToken, ".", "x.", 1),
+			wantFlash: "This verification link is invalid or has expired.",
+		},
+		{
+			name:      "Changed email",
+			token:     app.signer.Sign(verificationPurpose, "2:old@example.com", time.Now().Add(time.Hour)),
+			wantFlash: "This verification link is invalid or has expired.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, _ := ts.get(t, "/user/verify?token="+url.QueryEscape(tt.token))
+
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, headers.Get("Location"), "/")
+
+			_, _, body := ts.get(t, "/")
+			if !strings.Contains(body, tt.wantFlash) {
+				t.Fatalf("Wanted flash %q in %v.", tt.wantFlash, body)
+			}
+		})
+	}
+}
+
+func TestSnippetCreateRequiresVerifiedEmail(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "bob@example.com", "pa$$word")
+
+	code, headers, _ := ts.get(t, "/snippets/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	verified := newTestServer(t, app.routes())
+	defer verified.Close()
+
+	verified.login(t, "alice@example.com", "pa$$word")
+
+	code, _, _ = verified.get(t, "/snippets/create")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/2fa")
+	secret := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindStringSubmatch(body)
+	if secret == nil {
+		t.Fatalf("no secret found in %v", body)
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	code, headers, _ := ts.get(t, "/account/2fa/qr.png")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "image/png")
+
+	form := url.Values{}
+	form.Add("code", "000000")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/account/2fa/enable", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	now := time.Now()
+	totpCode, err := totp.Code(secret[1], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form.Set("code", totpCode)
+	code, _, body = ts.postForm(t, "/account/2fa/enable", form)
+	assert.Equal(t, code, http.StatusOK)
+
+	codeList := regexp.MustCompile(`(?s)<pre><code>(.*)</code></pre>`).FindStringSubmatch(body)
+	if codeList == nil {
+		t.Fatalf("no recovery codes found in %v", body)
+	}
+	recoveryCodes := strings.Fields(codeList[1])
+	assert.Equal(t, len(recoveryCodes), 10)
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+
+	other.login(t, "alice@example.com", "pa$$word")
+
+	// The password alone mustn't be enough.
+	code, headers, _ = other.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusFound)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	_, _, body = other.get(t, "/user/login/2fa")
+	csrfToken = extractCSRFToken(t, body)
+
+	// A code that was already used to enable two-factor can't be replayed.
+	form = url.Values{}
+	form.Add("code", totpCode)
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = other.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	nextCode, err := totp.Code(secret[1], now.Add(totp.Period))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form.Set("code", nextCode)
+	code, headers, _ = other.postForm(t, "/user/login/2fa", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/")
+
+	code, _, _ = other.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+
+	t.Run("Recovery code", func(t *testing.T) {
+		rc := newTestServer(t, app.routes())
+		defer rc.Close()
+
+		rc.login(t, "alice@example.com", "pa$$word")
+		_, _, body := rc.get(t, "/user/login/2fa")
+
+		form := url.Values{}
+		form.Add("code", recoveryCodes[0])
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ := rc.postForm(t, "/user/login/2fa", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+}
+
+func TestTwoFactorDisable(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/2fa")
+	secret := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindStringSubmatch(body)
+	if secret == nil {
+		t.Fatalf("no secret found in %v", body)
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	totpCode, err := totp.Code(secret[1], time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{}
+	form.Add("code", totpCode)
+	form.Add("csrf_token", csrfToken)
+	code, _, body := ts.postForm(t, "/account/2fa/enable", form)
+	assert.Equal(t, code, http.StatusOK)
+
+	codeList := regexp.MustCompile(`(?s)<pre><code>(.*)</code></pre>`).FindStringSubmatch(body)
+	if codeList == nil {
+		t.Fatalf("no recovery codes found in %v", body)
+	}
+	recoveryCodes := strings.Fields(codeList[1])
+
+	disable := func(t *testing.T, twoFactorCode string) (int, string) {
+		form := url.Values{}
+		form.Add("code", twoFactorCode)
+		form.Add("csrf_token", csrfToken)
+		code, _, body := ts.postForm(t, "/account/2fa/disable", form)
+		return code, body
+	}
+
+	t.Run("Lockout", func(t *testing.T) {
+		defer app.users.ResetLoginFailures(1)
+
+		for range accountLockoutThreshold {
+			code, body := disable(t, "WRONG-CODE")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.MatchRegex(t, body, "This code is invalid")
+		}
+
+		code, body := disable(t, recoveryCodes[0])
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.MatchRegex(t, body, "Too many failed login attempts")
+
+		events, err := app.auditLog.List(model.AuditFilter{Action: model.AuditLoginFailed}, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(events), accountLockoutThreshold)
+		assert.Equal(t, events[0].Details["reason"], "invalid two-factor code")
+	})
+
+	code, _ = disable(t, recoveryCodes[0])
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	_, _, body = ts.get(t, "/account/2fa")
+	assert.MatchRegex(t, body, "Scan this QR code")
+}
+
+func TestTwoFactorClock(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Codes are checked against the application's clock, so pinning it far
+	// from the real time proves every step of the flow uses it.
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/2fa")
+	secret := regexp.MustCompile(`<code>([A-Z2-7]+)</code>`).FindStringSubmatch(body)
+	if secret == nil {
+		t.Fatalf("no secret found in %v", body)
+	}
+
+	codeAt := func(t *testing.T, at time.Time) string {
+		code, err := totp.Code(secret[1], at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	form := url.Values{}
+	form.Add("code", codeAt(t, now))
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/account/2fa/enable", form)
+	assert.Equal(t, code, http.StatusOK)
+
+	// login starts a fresh login and answers the two-factor prompt with the
+	// code for at.
+	login := func(t *testing.T, at time.Time) int {
+		client := newTestServer(t, app.routes())
+		defer client.Close()
+
+		client.login(t, "alice@example.com", "pa$$word")
+		_, _, body := client.get(t, "/user/login/2fa")
+
+		form := url.Values{}
+		form.Add("code", codeAt(t, at))
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ := client.postForm(t, "/user/login/2fa", form)
+		return code
+	}
+
+	// The steps run in order, each at now plus clock periods, with the code
+	// from now plus codeAt periods.
+	tests := []struct {
+		name   string
+		clock  int
+		codeAt int
+		want   int
+	}{
+		{name: "Step used to enable", clock: 0, codeAt: 0, want: http.StatusUnprocessableEntity},
+		{name: "Two steps behind", clock: 3, codeAt: 1, want: http.StatusUnprocessableEntity},
+		{name: "Two steps ahead", clock: 3, codeAt: 5, want: http.StatusUnprocessableEntity},
+		{name: "One step ahead", clock: 3, codeAt: 4, want: http.StatusSeeOther},
+		{name: "Older than the last step used", clock: 3, codeAt: 3, want: http.StatusUnprocessableEntity},
+		{name: "Last step used", clock: 5, codeAt: 4, want: http.StatusUnprocessableEntity},
+		{name: "One step behind", clock: 6, codeAt: 5, want: http.StatusSeeOther},
+		{name: "Current step", clock: 6, codeAt: 6, want: http.StatusSeeOther},
+		{name: "Current step again", clock: 6, codeAt: 6, want: http.StatusUnprocessableEntity},
+	}
+
+	start := now
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = start.Add(time.Duration(tt.clock) * totp.Period)
+			code := login(t, start.Add(time.Duration(tt.codeAt)*totp.Period))
+			assert.Equal(t, code, tt.want)
+		})
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	origin := ts.URL
+	rpID := "127.0.0.1"
+
+	authenticator, err := webauthntest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/passkeys")
+	csrfToken := extractCSRFToken(t, body)
+
+	var creationOptions struct {
+		Challenge string `json:"challenge"`
+		RP        struct {
+			ID string `json:"id"`
+		} `json:"rp"`
+	}
+	code := ts.postJSON(t, "/account/passkeys/register/begin", csrfToken, nil, &creationOptions)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, creationOptions.RP.ID, rpID)
+
+	challenge, err := base64.RawURLEncoding.DecodeString(creationOptions.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientDataJSON, attestationObject, err := authenticator.Create(rpID, origin, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registration := map[string]string{
+		"name":              "Test key",
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+		"attestationObject": base64.RawURLEncoding.EncodeToString(attestationObject),
+	}
+	code = ts.postJSON(t, "/account/passkeys/register/finish", csrfToken, registration, nil)
+	assert.Equal(t, code, http.StatusOK)
+
+	// The challenge is used up, so the same response can't register again.
+	code = ts.postJSON(t, "/account/passkeys/register/finish", csrfToken, registration, nil)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	_, _, body = ts.get(t, "/account/passkeys")
+	assert.MatchRegex(t, body, "Test key")
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+
+	_, _, body = other.get(t, "/user/login")
+	csrfToken = extractCSRFToken(t, body)
+
+	var requestOptions struct {
+		Challenge string `json:"challenge"`
+	}
+	code = other.postJSON(t, "/user/login/passkey/begin", csrfToken, nil, &requestOptions)
+	assert.Equal(t, code, http.StatusOK)
+
+	challenge, err = base64.RawURLEncoding.DecodeString(requestOptions.Challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientDataJSON, authData, signature, err := authenticator.Get(rpID, other.URL, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion := map[string]string{
+		"id":                base64.RawURLEncoding.EncodeToString(authenticator.CredentialID()),
+		"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+		"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+		"signature":         base64.RawURLEncoding.EncodeToString(signature),
+	}
+
+	var result struct {
+		Redirect string `json:"redirect"`
+	}
+	code = other.postJSON(t, "/user/login/passkey/finish", csrfToken, assertion, &result)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, result.Redirect, "/")
+
+	code, _, body = other.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+	assert.MatchRegex(t, body, "alice@example.com")
+
+	t.Run("Replayed assertion", func(t *testing.T) {
+		replay := newTestServer(t, app.routes())
+		defer replay.Close()
+
+		_, _, body := replay.get(t, "/user/login")
+		csrfToken := extractCSRFToken(t, body)
+
+		code := replay.postJSON(t, "/user/login/passkey/begin", csrfToken, nil, nil)
+		assert.Equal(t, code, http.StatusOK)
+
+		code = replay.postJSON(t, "/user/login/passkey/finish", csrfToken, assertion, nil)
+		assert.Equal(t, code, http.StatusUnauthorized)
+	})
+
+	t.Run("User not verified", func(t *testing.T) {
+		unverified := newTestServer(t, app.routes())
+		defer unverified.Close()
+
+		_, _, body := unverified.get(t, "/user/login")
+		csrfToken := extractCSRFToken(t, body)
+
+		var requestOptions struct {
+			Challenge        string `json:"challenge"`
+			UserVerification string `json:"userVerification"`
+		}
+		code := unverified.postJSON(t, "/user/login/passkey/begin", csrfToken, nil, &requestOptions)
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, requestOptions.UserVerification, "required")
+
+		challenge, err := base64.RawURLEncoding.DecodeString(requestOptions.Challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authenticator.SkipUserVerification = true
+		defer func() { authenticator.SkipUserVerification = false }()
+
+		clientDataJSON, authData, signature, err := authenticator.Get(rpID, unverified.URL, challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertion := map[string]string{
+			"id":                base64.RawURLEncoding.EncodeToString(authenticator.CredentialID()),
+			"clientDataJSON":    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			"authenticatorData": base64.RawURLEncoding.EncodeToString(authData),
+			"signature":         base64.RawURLEncoding.EncodeToString(signature),
+		}
+
+		code = unverified.postJSON(t, "/user/login/passkey/finish", csrfToken, assertion, nil)
+		assert.Equal(t, code, http.StatusUnauthorized)
+	})
+}
+
+func TestUserLoginLockout(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	login := func(email, password string) (int, string) {
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.postForm(t, "/user/login", form)
+		return code, body
+	}
+
+	lockoutError := func(body string) string {
+		return regexp.MustCompile(`<div class='error'>(.*)</div>`).FindString(body)
+	}
+
+	for range accountLockoutThreshold {
+		code, _ := login("alice@example.com", "wrong")
+		assert.Equal(t, code, http.StatusUnauthorized)
+	}
+
+	// Even the right password is turned away while the account is locked.
+	code, body := login("alice@example.com", "pa$$word")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.MatchRegex(t, body, "Too many failed login attempts")
+
+	// Addresses without an account lock out the same way, so the lockout
+	// doesn't tell them apart.
+	for range accountLockoutThreshold {
+		code, _ := login("nobody@example.com", "wrong")
+		assert.Equal(t, code, http.StatusUnauthorized)
+	}
+
+	code, unknownBody := login("Nobody@example.com", "wrong")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.MatchRegex(t, lockoutError(unknownBody), "Too many failed login attempts")
+	assert.Equal(t, lockoutError(unknownBody), lockoutError(body))
+
+	// Other accounts aren't affected.
+	code, _ = login("bob@example.com", "pa$$word")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Unlocking the account lets the user back in.
+	err := app.users.ResetLoginFailures(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ = login("alice@example.com", "pa$$word")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Guessing across many accounts eventually blocks the IP address.
+	for i := range ipLockoutThreshold - 2*accountLockoutThreshold {
+		code, _ := login(fmt.Sprintf("nobody%d@example.com", i), "wrong")
+		assert.Equal(t, code, http.StatusUnauthorized)
+	}
+
+	code, _ = login("bob@example.com", "pa$$word")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	laptop := newTestServer(t, app.routes())
+	defer laptop.Close()
+
+	phone := newTestServer(t, app.routes())
+	defer phone.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+	laptop.login(t, "alice@example.com", "pa$$word")
+	phone.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/view")
+	assert.Equal(t, strings.Count(body, "This session"), 1)
+
+	revokeForms := regexp.MustCompile(`/account/sessions/revoke/(\d+)`).FindAllStringSubmatch(body, -1)
+	assert.Equal(t, len(revokeForms), 2)
+
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/account/sessions/revoke/"+revokeForms[0][1], form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// Exactly one of the other devices has been logged out.
+	laptopCode, _, _ := laptop.get(t, "/account/view")
+	phoneCode, _, _ := phone.get(t, "/account/view")
+	assert.Equal(t, laptopCode != phoneCode, true)
+
+	code, _, _ = ts.postForm(t, "/account/sessions/revoke-others", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, _, _ = laptop.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusFound)
+	code, _, _ = phone.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusFound)
+
+	code, _, body = ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Count(body, "/account/sessions/revoke/"), 0)
+
+	// Sessions of other users can't be revoked.
+	code, _, _ = ts.postForm(t, "/account/sessions/revoke/999", form)
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestUserLoginRememberMe(t *testing.T) {
+	// With an idle timeout this short, regular sessions end on the next request.
+	t.Setenv("SESSION_IDLE_TIMEOUT", "1ns")
+
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name           string
+		rememberMe     string
+		wantPersistent bool
+		wantCode       int
+	}{
+		{
+			name:           "Regular session",
+			wantPersistent: false,
+			wantCode:       http.StatusFound,
+		},
+		{
+			name:           "Remembered session",
+			rememberMe:     "true",
+			wantPersistent: true,
+			wantCode:       http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+
+			form := url.Values{}
+			form.Add("email", "alice@example.com")
+			form.Add("password", "pa$$word")
+			form.Add("rememberMe", tt.rememberMe)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, headers, _ := ts.postForm(t, "/user/login", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			session := sessionCookie(t, headers)
+			assert.Equal(t, !session.Expires.IsZero(), tt.wantPersistent)
+			if tt.wantPersistent {
+				assert.Equal(t, time.Until(session.Expires) > 29*24*time.Hour, true)
+			}
+
+			code, _, _ = ts.get(t, "/account/view")
+			assert.Equal(t, code, tt.wantCode)
+
+			if !tt.wantPersistent {
+				return
+			}
+
+			// Changing the password renews the session's token, which mustn't
+			// cut the remembered session short.
+			_, _, body = ts.get(t, "/account/password/update")
+
+			form = url.Values{}
+			form.Add("currentPassword", "pa$$word")
+			form.Add("newPassword", "Brand-new-pa55word")
+			form.Add("newPasswordConfirmation", "Brand-new-pa55word")
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, headers, _ = ts.postForm(t, "/account/password/update", form)
+			assert.Equal(t, code, http.StatusSeeOther)
+
+			renewed := sessionCookie(t, headers)
+			assert.NotEqual(t, renewed.Value, session.Value)
+			assert.Equal(t, renewed.Expires.Sub(session.Expires).Abs() < time.Minute, true)
+		})
+	}
+}
+
+func TestUserLoginSSO(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/user/login/sso")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	fake, err := oidctest.New("snippetbox", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+
+	app.oidc = oidc.New(fake.URL, fake.ClientID, fake.ClientSecret, fake.Client())
+
+	_, _, body := ts.get(t, "/user/login")
+	assert.MatchRegex(t, body, "Sign in with SSO")
+
+	// signIn goes through the flow as a browser would and returns the
+	// response to the callback.
+	signIn := func(t *testing.T, ts *testServer, user oidctest.User) (int, http.Header) {
+		fake.SetUser(user)
+
+		code, header, _ := ts.get(t, "/user/login/sso")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		client := fake.Client()
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+
+		rs, err := client.Get(header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		assert.Equal(t, rs.StatusCode, http.StatusFound)
+
+		callback, err := url.Parse(rs.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		code, header, _ = ts.get(t, callback.RequestURI())
+		return code, header
+	}
+
+	alice := oidctest.User{Subject: "1234", Email: "alice@example.com", EmailVerified: true, Name: "Alice Jones"}
+
+	t.Run("Existing account is linked by email", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, header := signIn(t, ts, alice)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/")
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+
+		user, err := app.users.GetByIdentity(fake.URL, alice.Subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, user.ID, 1)
+	})
+
+	t.Run("Linked account ignores email", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, _ := signIn(t, ts, oidctest.User{Subject: alice.Subject, Email: "alice@elsewhere.example"})
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusOK)
+	})
+
+	t.Run("Unverified email is rejected", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, header := signIn(t, ts, oidctest.User{Subject: "5678", Email: "bob@example.com", Name: "Bob Smith"})
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		_, _, body := ts.get(t, "/user/login")
+		assert.MatchRegex(t, body, "must be verified")
+
+		code, _, _ = ts.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusFound)
+	})
+
+	t.Run("Forged state is rejected", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.get(t, "/user/login/sso")
+
+		code, header, _ := ts.get(t, "/user/login/sso/callback?code=abc&state=forged")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+	})
+
+	t.Run("Bad code is rejected", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, header, _ := ts.get(t, "/user/login/sso")
+		authorize, err := url.Parse(header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		callback := url.Values{}
+		callback.Add("code", "bogus")
+		callback.Add("state", authorize.Query().Get("state"))
+
+		code, header, _ := ts.get(t, "/user/login/sso/callback?"+callback.Encode())
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login")
+
+		_, _, body := ts.get(t, "/user/login")
+		assert.MatchRegex(t, body, "Single sign-on failed, please try again.")
+	})
+
+	t.Run("Two-factor code is still required", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		err := app.twoFactor.Enable(1, "JBSWY3DPEHPK3PXP", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer app.twoFactor.Disable(1)
+
+		code, header := signIn(t, ts, alice)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/login/2fa")
+	})
+}
+
+func TestAPITokens(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/tokens")
+	csrfToken := extractCSRFToken(t, body)
+
+	createToken := func(t *testing.T, name, scope string) string {
+		form := url.Values{}
+		form.Add("name", name)
+		form.Add("scope", scope)
+		form.Add("expires", "30")
+		form.Add("csrf_token", csrfToken)
+
+		code, _, body := ts.postForm(t, "/account/tokens/create", form)
+		assert.Equal(t, code, http.StatusOK)
+
+		matches := regexp.MustCompile(`<pre><code>(.+)</code></pre>`).FindStringSubmatch(body)
+		if len(matches) < 2 {
+			t.Fatal("no token found in body")
+		}
+		return matches[1]
+	}
+
+	readToken := createToken(t, "Reader", "read")
+	writeToken := createToken(t, "Writer", "write")
+
+	form := url.Values{}
+	form.Add("name", "")
+	form.Add("scope", "admin")
+	form.Add("expires", "30")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/account/tokens/create", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+	// Tokens are only shown once.
+	_, _, body = ts.get(t, "/account/tokens")
+	assert.MatchRegex(t, body, "Reader")
+	assert.Equal(t, strings.Contains(body, readToken), false)
+
+	newSnippet := map[string]any{"title": "From CI", "content": "Deployed.", "expires": 7}
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		token    string
+		data     any
+		wantCode int
+		wantBody string
+	}{
+		{"No token", http.MethodGet, "/api/snippets", "", nil, http.StatusUnauthorized, ""},
+		{"Invalid token", http.MethodGet, "/api/snippets", "sbx_WRONG", nil, http.StatusUnauthorized, "invalid"},
+		{"List", http.MethodGet, "/api/snippets", readToken, nil, http.StatusOK, "An old silent pond"},
+		{"View", http.MethodGet, "/api/snippets/1", readToken, nil, http.StatusOK, `"id":1`},
+		{"View missing", http.MethodGet, "/api/snippets/2", readToken, nil, http.StatusNotFound, ""},
+		{"Create with read token", http.MethodPost, "/api/snippets", readToken, newSnippet, http.StatusForbidden, ""},
+		{"Create invalid", http.MethodPost, "/api/snippets", writeToken, map[string]any{"title": "", "content": "x", "expires": 2}, http.StatusUnprocessableEntity, "expires"},
+		{"Create", http.MethodPost, "/api/snippets", writeToken, newSnippet, http.StatusCreated, "From CI"},
+		{"Read with write token", http.MethodGet, "/api/snippets/1", writeToken, nil, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.apiRequest(t, tt.method, tt.urlPath, tt.token, tt.data)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			if tt.wantBody != "" {
+				assert.MatchRegex(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	tokens, err := app.apiTokens.ForUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, fmt.Sprintf("/account/tokens/delete/%d", tokens[0].ID), form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, _, _ = ts.apiRequest(t, http.MethodGet, "/api/snippets", readToken, nil)
+	assert.Equal(t, code, http.StatusUnauthorized)
+
+	t.Run("Server error", func(t *testing.T) {
+		app.snippets = &failingSnippetModel{}
+
+		code, headers, body := ts.apiRequest(t, http.MethodGet, "/api/snippets", writeToken, nil)
+		assert.Equal(t, code, http.StatusInternalServerError)
+		assert.Equal(t, headers.Get("Content-Type"), "application/json")
+		assert.Equal(t, body, `{"error":"Internal Server Error"}`)
+	})
+}
+
+// failingSnippetModel fails to list snippets, as if the database were down.
+type failingSnippetModel struct {
+	mock.SnippetModel
+}
+
+func (m *failingSnippetModel) Latest(limit int) ([]model.Snippet, error) {
+	return nil, errors.New("database is down")
+}
+
+func TestAdmin(t *testing.T) {
+	app := newTestApplication(t)
+
+	admin := newTestServer(t, app.routes())
+	defer admin.Close()
+	admin.login(t, "alice@example.com", "pa$$word")
+
+	user := newTestServer(t, app.routes())
+	defer user.Close()
+	user.login(t, "bob@example.com", "pa$$word")
+
+	_, _, body := user.get(t, "/")
+	assert.Equal(t, strings.Contains(body, "href='/admin'"), false)
+
+	code, _, _ := user.get(t, "/admin")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	code, _, body = admin.get(t, "/admin")
+	assert.Equal(t, code, http.StatusOK)
+	assert.MatchRegex(t, body, "64.0 KiB")
+
+	code, _, body = admin.get(t, "/admin/users?q=Bob")
+	assert.Equal(t, code, http.StatusOK)
+	assert.MatchRegex(t, body, "bob@example.com")
+	assert.Equal(t, strings.Contains(body, "alice@example.com"), false)
+
+	code, _, body = admin.get(t, "/admin/snippets?q=pond")
+	assert.Equal(t, code, http.StatusOK)
+	assert.MatchRegex(t, body, "An old silent pond")
+
+	csrfToken := extractCSRFToken(t, body)
+
+	post := func(t *testing.T, urlPath string, values url.Values) (int, http.Header) {
+		form := url.Values{}
+		form.Add("csrf_token", csrfToken)
+		for k, v := range values {
+			form[k] = v
+		}
+
+		code, header, _ := admin.postForm(t, urlPath, form)
+		return code, header
+	}
+
+	t.Run("Delete snippet", func(t *testing.T) {
+		code, _ := post(t, "/admin/snippets/1/delete", nil)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, _ = post(t, "/admin/snippets/2/delete", nil)
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Own account", func(t *testing.T) {
+		code, _ := post(t, "/admin/users/1/disable", nil)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, _, _ = admin.get(t, "/admin")
+		assert.Equal(t, code, http.StatusOK)
+	})
+
+	t.Run("Change role", func(t *testing.T) {
+		code, _ := post(t, "/admin/users/2/role", url.Values{"role": {"overlord"}})
+		assert.Equal(t, code, http.StatusBadRequest)
+
+		code, _ = post(t, "/admin/users/2/role", url.Values{"role": {"moderator"}})
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, _, _ = user.get(t, "/admin/snippets")
+		assert.Equal(t, code, http.StatusOK)
+
+		code, _, _ = user.get(t, "/admin/users")
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Disable account", func(t *testing.T) {
+		code, _ := post(t, "/admin/users/2/disable", nil)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, headers, _ := user.get(t, "/account/view")
+		assert.Equal(t, code, http.StatusFound)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+
+		_, _, body := user.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("email", "bob@example.com")
+		form.Add("password", "pa$$word")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, body = user.postForm(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusForbidden)
+		assert.MatchRegex(t, body, "This account has been disabled")
+
+		code, _ = post(t, "/admin/users/2/enable", nil)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		user.login(t, "bob@example.com", "pa$$word")
+	})
+}
+
+func TestUserSignupModes(t *testing.T) {
+	// signup submits the signup form and returns the response.
+	signup := func(t *testing.T, ts *testServer, email, invite string) (int, string) {
+		_, _, body := ts.get(t, "/user/signup")
+
+		form := url.Values{}
+		form.Add("fullName", "Carol")
+		form.Add("username", "carol")
+		form.Add("email", email)
+		form.Add("password", "validPa$$word")
+		form.Add("invite", invite)
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, body := ts.postForm(t, "/user/signup", form)
+		return code, body
+	}
+
+	t.Run("Closed", func(t *testing.T) {
+		t.Setenv("SIGNUP_MODE", "closed")
+		app := newTestApplication(t)
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, _, body := ts.get(t, "/user/signup")
+		assert.Equal(t, code, http.StatusOK)
+		assert.MatchRegex(t, body, "Signup is closed")
+		assert.Equal(t, strings.Contains(body, "href='/user/signup'"), false)
+
+		_, _, body = ts.get(t, "/user/login")
+
+		form := url.Values{}
+		form.Add("fullName", "Carol")
+		form.Add("email", "carol@example.com")
+		form.Add("password", "validPa$$word")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, _ = ts.postForm(t, "/user/signup", form)
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Domain restricted", func(t *testing.T) {
+		t.Setenv("SIGNUP_MODE", "domain")
+		t.Setenv("SIGNUP_DOMAINS", "example.com, Example.org")
+		app := newTestApplication(t)
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, body := signup(t, ts, "carol@elsewhere.example", "")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.MatchRegex(t, body, "limited to addresses at example.com, example.org")
+
+		code, _ = signup(t, ts, "carol@EXAMPLE.org", "")
+		assert.Equal(t, code, http.StatusSeeOther)
+	})
+
+	t.Run("Invite only", func(t *testing.T) {
+		t.Setenv("SIGNUP_MODE", "invite")
+		app := newTestApplication(t)
+
+		admin := newTestServer(t, app.routes())
+		defer admin.Close()
+		admin.login(t, "alice@example.com", "pa$$word")
+
+		_, _, body := admin.get(t, "/admin/invites")
+
+		form := url.Values{}
+		form.Add("email", "carol@example.com")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, body := admin.postForm(t, "/admin/invites/create", form)
+		assert.Equal(t, code, http.StatusOK)
+
+		matches := regexp.MustCompile(`/user/signup\?invite=(\w+)`).FindStringSubmatch(body)
+		if len(matches) < 2 {
+			t.Fatal("no invite link found in body")
+		}
+		invite := matches[1]
+
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body = ts.get(t, "/user/signup?invite="+invite)
+		assert.MatchRegex(t, body, "value='carol@example.com'")
+
+		code, body = signup(t, ts, "carol@example.com", "")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.MatchRegex(t, body, "cannot be blank")
+
+		code, _ = signup(t, ts, "dave@example.com", invite)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+		code, _ = signup(t, ts, "carol@example.com", invite)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		code, body = signup(t, ts, "carol@example.com", invite)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.MatchRegex(t, body, "invite code is invalid")
+	})
+}
+
+func TestOrganizations(t *testing.T) {
+	app := newTestApplication(t)
+
+	var mail bytes.Buffer
+	app.mailer = mailer.NewLog(ui.Files, &mail, "test@example.com")
+
+	alice := newTestServer(t, app.routes())
+	defer alice.Close()
+	alice.login(t, "alice@example.com", "pa$$word")
+
+	bob := newTestServer(t, app.routes())
+	defer bob.Close()
+	bob.login(t, "bob@example.com", "pa$$word")
+
+	err := app.users.SetVerified(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anon := newTestServer(t, app.routes())
+	defer anon.Close()
+
+	// post submits a form with a CSRF token taken from the client's home page.
+	post := func(t *testing.T, ts *testServer, urlPath string, values url.Values) (int, http.Header, string) {
+		_, _, body := ts.get(t, "/orgs")
+
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		for k, v := range values {
+			form[k] = v
+		}
+
+		return ts.postForm(t, urlPath, form)
+	}
+
+	code, header, _ := post(t, alice, "/orgs/create", url.Values{"name": {"Haiku Club"}})
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/orgs/view/1")
+
+	t.Run("Invitations", func(t *testing.T) {
+		// invite sends an invitation from alice and returns the link in it,
+		// along with the flash she was shown.
+		invite := func(t *testing.T, email, role string) (string, string) {
+			mail.Reset()
+
+			code, header, _ := post(t, alice, "/orgs/1/invites/create", url.Values{"email": {email}, "role": {role}})
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/orgs/view/1")
+
+			_, _, body := alice.get(t, "/orgs/view/1")
+			app.wg.Wait()
+
+			link := regexp.MustCompile(`/orgs/invites/accept\?code=(\S+)`).FindString(mail.String())
+			if link == "" {
+				t.Fatalf("no invitation link in %s", mail.String())
+			}
+			return link, regexp.MustCompile(`An invitation to join has been sent to \S+`).FindString(body)
+		}
+
+		// Addresses with and without an account get the same response and an
+		// invitation, and nobody is added before accepting.
+		bobLink, bobFlash := invite(t, "bob@example.com", "member")
+		_, nobodyFlash := invite(t, "nobody@example.com", "member")
+		assert.MatchRegex(t, bobFlash, "bob@example.com")
+		assert.Equal(t, strings.Replace(nobodyFlash, "nobody", "bob", 1), bobFlash)
+
+		_, err := app.organizations.Role(1, 2)
+		assert.Equal(t, err, model.ErrNoRecord)
+
+		code, _, body := post(t, alice, "/orgs/1/invites/create", url.Values{"email": {"bob"}, "role": {"member"}})
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.MatchRegex(t, body, "must be a valid email address")
+
+		code, _, _ = post(t, bob, "/orgs/1/invites/create", url.Values{"email": {"alice@example.com"}, "role": {"member"}})
+		assert.Equal(t, code, http.StatusForbidden)
+
+		// Only the account the invitation was sent to can accept it.
+		code, header, _ := alice.get(t, bobLink)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/orgs")
+
+		code, _, body = bob.get(t, bobLink)
+		assert.Equal(t, code, http.StatusOK)
+		assert.MatchRegex(t, body, "invited to join")
+
+		inviteCode := strings.TrimPrefix(bobLink, "/orgs/invites/accept?code=")
+
+		code, header, _ = post(t, bob, "/orgs/invites/accept", url.Values{"code": {inviteCode}})
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/orgs/view/1")
+
+		role, err := app.organizations.Role(1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, role, "member")
+
+		// Each invitation works once.
+		code, header, _ = post(t, bob, "/orgs/invites/accept", url.Values{"code": {inviteCode}})
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/orgs")
+
+		// Organization invitations don't let anyone sign up.
+		_, err = app.invites.Get(inviteCode)
+		assert.Equal(t, err, model.ErrNoRecord)
+	})
+
+	t.Run("Organization only snippet", func(t *testing.T) {
+		snippet := url.Values{"title": {"Frog"}, "content": {"A frog jumps in"}, "expires": {"7"}, "visibility": {"organization"}}
+
+		code, _, _ := post(t, alice, "/snippets/create", snippet)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+
+		snippet.Set("orgID", "1")
+		code, header, _ := post(t, alice, "/snippets/create", snippet)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/snippets/view/2")
+
+		for _, urlPath := range []string{"/snippets/view/2", "/snippets/embed/2", "/snippets/view/2/og.png"} {
+			code, _, _ = anon.get(t, urlPath)
+			assert.Equal(t, code, http.StatusNotFound)
+		}
+
+		code, _, body := anon.get(t, "/orgs/view/1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, strings.Contains(body, "Frog"), false)
+
+		_, _, body = bob.get(t, "/orgs/view/1")
+		assert.MatchRegex(t, body, "Frog")
+
+		_, _, body = bob.get(t, "/")
+		assert.Equal(t, strings.Contains(body, "Frog"), false)
+	})
+
+	t.Run("Members can edit", func(t *testing.T) {
+		code, _, body := bob.get(t, "/snippets/view/2")
+		assert.Equal(t, code, http.StatusOK)
+		assert.MatchRegex(t, body, "/snippets/edit/2")
+
+		code, _, _ = post(t, bob, "/snippets/edit/2", url.Values{"title": {"Frog"}, "content": {"Sound of water"}, "visibility": {"organization"}})
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		snippet, err := app.snippets.Get(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, snippet.Content, "Sound of water")
+
+		code, _, _ = bob.get(t, "/snippets/edit/1")
+		assert.Equal(t, code, http.StatusForbidden)
+
+		code, _, _ = post(t, bob, "/snippets/edit/1", url.Values{"title": {"Mine"}, "content": {"Now"}, "visibility": {"public"}})
+		assert.Equal(t, code, http.StatusForbidden)
+	})
+
+	t.Run("Membership changes", func(t *testing.T) {
+		code, _, _ := post(t, bob, "/orgs/1/members/1/remove", nil)
+		assert.Equal(t, code, http.StatusForbidden)
+
+		code, _, _ = post(t, alice, "/orgs/1/members/1/role", url.Values{"role": {"member"}})
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		role, err := app.organizations.Role(1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, role, "owner")
+
+		code, header, _ := post(t, bob, "/orgs/1/members/2/remove", nil)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/orgs")
+
+		code, _, _ = bob.get(t, "/snippets/view/2")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestUserProfile(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("View", func(t *testing.T) {
+		code, _, body := ts.get(t, "/u/alice")
+		assert.Equal(t, code, http.StatusOK)
+		assert.MatchRegex(t, body, "@alice")
+		assert.MatchRegex(t, body, "Writes haiku about ponds.")
+		assert.MatchRegex(t, body, "<img class='avatar' src='/u/alice/avatar.png\\?s=256'")
+		assert.MatchRegex(t, body, "<a href='/snippets/view/1'>An old silent pond</a>")
+
+		code, _, body = ts.get(t, "/u/Alice")
+		assert.Equal(t, code, http.StatusOK)
+		assert.MatchRegex(t, body, "@alice")
+
+		code, _, _ = ts.get(t, "/u/nobody")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+
+	t.Run("Avatar", func(t *testing.T) {
+		code, header, body := ts.get(t, "/u/alice/avatar.png")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "image/png")
+		assert.Equal(t, header.Get("Cache-Control"), "public, max-age=86400")
+		assert.Equal(t, strings.HasPrefix(body, "\x89PNG"), true)
+
+		// Without an uploaded avatar there's no version to match.
+		_, header, _ = ts.get(t, "/u/alice/avatar.png?v=0")
+		assert.Equal(t, header.Get("Cache-Control"), "public, max-age=86400")
+
+		code, _, _ = ts.get(t, "/u/alice/avatar.png?s=100")
+		assert.Equal(t, code, http.StatusNotFound)
+
+		code, _, _ = ts.get(t, "/u/ALICE/avatar.png")
+		assert.Equal(t, code, http.StatusOK)
+	})
+
+	t.Run("Author link", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippets/view/1")
+		assert.MatchRegex(t, body, "By <a href='/u/alice'>Alice</a>")
+	})
+
+	t.Run("Edit", func(t *testing.T) {
+		ts.login(t, "alice@example.com", "pa$$word")
+
+		// post submits the profile form with a CSRF token taken from it.
+		post := func(username, bio string) (int, http.Header, string) {
+			_, _, body := ts.get(t, "/account/profile")
+
+			form := url.Values{}
+			form.Add("fullName", "Alice")
+			form.Add("username", username)
+			form.Add("bio", bio)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			return ts.postForm(t, "/account/profile", form)
+		}
+
+		tests := []struct {
+			name, username, wantError string
+		}{
+			{name: "Invalid", username: "a!", wantError: "Usernames are 3 to 30"},
+			{name: "Reserved", username: "admin", wantError: "This username is reserved"},
+			{name: "Taken", username: "bob", wantError: "Username is already taken"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				code, _, body := post(tt.username, "")
+				assert.Equal(t, code, http.StatusUnprocessableEntity)
+				assert.MatchRegex(t, body, tt.wantError)
+			})
+		}
+
+		code, header, _ := post(" Alice_W ", "Ponds, frogs and haiku.")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/u/alice_w")
+
+		_, _, body := ts.get(t, "/u/alice_w")
+		assert.MatchRegex(t, body, "Ponds, frogs and haiku.")
+	})
+}
+
+func TestAvatarUpload(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	// upload submits the avatar form with a CSRF token taken from the profile
+	// page.
+	upload := func(t *testing.T, file []byte) (int, http.Header, string) {
+		_, _, body := ts.get(t, "/account/profile")
+
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		return ts.postFile(t, "/account/avatar", form, "avatar", "avatar", file)
+	}
+
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 400, 300)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		file      []byte
+		wantError string
+	}{
+		{name: "Not an image", file: []byte("<svg></svg>"), wantError: "Avatars must be PNG, JPEG or GIF images"},
+		{name: "Corrupt", file: buf.Bytes()[:100], wantError: "This image couldn&#39;t be read"},
+		{name: "Too big", file: make([]byte, avatarMaxBytes+1), wantError: "Images must be 2.0 MiB or smaller"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := upload(t, tt.file)
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.MatchRegex(t, body, tt.wantError)
+		})
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		code, header, _ := upload(t, buf.Bytes())
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/profile")
+
+		_, _, body := ts.get(t, "/u/alice")
+		src := regexp.MustCompile(`src='(/u/alice/avatar.png\?s=256&amp;v=\d+)'`).FindStringSubmatch(body)
+		if src == nil {
+			t.Fatalf("no versioned avatar link in %s", body)
+		}
+
+		code, header, body = ts.get(t, html.UnescapeString(src[1]))
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Cache-Control"), "public, max-age=31536000, immutable")
+
+		_, header, _ = ts.get(t, "/u/alice/avatar.png?s=256&v=1")
+		assert.Equal(t, header.Get("Cache-Control"), "public, max-age=86400")
+
+		img, err := png.Decode(strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, img.Bounds().Dx(), 256)
+
+		_, _, body = ts.get(t, "/u/alice/avatar.png?s=64")
+		img, err = png.Decode(strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, img.Bounds().Dx(), 64)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		_, _, body := ts.get(t, "/account/profile")
+
+		form := url.Values{}
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, _ := ts.postForm(t, "/account/avatar/delete", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		_, _, body = ts.get(t, "/u/alice")
+		assert.MatchRegex(t, body, "src='/u/alice/avatar.png\\?s=256'")
+	})
+}
+
+func TestAccountExport(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	code, header, body := ts.get(t, "/account/export")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/zip")
+	assert.MatchRegex(t, header.Get("Content-Disposition"), `^attachment; filename="snippetbox-.+\.zip"$`)
+
+	zr, err := zip.NewReader(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files[f.Name] = string(b)
+	}
+
+	var profile struct {
+		Email    string `json:"email"`
+		Username string `json:"username"`
+	}
+
+	err = json.Unmarshal([]byte(files["profile.json"]), &profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, profile.Email, "alice@example.com")
+	assert.Equal(t, profile.Username, "alice")
+
+	assert.MatchRegex(t, files["snippets.json"], `"title": "An old silent pond"`)
+
+	for _, name := range []string{"collections.json", "organizations.json", "api_tokens.json", "passkeys.json", "sessions.json"} {
+		assert.Equal(t, json.Valid([]byte(files[name])), true)
+	}
+}
+
+func TestAccountDelete(t *testing.T) {
+	app := newTestApplication(t)
+
+	var mail bytes.Buffer
+	app.mailer = mailer.NewLog(ui.Files, &mail, "test@example.com")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+	other.login(t, "alice@example.com", "pa$$word")
+
+	// deleteAccount submits the deletion form with a CSRF token taken from it.
+	deleteAccount := func(t *testing.T, password string) (int, http.Header, string) {
+		_, _, body := ts.get(t, "/account/view")
+
+		form := url.Values{}
+		form.Add("password", password)
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		return ts.postForm(t, "/account/delete", form)
+	}
+
+	orgID, err := app.organizations.Insert("Haiku Club", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.organizations.AddMember(orgID, 2, model.OrgRoleMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Last owner", func(t *testing.T) {
+		_, _, body := ts.get(t, "/account/delete")
+		assert.MatchRegex(t, body, "You're the only owner of")
+
+		code, _, _ := deleteAccount(t, "pa$$word")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	})
+
+	err = app.organizations.SetMemberRole(orgID, 2, model.OrgRoleOwner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Wrong password", func(t *testing.T) {
+		// Wrong passwords lock the account like failed logins, after which
+		// even the right one is turned away.
+		for range accountLockoutThreshold {
+			code, _, body := deleteAccount(t, "wrong")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.MatchRegex(t, body, "Password is incorrect")
+		}
+
+		code, _, body := deleteAccount(t, "pa$$word")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.MatchRegex(t, body, "Too many failed login attempts")
+
+		// Once the lockout is over, passwords are checked again.
+		app.now = func() time.Time { return time.Now().Add(lockoutMax + time.Minute) }
+		defer func() { app.now = time.Now }()
+
+		code, _, body = deleteAccount(t, "wrong")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.MatchRegex(t, body, "Password is incorrect")
+
+		err := app.users.ResetLoginFailures(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		code, header, _ := deleteAccount(t, "pa$$word")
+		app.wg.Wait()
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/")
+		assert.MatchRegex(t, mail.String(), "To: alice@example.com")
+		assert.MatchRegex(t, mail.String(), "Your Snippetbox account has been deleted")
+
+		for _, client := range []*testServer{ts, other} {
+			code, header, _ := client.get(t, "/account/view")
+			assert.Equal(t, code, http.StatusFound)
+			assert.Equal(t, header.Get("Location"), "/user/login")
+		}
+
+		_, err := app.users.Authenticate("alice@example.com", "pa$$word")
+		assert.Equal(t, err, model.ErrInvalidCredentials)
+
+		code, _, _ = ts.get(t, "/u/alice")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestAccountDeleteWithoutPassword(t *testing.T) {
+	app := newTestApplication(t)
+	app.users = &passwordlessUserModel{}
+
+	var mail bytes.Buffer
+	app.mailer = mailer.NewLog(ui.Files, &mail, "test@example.com")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	// deleteAccount submits the deletion form with the given token and a CSRF
+	// token taken from the account page.
+	deleteAccount := func(t *testing.T, token string) (int, http.Header) {
+		_, _, body := ts.get(t, "/account/view")
+
+		form := url.Values{}
+		form.Add("token", token)
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, header, _ := ts.postForm(t, "/account/delete", form)
+		return code, header
+	}
+
+	_, _, body := ts.get(t, "/account/delete")
+	assert.MatchRegex(t, body, "Your account doesn't have a password")
+
+	code, header := deleteAccount(t, "")
+	app.wg.Wait()
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/view")
+	assert.MatchRegex(t, mail.String(), "To: alice@example.com")
+
+	link := regexp.MustCompile(`/account/delete/confirm\?token=(\S+)`).FindStringSubmatch(mail.String())
+	if link == nil {
+		t.Fatalf("no confirmation link in %s", mail.String())
+	}
+
+	token, err := url.QueryUnescape(link[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing happens until the link is followed and the deletion confirmed.
+	user, err := app.users.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Email, "alice@example.com")
+
+	t.Run("Invalid token", func(t *testing.T) {
+		forged := app.signer.Sign(emailChangePurpose, "1\nalice@example.com", time.Now().Add(time.Hour))
+
+		for _, token := range []string{"forged", forged} {
+			code, header, _ := ts.get(t, "/account/delete/confirm?token="+url.QueryEscape(token))
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/account/delete")
+
+			code, header = deleteAccount(t, token)
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), "/account/delete")
+		}
+
+		_, _, body := ts.get(t, "/")
+		assert.MatchRegex(t, body, "This account deletion link is invalid or has expired.")
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		code, _, body := ts.get(t, "/account/delete/confirm?token="+url.QueryEscape(token))
+		assert.Equal(t, code, http.StatusOK)
+		assert.MatchRegex(t, body, "<input type='hidden' name='token'")
+
+		code, header := deleteAccount(t, token)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/")
+
+		user, err := app.users.Get(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, user.Email, "deleted-1@deleted.invalid")
+	})
+}
+
+// passwordlessUserModel has users without passwords, as if they'd signed up
+// through single sign-on.
+type passwordlessUserModel struct {
+	mock.UserModel
+}
+
+func (m *passwordlessUserModel) Get(id int) (model.User, error) {
+	user, err := m.UserModel.Get(id)
+	user.HasPassword = false
+	return user, err
+}
+
+func TestAccountEmailChange(t *testing.T) {
+	app := newTestApplication(t)
+
+	var mail bytes.Buffer
+	app.mailer = mailer.NewLog(ui.Files, &mail, "test@example.com")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	// change submits the email change form with a CSRF token taken from it.
+	change := func(t *testing.T, newEmail, password string) (int, string) {
+		_, _, body := ts.get(t, "/account/email")
+
+		form := url.Values{}
+		form.Add("newEmail", newEmail)
+		form.Add("password", password)
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, body := ts.postForm(t, "/account/email", form)
+		return code, body
+	}
+
+	// confirm follows a confirmation link and returns the flash message shown
+	// afterwards.
+	confirm := func(t *testing.T, token string) string {
+		code, header, _ := ts.get(t, "/user/email/confirm?token="+url.QueryEscape(token))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/")
+
+		_, _, body := ts.get(t, "/")
+		return body
+	}
+
+	tests := []struct {
+		name, newEmail, password, wantError string
+	}{
+		{name: "Invalid email", newEmail: "alice", password: "pa$$word", wantError: "This field must be a valid email address"},
+		{name: "Same email", newEmail: "alice@example.com", password: "pa$$word", wantError: "This is already your email address"},
+		{name: "Wrong password", newEmail: "alice@new.example.com", password: "wrong", wantError: "Password is incorrect"},
+		{name: "In use", newEmail: "bob@example.com", password: "pa$$word", wantError: "Email address is already in use"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := change(t, tt.newEmail, tt.password)
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+			assert.MatchRegex(t, body, tt.wantError)
+		})
+	}
+
+	t.Run("Lockout", func(t *testing.T) {
+		defer app.users.ResetLoginFailures(1)
+
+		// One wrong password has already been entered above.
+		for range accountLockoutThreshold - 1 {
+			code, _ := change(t, "alice@new.example.com", "wrong")
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+		}
+
+		code, body := change(t, "alice@new.example.com", "pa$$word")
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.MatchRegex(t, body, "Too many failed login attempts")
+	})
+
+	t.Run("Other tokens", func(t *testing.T) {
+		verification := app.signer.Sign(verificationPurpose, "1:alice@example.com", time.Now().Add(time.Hour))
+		assert.MatchRegex(t, confirm(t, verification), "This email change link is invalid or has expired.")
+
+		taken := app.signer.Sign(emailChangePurpose, "1\nalice@example.com\ndupe@example.com", time.Now().Add(time.Hour))
+		assert.MatchRegex(t, confirm(t, taken), "dupe@example.com is already in use by another account.")
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		code, _ := change(t, "alice@new.example.com", "pa$$word")
+		app.wg.Wait()
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.MatchRegex(t, mail.String(), "To: alice@new.example.com")
+
+		// Nothing changes until the link is followed.
+		user, err := app.users.Get(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, user.Email, "alice@example.com")
+
+		link := regexp.MustCompile(`/user/email/confirm\?token=(\S+)`).FindStringSubmatch(mail.String())
+		if link == nil {
+			t.Fatalf("no confirmation link in %s", mail.String())
+		}
+
+		token, err := url.QueryUnescape(link[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = app.tokens.New(1, time.Hour, model.ScopePasswordReset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mail.Reset()
+		assert.MatchRegex(t, confirm(t, token), "Your email address has been changed to alice@new.example.com.")
+		app.wg.Wait()
+
+		// Password reset links sent to the old address stop working.
+		_, err = app.tokens.UserID(mock.MockToken, model.ScopePasswordReset)
+		assert.Equal(t, err, model.ErrNoRecord)
+
+		user, err = app.users.Get(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, user.Email, "alice@new.example.com")
+		assert.MatchRegex(t, mail.String(), "To: alice@example.com")
+		assert.MatchRegex(t, mail.String(), "Your Snippetbox email address has changed")
+
+		assert.MatchRegex(t, confirm(t, token), "This email change link is invalid or has expired.")
+	})
+}
+
+func TestAccountEmailChangeDomain(t *testing.T) {
+	t.Setenv("SIGNUP_MODE", "domain")
+	t.Setenv("SIGNUP_DOMAINS", "example.com")
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	tests := []struct {
+		newEmail string
+		wantCode int
+	}{
+		{newEmail: "alice@elsewhere.example", wantCode: http.StatusUnprocessableEntity},
+		{newEmail: "alice.jones@EXAMPLE.com", wantCode: http.StatusSeeOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.newEmail, func(t *testing.T) {
+			_, _, body := ts.get(t, "/account/email")
+
+			form := url.Values{}
+			form.Add("newEmail", tt.newEmail)
+			form.Add("password", "pa$$word")
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, _, body := ts.postForm(t, "/account/email", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantCode != http.StatusSeeOther {
+				assert.MatchRegex(t, body, "limited to ones at example.com")
+			}
+		})
+	}
+}
+
+func TestAccountEmailChangeWithoutPassword(t *testing.T) {
+	app := newTestApplication(t)
+	app.users = &passwordlessUserModel{}
+
+	var mail bytes.Buffer
+	app.mailer = mailer.NewLog(ui.Files, &mail, "test@example.com")
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t, "alice@example.com", "pa$$word")
+
+	_, _, body := ts.get(t, "/account/email")
+	assert.MatchRegex(t, body, "Your account doesn't have a password")
+	assert.Equal(t, strings.Contains(body, "name='password'"), false)
+
+	form := url.Values{}
+	form.Add("newEmail", "alice@new.example.com")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/account/email", form)
+	app.wg.Wait()
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// The change has to be approved from the old address first.
+	assert.MatchRegex(t, mail.String(), "To: alice@example.com")
+	assert.Equal(t, strings.Contains(mail.String(), "/user/email/confirm"), false)
+
+	link := regexp.MustCompile(`/account/email/approve\?token=(\S+)`).FindStringSubmatch(mail.String())
+	if link == nil {
+		t.Fatalf("no approval link in %s", mail.String())
+	}
+
+	t.Run("Invalid token", func(t *testing.T) {
+		forged := app.signer.Sign(emailChangePurpose, "1\nalice@example.com\nalice@new.example.com", time.Now().Add(time.Hour))
+
+		code, header, _ := ts.get(t, "/account/email/approve?token="+url.QueryEscape(forged))
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/email")
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		mail.Reset()
+
+		code, header, _ := ts.get(t, link[0])
+		app.wg.Wait()
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/account/view")
+		assert.MatchRegex(t, mail.String(), "To: alice@new.example.com")
+		assert.MatchRegex(t, mail.String(), "/user/email/confirm\\?token=")
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	app := newTestApplication(t)
+
+	admin := newTestServer(t, app.routes())
+	defer admin.Close()
+	admin.login(t, "alice@example.com", "pa$$word")
+
+	user := newTestServer(t, app.routes())
+	defer user.Close()
+
+	// A failed login from a client whose user agent a spreadsheet would run.
+	_, _, body := user.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "bob@example.com")
+	form.Add("password", "wrong")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	req, err := http.NewRequest(http.MethodPost, user.URL+"/user/login", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "=HYPERLINK(\"http://evil.example\")")
+
+	rs, err := user.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	assert.Equal(t, rs.StatusCode, http.StatusUnauthorized)
+
+	user.login(t, "bob@example.com", "pa$$word")
+
+	code, _, _ := user.get(t, "/admin/audit")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	_, _, body = admin.get(t, "/admin/users")
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = admin.postForm(t, "/admin/users/2/disable", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	t.Run("View", func(t *testing.T) {
+		code, _, body := admin.get(t, "/admin/audit")
+		assert.Equal(t, code, http.StatusOK)
+		assert.MatchRegex(t, body, `<td>login.failed</td>`)
+		assert.MatchRegex(t, body, `reason: invalid credentials`)
+		assert.MatchRegex(t, body, `<td>admin.user.disable</td>\s*<td>alice@example.com</td>\s*<td>bob@example.com</td>`)
+
+		code, _, body = admin.get(t, "/admin/audit?action=login.failed")
+		assert.Equal(t, code, http.StatusOK)
+		assert.MatchRegex(t, body, `<td>login.failed</td>`)
+		assert.Equal(t, strings.Contains(body, "<td>admin.user.disable</td>"), false)
+
+		code, _, body = admin.get(t, "/admin/audit?user=alice")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, strings.Contains(body, "<td>login.failed</td>"), false)
+		assert.MatchRegex(t, body, `<td>admin.user.disable</td>`)
+
+		code, _, body = admin.get(t, "/admin/audit?to=2000-01-01")
+		assert.Equal(t, code, http.StatusOK)
+		assert.MatchRegex(t, body, "No events match")
+
+		code, _, _ = admin.get(t, "/admin/audit?from=yesterday")
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+
+	t.Run("Export CSV", func(t *testing.T) {
+		code, header, body := admin.get(t, "/admin/audit/export?format=csv&action=login.failed")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "text/csv; charset=utf-8")
+		assert.MatchRegex(t, header.Get("Content-Disposition"), `^attachment; filename="audit-log-.+\.csv"$`)
+
+		records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, len(records), 2)
+		assert.Equal(t, records[0][2], "action")
+		assert.Equal(t, records[1][2], "login.failed")
+		assert.Equal(t, records[1][3], "")
+		assert.Equal(t, records[1][6], "bob@example.com")
+		assert.Equal(t, records[1][8], "'=HYPERLINK(\"http://evil.example\")")
+	})
+
+	t.Run("Export JSON lines", func(t *testing.T) {
+		code, header, body := admin.get(t, "/admin/audit/export?format=jsonl")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, header.Get("Content-Type"), "application/x-ndjson")
+
+		var actions []string
+		for _, line := range strings.Split(body, "\n") {
+			var event struct {
+				Action  string            `json:"action"`
+				Details map[string]string `json:"details"`
+			}
+			err := json.Unmarshal([]byte(line), &event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actions = append(actions, event.Action)
+		}
+		assert.Equal(t, actions, []string{model.AuditAdminUserDisable, model.AuditLogin, model.AuditLoginFailed, model.AuditLogin})
+
+		code, _, _ = admin.get(t, "/admin/audit/export?format=xml")
+		assert.Equal(t, code, http.StatusBadRequest)
+	})
+}
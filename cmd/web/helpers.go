@@ -2,16 +2,30 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"github.com/thisisjab/snippetbox-go/internal/webauthn"
+	"net"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strings"
 	"time"
 )
 
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logServerError(r, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+func (app *application) logServerError(r *http.Request, err error) {
 	var (
 		method = r.Method
 		uri    = r.URL.RequestURI()
@@ -19,7 +33,6 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 	)
 
 	app.logger.Error(err.Error(), "method", method, "uri", uri, "trace", trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
 func (app *application) clientError(w http.ResponseWriter, status int) {
@@ -53,15 +66,54 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 	buf.WriteTo(w)
 }
 
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data any) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// errorJSON sends an API error: a JSON object whose error field is message.
+func (app *application) errorJSON(w http.ResponseWriter, r *http.Request, status int, message string) {
+	app.writeJSON(w, r, status, map[string]string{"error": message})
+}
+
+// serverErrorJSON is serverError for the API, which answers in JSON.
+func (app *application) serverErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
+	app.logServerError(r, err)
+	app.errorJSON(w, r, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
 func (app *application) newTemplateData(r *http.Request) templateData {
 	return templateData{
 		CurrentYear:     time.Now().Year(),
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
 		CSRFToken:       nosurf.Token(r),
+		BaseURL:         app.baseURL(r),
+		SSOProvider:     app.ssoProviderName(),
+		IsModerator:     app.authenticatedUser(r).HasRole(model.RoleModerator),
+		IsAdmin:         app.authenticatedUser(r).HasRole(model.RoleAdmin),
+		SignupMode:      app.config.SignupMode(),
+		SignupDomains:   app.config.SignupDomains(),
 	}
 }
 
+// ssoProviderName is the name the login page shows for single sign-on, or
+// empty if it's disabled.
+func (app *application) ssoProviderName() string {
+	if app.oidc == nil {
+		return ""
+	}
+
+	return app.config.OIDCProviderName()
+}
+
 func (app *application) decodePostForm(r *http.Request, dst any) error {
 	err := r.ParseForm()
 	if err != nil {
@@ -89,3 +141,320 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// authenticatedUser returns the logged in user, or the zero User if nobody is
+// logged in.
+func (app *application) authenticatedUser(r *http.Request) model.User {
+	user, _ := r.Context().Value(authenticatedUserContextKey).(model.User)
+	return user
+}
+
+// apiToken returns the token an API request was authenticated with. It must
+// only be called behind requireAPIToken.
+func (app *application) apiToken(r *http.Request) model.APIToken {
+	token, ok := r.Context().Value(apiTokenContextKey).(model.APIToken)
+	if !ok {
+		panic("apiToken called without requireAPIToken")
+	}
+	return token
+}
+
+func (app *application) authenticatedUserID(r *http.Request) int {
+	return app.sessionManager.GetInt(r.Context(), "userID")
+}
+
+// orgRole returns the user's role in the organization, or an empty string if
+// they aren't a member. Logged out visitors, whose ID is zero, are never
+// members.
+func (app *application) orgRole(orgID, userID int) (string, error) {
+	if orgID == 0 || userID == 0 {
+		return "", nil
+	}
+
+	role, err := app.organizations.Role(orgID, userID)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRecord) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return role, nil
+}
+
+func (app *application) isOrgMember(orgID, userID int) (bool, error) {
+	role, err := app.orgRole(orgID, userID)
+	return role != "", err
+}
+
+// canViewSnippet reports whether the user may see the snippet. Snippets
+// limited to an organization are hidden from everyone outside it.
+func (app *application) canViewSnippet(userID int, snippet model.Snippet) (bool, error) {
+	if snippet.Public() {
+		return true, nil
+	}
+
+	return app.isOrgMember(snippet.OrgID, userID)
+}
+
+// canEditSnippet reports whether the user may edit the snippet. Snippets
+// created under an organization can be edited by all of its members, and any
+// other snippet only by its author.
+func (app *application) canEditSnippet(userID int, snippet model.Snippet) (bool, error) {
+	if snippet.OrgID != 0 {
+		return app.isOrgMember(snippet.OrgID, userID)
+	}
+
+	return userID != 0 && snippet.UserID == userID, nil
+}
+
+// viewerKey identifies the visitor making the request: their session token if
+// they have a session, otherwise their IP address.
+func (app *application) viewerKey(r *http.Request) string {
+	if token := app.sessionManager.Token(r.Context()); token != "" {
+		return "session:" + token
+	}
+
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the IP address the request came from.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// audit records a security-relevant event in the audit log, along with where
+// the request came from. actorID is the user who took the action, or 0 if
+// that isn't known. By the time it's recorded the action has already been
+// taken, so failing to record it is logged rather than failing the request.
+func (app *application) audit(r *http.Request, action string, actorID, targetID int, details map[string]string) {
+	err := app.auditLog.Insert(model.AuditEvent{
+		Action:    action,
+		ActorID:   actorID,
+		TargetID:  targetID,
+		IP:        clientIP(r),
+		UserAgent: truncate(r.UserAgent(), 255),
+		Details:   details,
+	})
+	if err != nil {
+		app.logger.Error("Error recording audit event", "action", action, "actorID", actorID, "error", err)
+	}
+}
+
+// emailURL returns an absolute link to path for an email. Unlike baseURL, it
+// never looks at the request: its Host header is up to the client, so anyone
+// could otherwise have a password reset link for someone else's account point
+// at their own site.
+func (app *application) emailURL(path string) string {
+	return app.config.BaseURL() + path
+}
+
+// baseURL returns the scheme and host absolute links on pages should use. The
+// configured base URL wins; otherwise it's derived from the request.
+func (app *application) baseURL(r *http.Request) string {
+	if base := app.config.BaseURL(); base != "" {
+		return base
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+// destroyOtherSessions logs the user out of every session except the one in
+// ctx, for example after their password has changed. The sessions are found
+// through the user's recorded sessions, so it must run before those records
+// are removed.
+func (app *application) destroyOtherSessions(ctx context.Context, userID int) error {
+	tokens, err := app.userSessions.DeleteOthers(userID, app.sessionManager.Token(ctx))
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		err = app.sessionManager.Store.Delete(token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renewToken gives the session a new token, as should happen whenever its
+// privileges change, and moves the session's recorded metadata to it.
+func (app *application) renewToken(r *http.Request) error {
+	oldToken := app.sessionManager.Token(r.Context())
+	deadline := app.sessionManager.Deadline(r.Context())
+
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	// RenewToken starts the session's lifetime over, which would cut a
+	// remembered session short.
+	if app.sessionManager.GetBool(r.Context(), "rememberMe") {
+		app.sessionManager.SetDeadline(r.Context(), deadline)
+	}
+
+	if oldToken == "" {
+		return nil
+	}
+
+	return app.userSessions.Rekey(oldToken, app.sessionManager.Token(r.Context()))
+}
+
+// startSession logs the user in on the current session and records which
+// device it's on, so that it shows up in their list of active sessions. A
+// remembered session survives the browser closing, lasts for the configured
+// remember me lifetime and isn't subject to the idle timeout. method is how
+// the user proved who they are, for the audit log.
+func (app *application) startSession(r *http.Request, userID int, rememberMe bool, method string) error {
+	app.sessionManager.Put(r.Context(), "userID", userID)
+	app.sessionManager.Put(r.Context(), "lastSeen", time.Now().Unix())
+
+	if rememberMe {
+		app.sessionManager.Put(r.Context(), "rememberMe", true)
+		app.sessionManager.RememberMe(r.Context(), true)
+		app.sessionManager.SetDeadline(r.Context(), time.Now().Add(app.config.RememberMeLifetime()))
+	}
+
+	err := app.userSessions.Insert(app.sessionManager.Token(r.Context()), userID, truncate(r.UserAgent(), 255), clientIP(r))
+	if err != nil {
+		return err
+	}
+
+	app.audit(r, model.AuditLogin, userID, 0, map[string]string{"method": method})
+
+	return nil
+}
+
+// endSession logs the user out of the current session.
+func (app *application) endSession(r *http.Request) error {
+	err := app.userSessions.DeleteByToken(app.sessionManager.Token(r.Context()))
+	if err != nil {
+		return err
+	}
+
+	err = app.renewToken(r)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Remove(r.Context(), "userID")
+	app.sessionManager.Remove(r.Context(), "lastSeen")
+	app.sessionManager.Remove(r.Context(), "rememberMe")
+	app.sessionManager.RememberMe(r.Context(), false)
+
+	return nil
+}
+
+// sessionIdle reports whether the current session has gone unused for longer
+// than the idle timeout. Remembered sessions never go idle.
+func (app *application) sessionIdle(r *http.Request) bool {
+	timeout := app.config.SessionIdleTimeout()
+	if timeout <= 0 || app.sessionManager.GetBool(r.Context(), "rememberMe") {
+		return false
+	}
+
+	lastSeen := app.sessionManager.GetInt64(r.Context(), "lastSeen")
+	if lastSeen == 0 {
+		return false
+	}
+
+	return time.Since(time.Unix(lastSeen, 0)) > timeout
+}
+
+// sessionTouchInterval is how often a session's last seen time is updated.
+// Updating it on every request would mean a database write per request.
+const sessionTouchInterval = time.Minute
+
+// touchSession records that the current session is in use.
+func (app *application) touchSession(r *http.Request) error {
+	lastSeen := time.Unix(app.sessionManager.GetInt64(r.Context(), "lastSeen"), 0)
+	if time.Since(lastSeen) < sessionTouchInterval {
+		return nil
+	}
+
+	app.sessionManager.Put(r.Context(), "lastSeen", time.Now().Unix())
+
+	return app.userSessions.Touch(app.sessionManager.Token(r.Context()))
+}
+
+// background runs fn in a new goroutine, logging instead of crashing if it
+// panics. The application's wait group tracks it so that tests can wait for
+// the work to finish.
+func (app *application) background(fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// generateRecoveryCode returns a random two-factor recovery code, formatted in
+// two halves so that it's easier to copy by hand.
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+
+	return code[:4] + "-" + code[4:], nil
+}
+
+// readJSON decodes a JSON request body into dst, rejecting bodies which are
+// too large or contain more than one value.
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		return err
+	}
+
+	if dec.More() {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// relyingParty describes this site to WebAuthn. The origin passkeys are bound
+// to comes from the base URL, so it must be configured when the site is served
+// behind a proxy.
+func (app *application) relyingParty(r *http.Request) (webauthn.RelyingParty, error) {
+	origin := app.baseURL(r)
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return webauthn.RelyingParty{}, err
+	}
+
+	return webauthn.RelyingParty{ID: u.Hostname(), Name: "Snippetbox", Origin: origin}, nil
+}
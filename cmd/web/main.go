@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"crypto/tls"
 	"database/sql"
 	"flag"
@@ -9,24 +10,53 @@ import (
 	"github.com/go-playground/form/v4"
 	"github.com/thisisjab/snippetbox-go/cmd/web/config"
 	"github.com/thisisjab/snippetbox-go/cmd/web/db"
+	"github.com/thisisjab/snippetbox-go/internal/mailer"
 	"github.com/thisisjab/snippetbox-go/internal/model"
+	"github.com/thisisjab/snippetbox-go/internal/ogimage"
+	"github.com/thisisjab/snippetbox-go/internal/oidc"
+	"github.com/thisisjab/snippetbox-go/internal/password"
+	"github.com/thisisjab/snippetbox-go/internal/signer"
+	"github.com/thisisjab/snippetbox-go/internal/validator"
+	"github.com/thisisjab/snippetbox-go/ui"
 	"html/template"
 	"log/slog"
 	"math"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 )
 
 type application struct {
+	apiTokens      model.APITokenModelInterface
+	auditLog       model.AuditLogModelInterface
+	avatars        model.AvatarModelInterface
+	collections    model.CollectionModelInterface
 	config         *config.Config
 	dbConn         *sql.DB
+	emailThrottle  *loginThrottle
 	formDecoder    *form.Decoder
+	invites        model.InviteModelInterface
+	ipThrottle     *loginThrottle
 	logger         *slog.Logger
+	mailer         mailer.Mailer
+	now            func() time.Time
+	oidc           *oidc.Provider
+	organizations  model.OrganizationModelInterface
+	passkeys       model.PasskeyModelInterface
+	passwordPolicy *validator.PasswordPolicy
+	previews       *ogimage.Generator
 	sessionManager *scs.SessionManager
+	signer         *signer.Signer
 	snippets       model.SnippetModelInterface
+	stats          model.StatsModelInterface
 	templateCache  map[string]*template.Template
+	tokens         model.TokenModelInterface
+	twoFactor      model.TwoFactorModelInterface
+	userSessions   model.UserSessionModelInterface
 	users          model.UserModelInterface
+	views          *viewCounter
+	wg             sync.WaitGroup
 }
 
 func main() {
@@ -34,18 +64,32 @@ func main() {
 	useTLS := flag.Bool("tls", false, "Connection uses TLS if true. Corresponding key and certificate path must be set as env vars.")
 	doMigrate := flag.Bool("doMigrate", false, "Run migrations")
 	migrationTarget := flag.Int("migrationTarget", 0, "Migrations target: Negative values mean downgrade.")
+	unlockUser := flag.String("unlockUser", "", "Unlock the account with this email address after too many failed logins, then exit.")
+	makeAdmin := flag.String("makeAdmin", "", "Give the account with this email address the admin role, then exit.")
 
 	flag.Parse()
 
-	app := &application{}
+	app := &application{now: time.Now}
 
 	app.setupLogger()
 	app.loadConfig()
+	app.checkSignupMode()
+	app.checkBaseURL()
 	app.connectDBModels()
+	app.setupAvatarDir()
+	app.setupViewCounter()
 	app.migrateDB(doMigrate, migrationTarget)
+	app.unlockAccount(unlockUser)
+	app.makeAdmin(makeAdmin)
+	app.setupLoginThrottle()
+	app.setupPasswordPolicy()
 	app.setupSessionManager()
 	app.loadTemplates()
 	app.setupFormDecoder()
+	app.setupPreviews()
+	app.setupMailer()
+	app.setupSigner()
+	app.setupOIDC()
 
 	tlsConfig := &tls.Config{
 		// There is no browser that supports TLS 1.3 and does not support SameSite cookies.
@@ -80,11 +124,39 @@ func (app *application) loadConfig() {
 
 	if configErr != nil {
 		app.logger.Error("Error loading config", "error", configErr)
+		os.Exit(1)
 	}
 
 	app.config = c
 }
 
+// checkSignupMode refuses to start with a signup mode that isn't recognised,
+// rather than guessing who should be allowed to sign up.
+func (app *application) checkSignupMode() {
+	c := app.config
+
+	switch c.SignupMode() {
+	case config.SignupOpen, config.SignupClosed, config.SignupInvite:
+	case config.SignupDomain:
+		if len(c.SignupDomains()) == 0 {
+			app.logger.Error("Signup is restricted to email domains but none are set")
+			os.Exit(1)
+		}
+	default:
+		app.logger.Error("Invalid signup mode", "mode", c.SignupMode())
+		os.Exit(1)
+	}
+}
+
+// checkBaseURL refuses to start without a base URL, as links in emails, such
+// as password reset links, can't safely be built without one.
+func (app *application) checkBaseURL() {
+	if app.config.BaseURL() == "" {
+		app.logger.Error("BASE_URL must be set, such as to http://localhost:4000")
+		os.Exit(1)
+	}
+}
+
 func (app *application) connectDBModels() {
 	conn, connErr := db.OpenDB(app.config.DatabasePath())
 
@@ -93,8 +165,60 @@ func (app *application) connectDBModels() {
 	}
 
 	app.dbConn = conn
+	app.apiTokens = &model.APITokenModel{DB: conn}
+	app.auditLog = &model.AuditLogModel{DB: conn}
+	app.avatars = &model.AvatarModel{DB: conn}
+	app.collections = &model.CollectionModel{DB: conn}
+	app.invites = &model.InviteModel{DB: conn}
+	app.organizations = &model.OrganizationModel{DB: conn}
+	app.passkeys = &model.PasskeyModel{DB: conn}
 	app.snippets = &model.SnippetModel{DB: conn}
-	app.users = &model.UserModel{DB: conn}
+	app.stats = &model.StatsModel{DB: conn}
+	app.tokens = &model.TokenModel{DB: conn}
+	app.twoFactor = &model.TwoFactorModel{DB: conn}
+	app.userSessions = &model.UserSessionModel{DB: conn}
+	app.users = &model.UserModel{DB: conn, PasswordParams: app.passwordParams()}
+}
+
+// setupAvatarDir stores avatars in the configured directory rather than the
+// database.
+func (app *application) setupAvatarDir() {
+	dir := app.config.AvatarDir()
+	if dir == "" {
+		return
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		app.logger.Error("Error creating avatar directory", "error", err)
+		os.Exit(1)
+	}
+
+	app.avatars = &model.AvatarFileModel{Dir: dir}
+}
+
+func (app *application) passwordParams() password.Params {
+	c := app.config
+
+	if c.Argon2Memory() < 8*c.Argon2Parallelism() || c.Argon2Iterations() < 1 ||
+		c.Argon2Parallelism() < 1 || c.Argon2Parallelism() > math.MaxUint8 {
+		app.logger.Error("Invalid argon2 parameters", "memory", c.Argon2Memory(), "iterations", c.Argon2Iterations(),
+			"parallelism", c.Argon2Parallelism())
+		os.Exit(1)
+	}
+
+	params := password.DefaultParams
+	params.Memory = uint32(c.Argon2Memory())
+	params.Iterations = uint32(c.Argon2Iterations())
+	params.Parallelism = uint8(c.Argon2Parallelism())
+
+	return params
+}
+
+func (app *application) setupViewCounter() {
+	app.views = newViewCounter(app.snippets.AddViews, time.Hour, app.logger)
+
+	go app.views.run(10 * time.Second)
 }
 
 func (app *application) migrateDB(doMigrate *bool, target *int) {
@@ -118,11 +242,101 @@ func (app *application) migrateDB(doMigrate *bool, target *int) {
 
 }
 
+// unlockAccount lets an administrator clear a lockout from the command line.
+// The server isn't started afterwards.
+func (app *application) unlockAccount(email *string) {
+	if *email == "" {
+		return
+	}
+
+	user, err := app.users.GetByEmail(*email)
+	if err != nil {
+		app.logger.Error("Error finding account to unlock", "email", *email, "error", err)
+		os.Exit(1)
+	}
+
+	err = app.users.ResetLoginFailures(user.ID)
+	if err != nil {
+		app.logger.Error("Error unlocking account", "email", *email, "error", err)
+		os.Exit(1)
+	}
+
+	app.auditCommandLine(model.AuditAdminUserUnlock, user.ID, nil)
+
+	app.logger.Info("Unlocked account", "userID", user.ID, "email", user.Email)
+	os.Exit(0)
+}
+
+// makeAdmin gives an account the admin role from the command line, which is
+// how the first admin is created. The server isn't started afterwards.
+func (app *application) makeAdmin(email *string) {
+	if *email == "" {
+		return
+	}
+
+	user, err := app.users.GetByEmail(*email)
+	if err != nil {
+		app.logger.Error("Error finding account to make admin", "email", *email, "error", err)
+		os.Exit(1)
+	}
+
+	err = app.users.SetRole(user.ID, model.RoleAdmin)
+	if err != nil {
+		app.logger.Error("Error making account admin", "email", *email, "error", err)
+		os.Exit(1)
+	}
+
+	app.auditCommandLine(model.AuditAdminUserRole, user.ID, map[string]string{"from": user.Role, "to": model.RoleAdmin})
+
+	app.logger.Info("Made account admin", "userID", user.ID, "email", user.Email)
+	os.Exit(0)
+}
+
+// auditCommandLine records an admin action taken from the command line, which
+// whoever runs the server may take without logging in.
+func (app *application) auditCommandLine(action string, targetID int, details map[string]string) {
+	if details == nil {
+		details = map[string]string{}
+	}
+	details["via"] = "command line"
+
+	err := app.auditLog.Insert(model.AuditEvent{Action: action, TargetID: targetID, Details: details})
+	if err != nil {
+		app.logger.Error("Error recording audit event", "action", action, "error", err)
+	}
+}
+
+func (app *application) setupLoginThrottle() {
+	app.ipThrottle = newLoginThrottle(ipLockoutThreshold, lockoutMax)
+	// Email addresses without an account are locked out just like accounts, so
+	// that the lockout doesn't give away which addresses are registered.
+	app.emailThrottle = newLoginThrottle(accountLockoutThreshold, lockoutMax)
+
+	go app.ipThrottle.run(time.Minute)
+	go app.emailThrottle.run(time.Minute)
+}
+
+func (app *application) setupPasswordPolicy() {
+	c := app.config
+
+	app.passwordPolicy = &validator.PasswordPolicy{
+		MinLength:  c.PasswordMinLength(),
+		MinEntropy: float64(c.PasswordMinEntropy()),
+	}
+
+	if c.BreachedPasswordsPath() != "" {
+		app.passwordPolicy.Breached = validator.NewBreachedPasswords(os.DirFS(c.BreachedPasswordsPath()))
+	}
+}
+
 func (app *application) setupSessionManager() {
 	sessionManager := scs.New()
 	sessionManager.Store = sqlite3store.New(app.dbConn)
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Lifetime = app.config.SessionLifetime()
 	sessionManager.Cookie.Secure = true
+	// Session cookies only outlive the browser when the user asks to be
+	// remembered on login.
+	sessionManager.Cookie.Persist = false
 
 	app.sessionManager = sessionManager
 }
@@ -153,3 +367,54 @@ func (app *application) setupFormDecoder() {
 
 	app.formDecoder = fd
 }
+
+func (app *application) setupPreviews() {
+	previews, err := ogimage.New(app.config.PreviewsPath())
+
+	if err != nil {
+		app.logger.Error("Error setting up preview images", "error", err)
+		os.Exit(1)
+	}
+
+	app.previews = previews
+}
+
+func (app *application) setupMailer() {
+	c := app.config
+
+	if c.SMTPHost() == "" {
+		app.logger.Warn("SMTP host isn't set, emails will be written to stdout")
+		app.mailer = mailer.NewLog(ui.Files, os.Stdout, c.SMTPSender())
+		return
+	}
+
+	app.mailer = mailer.NewSMTP(ui.Files, c.SMTPHost(), c.SMTPPort(), c.SMTPUsername(), c.SMTPPassword(), c.SMTPSender())
+}
+
+func (app *application) setupSigner() {
+	key := []byte(app.config.SecretKey())
+
+	if len(key) == 0 {
+		app.logger.Warn("Secret key isn't set, signed links will stop working when the server restarts")
+
+		key = make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			app.logger.Error("Error generating secret key", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	app.signer = signer.New(key)
+}
+
+// setupOIDC enables single sign-on if an identity provider is configured.
+func (app *application) setupOIDC() {
+	c := app.config
+
+	if c.OIDCIssuer() == "" {
+		return
+	}
+
+	app.oidc = oidc.New(c.OIDCIssuer(), c.OIDCClientID(), c.OIDCClientSecret(), nil)
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/justinas/nosurf"
+	"github.com/thisisjab/snippetbox-go/internal/model"
 	"net/http"
+	"strings"
 )
 
 func commonHeaders(next http.Handler) http.Handler {
@@ -23,6 +26,33 @@ func commonHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// embedHeaders replaces the framing policy set by commonHeaders so that the
+// response can be shown in an iframe on any site. It also allows the inline
+// styles embeds use to stay self-contained.
+func embedHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy",
+			"default-src 'none'; style-src 'unsafe-inline'; frame-ancestors *")
+
+		w.Header().Del("X-Frame-Options")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody caps the size of request bodies. It has to come before noSurf on
+// routes which take file uploads, since checking the CSRF token of a multipart
+// form reads the whole body.
+func limitBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
@@ -70,6 +100,41 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// requireVerified stops users who haven't verified their email address yet. It
+// must come after requireAuthentication.
+func (app *application) requireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.users.Get(app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		if !user.Verified {
+			app.sessionManager.Put(r.Context(), "flash", "Please verify your email address first.")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireRole stops users who don't have role or a more powerful one. It must
+// come after requireAuthentication.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.authenticatedUser(r).HasRole(role) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := app.sessionManager.GetInt(r.Context(), "userID")
@@ -78,14 +143,45 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		exists, err := app.users.Exists(id)
-		if err != nil {
+		if app.sessionIdle(r) {
+			err := app.endSession(r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			app.sessionManager.Put(r.Context(), "flash", "You were logged out because you were inactive for too long.")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := app.users.Get(id)
+		if err != nil && !errors.Is(err, model.ErrNoRecord) {
 			app.serverError(w, r, err)
 			return
 		}
 
-		if exists {
+		if err == nil && user.Disabled {
+			err = app.endSession(r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			app.sessionManager.Put(r.Context(), "flash", "Your account has been disabled.")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err == nil {
+			err = app.touchSession(r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserContextKey, user)
 			r = r.WithContext(ctx)
 		}
 
@@ -93,6 +189,57 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// requireAPIToken authenticates API requests by the token in their
+// Authorization header instead of a session, and stops those whose token
+// doesn't allow scope.
+func (app *application) requireAPIToken(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Authorization")
+			w.Header().Set("Cache-Control", "no-store")
+
+			plaintext, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || plaintext == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="snippetbox"`)
+				app.errorJSON(w, r, http.StatusUnauthorized, "An API token is required.")
+				return
+			}
+
+			token, err := app.apiTokens.Authenticate(strings.TrimSpace(plaintext))
+			if err != nil {
+				if errors.Is(err, model.ErrNoRecord) {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="snippetbox", error="invalid_token"`)
+					app.errorJSON(w, r, http.StatusUnauthorized, "This API token is invalid or has expired.")
+				} else {
+					app.serverErrorJSON(w, r, err)
+				}
+				return
+			}
+
+			user, err := app.users.Get(token.UserID)
+			if err != nil {
+				app.serverErrorJSON(w, r, err)
+				return
+			}
+
+			if user.Disabled {
+				app.errorJSON(w, r, http.StatusForbidden, "This account has been disabled.")
+				return
+			}
+
+			if !token.Allows(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="snippetbox", error="insufficient_scope", scope="%s"`, scope))
+				app.errorJSON(w, r, http.StatusForbidden, "This API token doesn't allow "+scope+" access.")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), apiTokenContextKey, token)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
 	csrfHandler.SetBaseCookie(http.Cookie{
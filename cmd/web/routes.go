@@ -2,6 +2,7 @@ package main
 
 import (
 	"github.com/justinas/alice"
+	"github.com/thisisjab/snippetbox-go/internal/model"
 	"github.com/thisisjab/snippetbox-go/ui"
 	"net/http"
 )
@@ -11,6 +12,14 @@ func (app *application) routes() http.Handler {
 
 	mux.Handle("GET /static/", http.FileServerFS(ui.Files))
 	mux.Handle("GET /ping", http.HandlerFunc(app.ping))
+	mux.Handle("GET /oembed", http.HandlerFunc(app.oEmbed))
+	mux.Handle("GET /snippets/view/{id}/og.png", http.HandlerFunc(app.snippetPreviewImage))
+	mux.Handle("GET /u/{username}/avatar.png", http.HandlerFunc(app.userAvatar))
+
+	embeddable := alice.New(embedHeaders)
+
+	mux.Handle("GET /snippets/embed/{id}", embeddable.ThenFunc(app.embedSnippet))
+	mux.Handle("GET /snippets/embed/{id}/script.js", http.HandlerFunc(app.embedSnippetScript))
 
 	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
 
@@ -18,14 +27,101 @@ func (app *application) routes() http.Handler {
 	mux.Handle("POST /user/signup", dynamic.ThenFunc(app.userSignupPost))
 	mux.Handle("GET /user/login", dynamic.ThenFunc(app.userLogin))
 	mux.Handle("POST /user/login", dynamic.ThenFunc(app.userLoginPost))
+	mux.Handle("POST /user/login/passkey/begin", dynamic.ThenFunc(app.userLoginPasskeyBeginPost))
+	mux.Handle("POST /user/login/passkey/finish", dynamic.ThenFunc(app.userLoginPasskeyFinishPost))
+	mux.Handle("GET /user/login/sso", dynamic.ThenFunc(app.userLoginSSO))
+	mux.Handle("GET /user/login/sso/callback", dynamic.ThenFunc(app.userLoginSSOCallback))
+	mux.Handle("GET /user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactor))
+	mux.Handle("POST /user/login/2fa", dynamic.ThenFunc(app.userLoginTwoFactorPost))
+	mux.Handle("GET /user/verify", dynamic.ThenFunc(app.userVerify))
+	mux.Handle("GET /user/email/confirm", dynamic.ThenFunc(app.userEmailConfirm))
+	mux.Handle("GET /user/forgot", dynamic.ThenFunc(app.userForgotPassword))
+	mux.Handle("POST /user/forgot", dynamic.ThenFunc(app.userForgotPasswordPost))
+	mux.Handle("GET /user/reset/{token}", dynamic.ThenFunc(app.userResetPassword))
+	mux.Handle("POST /user/reset/{token}", dynamic.ThenFunc(app.userResetPasswordPost))
 
 	authRequired := dynamic.Append(app.requireAuthentication)
-	mux.Handle("GET /snippets/create", authRequired.ThenFunc(app.createSnippet))
-	mux.Handle("POST /snippets/create", authRequired.ThenFunc(app.snippetCreatePost))
+	verifiedRequired := authRequired.Append(app.requireVerified)
+	mux.Handle("GET /snippets/create", verifiedRequired.ThenFunc(app.createSnippet))
+	mux.Handle("POST /snippets/create", verifiedRequired.ThenFunc(app.snippetCreatePost))
+	mux.Handle("GET /snippets/edit/{id}", verifiedRequired.ThenFunc(app.editSnippet))
+	mux.Handle("POST /snippets/edit/{id}", verifiedRequired.ThenFunc(app.snippetEditPost))
 	mux.Handle("POST /user/logout", authRequired.ThenFunc(app.userLogoutPost))
+	mux.Handle("POST /user/verify/resend", authRequired.ThenFunc(app.userVerifyResendPost))
+	mux.Handle("GET /account/view", authRequired.ThenFunc(app.accountView))
+	mux.Handle("GET /account/profile", authRequired.ThenFunc(app.accountProfile))
+	mux.Handle("POST /account/profile", authRequired.ThenFunc(app.accountProfilePost))
+	mux.Handle("POST /account/avatar/delete", authRequired.ThenFunc(app.accountAvatarDeletePost))
+	mux.Handle("GET /account/email", authRequired.ThenFunc(app.accountEmail))
+	mux.Handle("POST /account/email", authRequired.ThenFunc(app.accountEmailPost))
+	mux.Handle("GET /account/email/approve", authRequired.ThenFunc(app.accountEmailApprove))
+	mux.Handle("GET /account/export", authRequired.ThenFunc(app.accountExport))
+	mux.Handle("GET /account/delete", authRequired.ThenFunc(app.accountDelete))
+	mux.Handle("POST /account/delete", authRequired.ThenFunc(app.accountDeletePost))
+	mux.Handle("GET /account/delete/confirm", authRequired.ThenFunc(app.accountDeleteConfirm))
+	mux.Handle("GET /account/password/update", authRequired.ThenFunc(app.accountPasswordUpdate))
+	mux.Handle("POST /account/password/update", authRequired.ThenFunc(app.accountPasswordUpdatePost))
+	mux.Handle("POST /account/sessions/revoke/{id}", authRequired.ThenFunc(app.accountSessionRevokePost))
+	mux.Handle("POST /account/sessions/revoke-others", authRequired.ThenFunc(app.accountSessionRevokeOthersPost))
+	mux.Handle("GET /account/2fa", authRequired.ThenFunc(app.accountTwoFactor))
+	mux.Handle("GET /account/2fa/qr.png", authRequired.ThenFunc(app.accountTwoFactorQR))
+	mux.Handle("POST /account/2fa/enable", authRequired.ThenFunc(app.accountTwoFactorEnablePost))
+	mux.Handle("POST /account/2fa/disable", authRequired.ThenFunc(app.accountTwoFactorDisablePost))
+	mux.Handle("GET /account/passkeys", authRequired.ThenFunc(app.accountPasskeys))
+	mux.Handle("POST /account/passkeys/register/begin", authRequired.ThenFunc(app.accountPasskeyRegisterBeginPost))
+	mux.Handle("POST /account/passkeys/register/finish", authRequired.ThenFunc(app.accountPasskeyRegisterFinishPost))
+	mux.Handle("POST /account/passkeys/delete/{id}", authRequired.ThenFunc(app.accountPasskeyDeletePost))
+	mux.Handle("GET /account/tokens", authRequired.ThenFunc(app.accountAPITokens))
+	mux.Handle("POST /account/tokens/create", authRequired.ThenFunc(app.accountAPITokenCreatePost))
+	mux.Handle("POST /account/tokens/delete/{id}", authRequired.ThenFunc(app.accountAPITokenDeletePost))
+	mux.Handle("GET /collections", authRequired.ThenFunc(app.collectionList))
+	mux.Handle("GET /collections/create", authRequired.ThenFunc(app.createCollection))
+	mux.Handle("POST /collections/create", authRequired.ThenFunc(app.collectionCreatePost))
+	mux.Handle("GET /collections/edit/{id}", authRequired.ThenFunc(app.editCollection))
+	mux.Handle("POST /collections/edit/{id}/add", authRequired.ThenFunc(app.collectionAddSnippetPost))
+	mux.Handle("POST /collections/edit/{id}/remove", authRequired.ThenFunc(app.collectionRemoveSnippetPost))
+	mux.Handle("POST /collections/edit/{id}/reorder", authRequired.ThenFunc(app.collectionReorderPost))
+	mux.Handle("GET /orgs", authRequired.ThenFunc(app.orgList))
+	mux.Handle("POST /orgs/create", authRequired.ThenFunc(app.orgCreatePost))
+	mux.Handle("POST /orgs/{id}/invites/create", authRequired.ThenFunc(app.orgInvitePost))
+	mux.Handle("GET /orgs/invites/accept", authRequired.ThenFunc(app.orgInviteAccept))
+	mux.Handle("POST /orgs/invites/accept", authRequired.ThenFunc(app.orgInviteAcceptPost))
+	mux.Handle("POST /orgs/{id}/members/{userID}/role", authRequired.ThenFunc(app.orgMemberRolePost))
+	mux.Handle("POST /orgs/{id}/members/{userID}/remove", authRequired.ThenFunc(app.orgMemberRemovePost))
+
+	// The body limit leaves room for the rest of the form, and for a file which
+	// is too large to be reported as such rather than failing the CSRF check.
+	uploads := alice.New(limitBody(2 * avatarMaxBytes)).Extend(authRequired)
+	mux.Handle("POST /account/avatar", uploads.ThenFunc(app.accountAvatarPost))
+
+	moderatorRequired := authRequired.Append(app.requireRole(model.RoleModerator))
+	adminRequired := authRequired.Append(app.requireRole(model.RoleAdmin))
+	mux.Handle("GET /admin", moderatorRequired.ThenFunc(app.adminDashboard))
+	mux.Handle("GET /admin/snippets", moderatorRequired.ThenFunc(app.adminSnippets))
+	mux.Handle("POST /admin/snippets/{id}/delete", moderatorRequired.ThenFunc(app.adminSnippetDeletePost))
+	mux.Handle("GET /admin/users", adminRequired.ThenFunc(app.adminUsers))
+	mux.Handle("POST /admin/users/{id}/disable", adminRequired.ThenFunc(app.adminUserDisablePost))
+	mux.Handle("POST /admin/users/{id}/enable", adminRequired.ThenFunc(app.adminUserEnablePost))
+	mux.Handle("POST /admin/users/{id}/unlock", adminRequired.ThenFunc(app.adminUserUnlockPost))
+	mux.Handle("POST /admin/users/{id}/role", adminRequired.ThenFunc(app.adminUserRolePost))
+	mux.Handle("GET /admin/invites", adminRequired.ThenFunc(app.adminInvites))
+	mux.Handle("POST /admin/invites/create", adminRequired.ThenFunc(app.adminInviteCreatePost))
+	mux.Handle("POST /admin/invites/{id}/delete", adminRequired.ThenFunc(app.adminInviteDeletePost))
+	mux.Handle("GET /admin/audit", adminRequired.ThenFunc(app.adminAudit))
+	mux.Handle("GET /admin/audit/export", adminRequired.ThenFunc(app.adminAuditExport))
 
 	mux.Handle("GET /{$}", dynamic.ThenFunc(app.home))
 	mux.Handle("GET /snippets/view/{id}", dynamic.ThenFunc(app.showSnippet))
+	mux.Handle("GET /collections/view/{id}", dynamic.ThenFunc(app.showCollection))
+	mux.Handle("GET /orgs/view/{id}", dynamic.ThenFunc(app.showOrg))
+	mux.Handle("GET /u/{username}", dynamic.ThenFunc(app.userProfile))
+
+	// The API is used by scripts rather than browsers, so it's authenticated
+	// by bearer tokens instead of session cookies and doesn't need CSRF
+	// protection.
+	mux.Handle("GET /api/snippets", alice.New(app.requireAPIToken(model.APIScopeRead)).ThenFunc(app.apiSnippetList))
+	mux.Handle("GET /api/snippets/{id}", alice.New(app.requireAPIToken(model.APIScopeRead)).ThenFunc(app.apiSnippetView))
+	mux.Handle("POST /api/snippets", alice.New(app.requireAPIToken(model.APIScopeWrite)).ThenFunc(app.apiSnippetCreate))
 
 	standard := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
 
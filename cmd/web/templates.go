@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/base64"
+	"fmt"
 	"github.com/thisisjab/snippetbox-go/internal/model"
 	"github.com/thisisjab/snippetbox-go/ui"
 	"html/template"
@@ -10,13 +12,40 @@ import (
 )
 
 type templateData struct {
-	CurrentYear     int
-	Snippet         model.Snippet
-	Snippets        []model.Snippet
-	Flash           string
-	Form            any
-	IsAuthenticated bool
-	CSRFToken       string
+	CurrentYear         int
+	Snippet             model.Snippet
+	Snippets            []model.Snippet
+	PopularSnippets     []model.Snippet
+	Collection          model.Collection
+	Collections         []model.Collection
+	User                model.User
+	Flash               string
+	Form                any
+	IsAuthenticated     bool
+	CSRFToken           string
+	Token               string
+	BaseURL             string
+	TwoFactorEnabled    bool
+	RecoveryCodes       []string
+	Passkeys            []model.Passkey
+	APITokens           []model.APIToken
+	Sessions            []model.UserSession
+	CurrentSessionToken string
+	SSOProvider         string
+	IsModerator         bool
+	IsAdmin             bool
+	Stats               model.Stats
+	Users               []model.User
+	Invites             []model.Invite
+	SignupMode          string
+	SignupDomains       []string
+	CanEdit             bool
+	Organization        model.Organization
+	Organizations       []model.Organization
+	Members             []model.OrganizationMember
+	OrgRole             string
+	AuditEvents         []model.AuditEvent
+	AuditActions        []string
 }
 
 func humanDateTime(t time.Time) string {
@@ -27,8 +56,62 @@ func humanDateTime(t time.Time) string {
 	return t.Format("2006-01-02 3:04 PM")
 }
 
+// truncate shortens s to at most n characters, ending it with an ellipsis if
+// anything was cut off.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+
+	return string(runes[:n-1]) + "…"
+}
+
+// base64url encodes binary IDs, such as passkey credential IDs, for use in
+// URLs.
+func base64url(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// humanBytes formats a size in bytes with a binary unit, such as "1.5 MiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// avatarURL links to the user's avatar at one of avatar.Sizes. Once they've
+// uploaded one the link includes when, so that it changes with each upload and
+// the image can be cached for a long time.
+func avatarURL(u model.User, size int) string {
+	url := fmt.Sprintf("/u/%s/avatar.png?s=%d", u.Username, size)
+	if !u.AvatarUpdated.IsZero() {
+		url += fmt.Sprintf("&v=%d", u.AvatarUpdated.Unix())
+	}
+
+	return url
+}
+
+func inc(i int) int {
+	return i + 1
+}
+
 var funcMap = template.FuncMap{
+	"avatarURL":     avatarURL,
+	"base64url":     base64url,
+	"humanBytes":    humanBytes,
 	"humanDateTime": humanDateTime,
+	"inc":           inc,
+	"truncate":      truncate,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -54,5 +137,23 @@ func newTemplateCache() (map[string]*template.Template, error) {
 
 		cache[name] = ts
 	}
+
+	// Embeds are meant to be shown inside other sites, so they're parsed on
+	// their own without the base layout and partials.
+	embeds, err := fs.Glob(ui.Files, "html/embeds/*.gohtml")
+	if err != nil {
+		return nil, err
+	}
+	for _, embed := range embeds {
+		name := filepath.Base(embed)
+
+		ts, err := template.New(name).Funcs(funcMap).ParseFS(ui.Files, embed)
+		if err != nil {
+			return nil, err
+		}
+
+		cache[name] = ts
+	}
+
 	return cache, nil
 }
@@ -31,3 +31,21 @@ func TestHumanDate(t *testing.T) {
 	}
 
 }
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name, s, want string
+		n             int
+	}{
+		{name: "Short", s: "pond", n: 10, want: "pond"},
+		{name: "Exact", s: "pond", n: 4, want: "pond"},
+		{name: "Long", s: "An old silent pond", n: 10, want: "An old si…"},
+		{name: "Multibyte", s: "古池や蛙飛び込む水の音", n: 4, want: "古池や…"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, truncate(tt.s, tt.n), tt.want)
+		})
+	}
+}
@@ -2,29 +2,63 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"github.com/alexedwards/scs/v2/memstore"
+	"github.com/thisisjab/snippetbox-go/internal/mailer"
 	"github.com/thisisjab/snippetbox-go/internal/model/mock"
+	"github.com/thisisjab/snippetbox-go/internal/ogimage"
+	"github.com/thisisjab/snippetbox-go/ui"
 	"html"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"regexp"
 	"testing"
+	"time"
 )
 
 func newTestApplication(t *testing.T) *application {
 	app := &application{
-		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
-		users:    &mock.UserModel{},
-		snippets: &mock.SnippetModel{},
+		apiTokens:     &mock.APITokenModel{},
+		auditLog:      &mock.AuditLogModel{},
+		avatars:       &mock.AvatarModel{},
+		collections:   &mock.CollectionModel{},
+		invites:       &mock.InviteModel{},
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		mailer:        mailer.NewLog(ui.Files, io.Discard, "test@example.com"),
+		now:           time.Now,
+		organizations: &mock.OrganizationModel{},
+		passkeys:      &mock.PasskeyModel{},
+		users:         &mock.UserModel{},
+		userSessions:  &mock.UserSessionModel{},
+		snippets:      &mock.SnippetModel{},
+		stats:         &mock.StatsModel{},
+		tokens:        &mock.TokenModel{},
+		twoFactor:     &mock.TwoFactorModel{},
 	}
 
+	app.views = newViewCounter(app.snippets.AddViews, time.Hour, app.logger)
+
+	app.loadConfig()
+	app.ipThrottle = newLoginThrottle(ipLockoutThreshold, lockoutMax)
+	app.emailThrottle = newLoginThrottle(accountLockoutThreshold, lockoutMax)
+	app.setupPasswordPolicy()
+	app.setupSigner()
 	app.setupSessionManager()
+	app.sessionManager.Store = memstore.New()
 	app.setupFormDecoder()
 	app.loadTemplates()
 
+	previews, err := ogimage.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.previews = previews
+
 	return app
 }
 
@@ -104,3 +138,147 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 
 	return rs.StatusCode, rs.Header, string(body)
 }
+
+// postFile submits a multipart form with the file in the given field, as a
+// browser uploading it would.
+func (ts *testServer) postFile(t *testing.T, urlPath string, form url.Values, field, filename string, file []byte) (int, http.Header, string) {
+	var buf bytes.Buffer
+
+	mw := multipart.NewWriter(&buf)
+
+	for k, vs := range form {
+		for _, v := range vs {
+			err := mw.WriteField(k, v)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	fw, err := mw.CreateFormFile(field, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = fw.Write(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := ts.Client().Post(ts.URL+urlPath, mw.FormDataContentType(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(bytes.TrimSpace(body))
+}
+
+// sessionCookie returns the session cookie set by a response with headers.
+func sessionCookie(t *testing.T, headers http.Header) *http.Cookie {
+	for _, cookie := range (&http.Response{Header: headers}).Cookies() {
+		if cookie.Name == "session" {
+			return cookie
+		}
+	}
+
+	t.Fatal("no session cookie set")
+	return nil
+}
+
+// login signs in through the login form so that later requests made by the
+// test server's client are authenticated.
+func (ts *testServer) login(t *testing.T, email, password string) {
+	_, _, body := ts.get(t, "/user/login")
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login as %s: got status %d", email, code)
+	}
+}
+
+// postJSON sends data as a JSON body with the CSRF token in a header, the way
+// the site's scripts do, and decodes the JSON response into dst if it's not nil.
+func (ts *testServer) postJSON(t *testing.T, urlPath, csrfToken string, data, dst any) int {
+	body, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+urlPath, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CSRF-Token", csrfToken)
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+
+	if dst != nil {
+		err = json.NewDecoder(rs.Body).Decode(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return rs.StatusCode
+}
+
+// apiRequest calls the API with token as its bearer token, sending data as a
+// JSON body if it's not nil. The client's cookies are left out, the way
+// scripts call the API.
+func (ts *testServer) apiRequest(t *testing.T, method, urlPath, token string, data any) (int, http.Header, string) {
+	var reqBody io.Reader
+	if data != nil {
+		js, err := json.Marshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(js)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Transport: ts.Client().Transport}
+
+	rs, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, string(bytes.TrimSpace(body))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// accountLockoutThreshold is how many failed logins in a row an account
+	// allows before it's locked.
+	accountLockoutThreshold = 5
+	// ipLockoutThreshold is how many failed logins an IP address may make
+	// before it's blocked. It's higher than the account threshold because
+	// several people can share an address.
+	ipLockoutThreshold = 20
+
+	lockoutBase = time.Minute
+	lockoutMax  = time.Hour
+)
+
+// lockoutDuration returns how long to lock out after the given number of
+// failures: nothing below threshold, then lockoutBase doubling with every
+// further failure up to lockoutMax.
+func lockoutDuration(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+
+	n := failures - threshold
+	if n >= 32 {
+		return lockoutMax
+	}
+
+	return min(lockoutBase<<n, lockoutMax)
+}
+
+// lockoutMessage tells the user when they can try logging in again.
+func lockoutMessage(until time.Time) string {
+	minutes := int(math.Ceil(time.Until(until).Minutes()))
+	if minutes <= 1 {
+		return "Too many failed login attempts. Please try again in a minute."
+	}
+
+	return fmt.Sprintf("Too many failed login attempts. Please try again in %d minutes.", minutes)
+}
+
+// loginThrottle counts failed logins per key, such as an IP address, in
+// memory. Keys are locked out with exponential backoff once they pass the
+// threshold, and forgotten after going quiet for a while: a key's count starts
+// over on its next failure, and run sweeps out keys which never fail again.
+type loginThrottle struct {
+	mu        sync.Mutex
+	entries   map[string]*throttleEntry
+	threshold int
+	forget    time.Duration
+}
+
+type throttleEntry struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+func newLoginThrottle(threshold int, forget time.Duration) *loginThrottle {
+	return &loginThrottle{
+		entries:   make(map[string]*throttleEntry),
+		threshold: threshold,
+		forget:    forget,
+	}
+}
+
+// LockedUntil returns when the key's lockout ends, or the zero time if it
+// isn't locked out.
+func (t *loginThrottle) LockedUntil(key string) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok || !time.Now().Before(e.lockedUntil) {
+		return time.Time{}
+	}
+
+	return e.lockedUntil
+}
+
+// Fail records a failed login for the key. If that locks the key out it
+// returns when the lockout ends, otherwise the zero time.
+func (t *loginThrottle) Fail(key string) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	e, ok := t.entries[key]
+	if !ok || t.stale(e, now) {
+		e = &throttleEntry{}
+		t.entries[key] = e
+	}
+
+	e.failures++
+	e.last = now
+
+	d := lockoutDuration(e.failures, t.threshold)
+	if d == 0 {
+		return time.Time{}
+	}
+
+	e.lockedUntil = now.Add(d)
+
+	return e.lockedUntil
+}
+
+// Sweep forgets the keys which have gone quiet.
+func (t *loginThrottle) Sweep() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	for k, e := range t.entries {
+		if t.stale(e, now) {
+			delete(t.entries, k)
+		}
+	}
+}
+
+// stale reports whether the entry's failures are old enough to forget and it
+// isn't locked out.
+func (t *loginThrottle) stale(e *throttleEntry, now time.Time) bool {
+	return now.Sub(e.last) >= t.forget && !now.Before(e.lockedUntil)
+}
+
+// run sweeps the throttle every interval. It never returns.
+func (t *loginThrottle) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.Sweep()
+	}
+}
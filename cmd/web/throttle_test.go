@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestLockoutDuration(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 4, want: 0},
+		{failures: 5, want: time.Minute},
+		{failures: 6, want: 2 * time.Minute},
+		{failures: 8, want: 8 * time.Minute},
+		{failures: 11, want: time.Hour},
+		{failures: 100, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, lockoutDuration(tt.failures, 5), tt.want)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	throttle := newLoginThrottle(3, time.Hour)
+
+	assert.Equal(t, throttle.Fail("10.0.0.1").IsZero(), true)
+	assert.Equal(t, throttle.Fail("10.0.0.1").IsZero(), true)
+	assert.Equal(t, throttle.LockedUntil("10.0.0.1").IsZero(), true)
+
+	until := throttle.Fail("10.0.0.1")
+	assert.Equal(t, until.IsZero(), false)
+	assert.Equal(t, throttle.LockedUntil("10.0.0.1"), until)
+
+	// Other addresses aren't affected.
+	assert.Equal(t, throttle.LockedUntil("10.0.0.2").IsZero(), true)
+
+	// Each further failure doubles the lockout.
+	next := throttle.Fail("10.0.0.1")
+	assert.Equal(t, next.Sub(until) > time.Minute-time.Second, true)
+}
+
+func TestLoginThrottleForget(t *testing.T) {
+	throttle := newLoginThrottle(3, time.Millisecond)
+
+	throttle.Fail("10.0.0.1")
+	throttle.Fail("10.0.0.1")
+	time.Sleep(2 * time.Millisecond)
+
+	// Failures which have gone quiet start over instead of adding up.
+	assert.Equal(t, throttle.Fail("10.0.0.1").IsZero(), true)
+	assert.Equal(t, throttle.Fail("10.0.0.1").IsZero(), true)
+
+	// Locked out keys are kept until the lockout ends, however quiet.
+	assert.Equal(t, throttle.Fail("10.0.0.1").IsZero(), false)
+	time.Sleep(2 * time.Millisecond)
+	throttle.Sweep()
+	assert.Equal(t, throttle.LockedUntil("10.0.0.1").IsZero(), false)
+
+	throttle.Fail("10.0.0.2")
+	time.Sleep(2 * time.Millisecond)
+	throttle.Sweep()
+
+	_, ok := throttle.entries["10.0.0.2"]
+	assert.Equal(t, ok, false)
+}
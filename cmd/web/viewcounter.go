@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// viewCounter buffers snippet views in memory so that showing a snippet never
+// waits on a database write. Buffered views are written in one batch by Flush,
+// which run calls periodically.
+//
+// Views are deduplicated per viewer: the same viewer seeing the same snippet
+// again within window isn't counted a second time.
+type viewCounter struct {
+	mu      sync.Mutex
+	pending map[int]int
+	seen    map[viewKey]time.Time
+	window  time.Duration
+	store   func(views map[int]int) error
+	logger  *slog.Logger
+}
+
+type viewKey struct {
+	viewer    string
+	snippetID int
+}
+
+func newViewCounter(store func(views map[int]int) error, window time.Duration, logger *slog.Logger) *viewCounter {
+	return &viewCounter{
+		pending: make(map[int]int),
+		seen:    make(map[viewKey]time.Time),
+		window:  window,
+		store:   store,
+		logger:  logger,
+	}
+}
+
+// Add records a view of the snippet by viewer, unless the viewer has already
+// been counted within the window. It reports whether the view was counted.
+func (c *viewCounter) Add(viewer string, snippetID int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := viewKey{viewer: viewer, snippetID: snippetID}
+	now := time.Now()
+
+	if last, ok := c.seen[key]; ok && now.Sub(last) < c.window {
+		return false
+	}
+
+	c.seen[key] = now
+	c.pending[snippetID]++
+
+	return true
+}
+
+// Pending returns the number of views of the snippet which haven't been
+// flushed yet.
+func (c *viewCounter) Pending(snippetID int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.pending[snippetID]
+}
+
+// Flush writes the buffered views to the store. If the write fails the views
+// are put back so that they're retried on the next flush.
+func (c *viewCounter) Flush() error {
+	c.mu.Lock()
+	views := c.pending
+	c.pending = make(map[int]int)
+
+	for key, last := range c.seen {
+		if time.Since(last) >= c.window {
+			delete(c.seen, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if len(views) == 0 {
+		return nil
+	}
+
+	err := c.store(views)
+	if err != nil {
+		c.mu.Lock()
+		for id, n := range views {
+			c.pending[id] += n
+		}
+		c.mu.Unlock()
+	}
+
+	return err
+}
+
+// run flushes the buffered views every interval. It never returns.
+func (c *viewCounter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := c.Flush(); err != nil {
+			c.logger.Error("Error flushing snippet views", "error", err)
+		}
+	}
+}
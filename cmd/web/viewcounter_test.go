@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestViewCounter(t *testing.T) {
+	var stored map[int]int
+	storeErr := errors.New("database is down")
+	failing := true
+
+	store := func(views map[int]int) error {
+		if failing {
+			return storeErr
+		}
+		stored = views
+		return nil
+	}
+
+	c := newViewCounter(store, time.Hour, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	assert.Equal(t, c.Add("ip:10.0.0.1", 1), true)
+	assert.Equal(t, c.Add("ip:10.0.0.1", 1), false)
+	assert.Equal(t, c.Add("ip:10.0.0.2", 1), true)
+	assert.Equal(t, c.Add("ip:10.0.0.1", 2), true)
+	assert.Equal(t, c.Pending(1), 2)
+
+	// A failed flush keeps the views buffered for the next attempt.
+	assert.Equal(t, c.Flush(), storeErr)
+	assert.Equal(t, c.Pending(1), 2)
+
+	failing = false
+	assert.Equal(t, c.Flush(), nil)
+	assert.Equal(t, stored, map[int]int{1: 2, 2: 1})
+	assert.Equal(t, c.Pending(1), 0)
+
+	// Viewers are still deduplicated after a flush.
+	assert.Equal(t, c.Add("ip:10.0.0.1", 1), false)
+}
@@ -19,3 +19,12 @@ require (
 )
 
 require github.com/go-playground/assert v1.2.1
+
+require rsc.io/qr v0.2.0
+
+require golang.org/x/sys v0.30.0 // indirect
+
+require (
+	golang.org/x/image v0.24.0
+	golang.org/x/text v0.22.0 // indirect
+)
@@ -0,0 +1,86 @@
+// Package avatar turns images users upload into the square PNG avatars shown on
+// their profiles.
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/image/draw"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+)
+
+// Sizes avatars are stored at, in pixels square.
+const (
+	Small = 64
+	Large = 256
+)
+
+var Sizes = []int{Small, Large}
+
+// maxPixels bounds how large an image may be once decoded, so that a small,
+// highly compressed file can't exhaust memory.
+const maxPixels = 25_000_000
+
+var (
+	ErrUnsupportedFormat = errors.New("avatar: image isn't a PNG, JPEG or GIF")
+	ErrTooLarge          = errors.New("avatar: image dimensions are too large")
+)
+
+// Process decodes a PNG, JPEG or GIF image, crops it to a centred square and
+// scales it to each of Sizes. The results are encoded afresh as PNGs, so
+// nothing but the pixels survives: EXIF data such as locations is dropped.
+// Only the first frame of an animated GIF is kept.
+func Process(b []byte) (map[int][]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, ErrUnsupportedFormat
+		}
+		return nil, err
+	}
+
+	if format != "png" && format != "jpeg" && format != "gif" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	if cfg.Width < 1 || cfg.Height < 1 || cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	square := centreSquare(src.Bounds())
+
+	images := make(map[int][]byte, len(Sizes))
+
+	for _, size := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, square, draw.Src, nil)
+
+		var buf bytes.Buffer
+
+		err = png.Encode(&buf, dst)
+		if err != nil {
+			return nil, err
+		}
+
+		images[size] = buf.Bytes()
+	}
+
+	return images, nil
+}
+
+// centreSquare returns the largest square in the middle of r.
+func centreSquare(r image.Rectangle) image.Rectangle {
+	side := min(r.Dx(), r.Dy())
+	x := r.Min.X + (r.Dx()-side)/2
+	y := r.Min.Y + (r.Dy()-side)/2
+
+	return image.Rect(x, y, x+side, y+side)
+}
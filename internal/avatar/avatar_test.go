@@ -0,0 +1,87 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/go-playground/assert"
+)
+
+func TestProcess(t *testing.T) {
+	// A wide image with a red left half and a blue right half.
+	src := image.NewRGBA(image.Rect(0, 0, 300, 100))
+	for x := 0; x < 300; x++ {
+		for y := 0; y < 100; y++ {
+			c := color.RGBA{R: 0xFF, A: 0xFF}
+			if x >= 150 {
+				c = color.RGBA{B: 0xFF, A: 0xFF}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	encoders := map[string]func(*bytes.Buffer) error{
+		"PNG":  func(b *bytes.Buffer) error { return png.Encode(b, src) },
+		"JPEG": func(b *bytes.Buffer) error { return jpeg.Encode(b, src, nil) },
+		"GIF":  func(b *bytes.Buffer) error { return gif.Encode(b, src, nil) },
+	}
+
+	for name, encode := range encoders {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			err := encode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			images, err := Process(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, size := range Sizes {
+				img, err := png.Decode(bytes.NewReader(images[size]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, img.Bounds().Dx(), size)
+				assert.Equal(t, img.Bounds().Dy(), size)
+
+				// The centre square straddles both halves.
+				r, _, _, _ := img.At(1, size/2).RGBA()
+				_, _, b, _ := img.At(size-2, size/2).RGBA()
+				assert.Equal(t, r > 0xC000, true)
+				assert.Equal(t, b > 0xC000, true)
+			}
+		})
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	_, err := Process([]byte("<svg xmlns='http://www.w3.org/2000/svg'></svg>"))
+	assert.Equal(t, err, ErrUnsupportedFormat)
+
+	// A header claiming enormous dimensions is rejected before decoding.
+	var buf bytes.Buffer
+
+	err = png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite the IHDR chunk to claim 10000x10000 pixels, fixing its checksum.
+	b := buf.Bytes()
+	copy(b[16:24], []byte{0, 0, 0x27, 0x10, 0, 0, 0x27, 0x10})
+	binary.BigEndian.PutUint32(b[29:33], crc32.ChecksumIEEE(b[12:29]))
+
+	_, err = Process(b)
+	assert.Equal(t, err, ErrTooLarge)
+}
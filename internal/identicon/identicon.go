@@ -0,0 +1,63 @@
+// Package identicon draws user avatars: a symmetric pattern of squares whose
+// shape and colour come from a hash of a key, so that every user gets a
+// distinctive image which never changes.
+package identicon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+// grid is how many cells wide and high the pattern is.
+const grid = 5
+
+var background = color.RGBA{R: 0xF1, G: 0xF3, B: 0xF6, A: 0xFF}
+
+// Image returns the identicon for key, size pixels square.
+func Image(key string, size int) image.Image {
+	sum := sha256.Sum256([]byte(key))
+
+	// Keeping each channel away from the extremes avoids colours which are
+	// too pale to see against the background or too dark to tell apart.
+	fg := color.RGBA{R: 0x30 + sum[0]%0xA0, G: 0x30 + sum[1]%0xA0, B: 0x30 + sum[2]%0xA0, A: 0xFF}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: background}, image.Point{}, draw.Src)
+
+	// Half a cell of margin is left on every side.
+	cell := size / (grid + 1)
+	offset := (size - cell*grid) / 2
+
+	for row := 0; row < grid; row++ {
+		// Only the left half and middle column are read from the hash; the
+		// right half mirrors them.
+		for col := 0; col < (grid+1)/2; col++ {
+			if sum[3+row*grid+col]&1 == 0 {
+				continue
+			}
+
+			for _, c := range []int{col, grid - 1 - col} {
+				r := image.Rect(offset+c*cell, offset+row*cell, offset+(c+1)*cell, offset+(row+1)*cell)
+				draw.Draw(img, r, &image.Uniform{C: fg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	return img
+}
+
+// PNG returns the identicon for key encoded as a PNG.
+func PNG(key string, size int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	err := png.Encode(&buf, Image(key, size))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
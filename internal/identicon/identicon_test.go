@@ -0,0 +1,48 @@
+package identicon
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/go-playground/assert"
+)
+
+func TestPNG(t *testing.T) {
+	a, err := PNG("user:1", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, img.Bounds().Dx(), 120)
+	assert.Equal(t, img.Bounds().Dy(), 120)
+
+	again, err := PNG("user:1", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Equal(a, again), true)
+
+	other, err := PNG("user:2", 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Equal(a, other), false)
+}
+
+func TestImageIsSymmetric(t *testing.T) {
+	img := Image("user:1", 60)
+	size := img.Bounds().Dx()
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if img.At(x, y) != img.At(size-1-x, y) {
+				t.Fatalf("pixel (%d, %d) doesn't mirror (%d, %d)", x, y, size-1-x, y)
+			}
+		}
+	}
+}
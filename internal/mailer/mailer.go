@@ -0,0 +1,110 @@
+// Package mailer sends the plain text emails the site needs, such as password
+// reset links. Messages are built from templates which define a "subject" and
+// a "plainBody" template.
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/smtp"
+	"sync"
+	"text/template"
+	"time"
+)
+
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// SMTPMailer delivers messages through an SMTP server.
+type SMTPMailer struct {
+	templates fs.FS
+	addr      string
+	auth      smtp.Auth
+	sender    string
+}
+
+func NewSMTP(templates fs.FS, host, port, username, password, sender string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		templates: templates,
+		addr:      net.JoinHostPort(host, port),
+		auth:      auth,
+		sender:    sender,
+	}
+}
+
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := buildMessage(m.templates, m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(m.addr, m.auth, m.sender, []string{recipient}, msg)
+}
+
+// LogMailer writes messages to w instead of delivering them. It's meant for
+// development and tests, where the links in a message can be read back out.
+type LogMailer struct {
+	mu        sync.Mutex
+	templates fs.FS
+	w         io.Writer
+	sender    string
+}
+
+func NewLog(templates fs.FS, w io.Writer, sender string) *LogMailer {
+	return &LogMailer{templates: templates, w: w, sender: sender}
+}
+
+func (m *LogMailer) Send(recipient, templateFile string, data any) error {
+	msg, err := buildMessage(m.templates, m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = fmt.Fprintf(m.w, "%s\r\n\r\n", msg)
+
+	return err
+}
+
+func buildMessage(templates fs.FS, sender, recipient, templateFile string, data any) ([]byte, error) {
+	tmpl, err := template.New("email").ParseFS(templates, templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	body := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(body, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", sender)
+	fmt.Fprintf(msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject.String()))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(msg, "\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
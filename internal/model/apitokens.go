@@ -0,0 +1,164 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// Scopes of API tokens. Write tokens can read as well.
+const (
+	APIScopeRead  = "read"
+	APIScopeWrite = "write"
+)
+
+// apiTokenPrefix marks API tokens so that they're easy to recognise, for
+// example by secret scanners, when they leak.
+const apiTokenPrefix = "sbx_"
+
+type APITokenModelInterface interface {
+	Insert(userID int, name, scope string, ttl time.Duration) (string, error)
+	Authenticate(plaintext string) (APIToken, error)
+	ForUser(userID int) ([]APIToken, error)
+	Delete(userID, id int) error
+}
+
+// APIToken lets scripts use the API on behalf of a user without a browser
+// session.
+type APIToken struct {
+	ID       int
+	UserID   int
+	Name     string
+	Scope    string
+	Created  time.Time
+	Expires  time.Time
+	LastUsed time.Time
+}
+
+// Allows reports whether the token may be used for requests which need scope.
+func (t APIToken) Allows(scope string) bool {
+	return t.Scope == scope || t.Scope == APIScopeWrite
+}
+
+// APITokenModel stores API tokens. As with TokenModel, only a SHA-256 hash of
+// each token is kept.
+type APITokenModel struct {
+	DB *sql.DB
+}
+
+// Insert creates a token for the user which is valid for ttl and returns its
+// plaintext, which is the only time it's available.
+func (m *APITokenModel) Insert(userID int, name, scope string, ttl time.Duration) (string, error) {
+	randomBytes := make([]byte, 20)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := apiTokenPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `INSERT INTO api_tokens (user_id, name, hash, scope, created, expires)
+	VALUES (?, ?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'), datetime(strftime('%Y-%m-%d %H:%M:%S', 'now'), '+' || ? || ' seconds'))`
+
+	_, err = m.DB.Exec(stmt, userID, name, hash[:], scope, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// Authenticate returns the token with the given plaintext and records that it
+// was just used. ErrNoRecord is returned if it doesn't exist or has expired.
+func (m *APITokenModel) Authenticate(plaintext string) (APIToken, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `UPDATE api_tokens SET last_used = strftime('%Y-%m-%d %H:%M:%S', 'now')
+	WHERE hash = ? AND expires > current_timestamp
+	RETURNING id, user_id, name, scope, created, expires, last_used`
+
+	t, err := scanAPIToken(m.DB.QueryRow(stmt, hash[:]))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIToken{}, ErrNoRecord
+		}
+
+		return APIToken{}, err
+	}
+
+	return t, nil
+}
+
+// ForUser returns the user's tokens, including expired ones so that they can
+// see what stopped working.
+func (m *APITokenModel) ForUser(userID int) ([]APIToken, error) {
+	stmt := `SELECT id, user_id, name, scope, created, expires, last_used FROM api_tokens
+	WHERE user_id = ? ORDER BY created DESC, id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tokens []APIToken
+
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Delete revokes the token if it belongs to the user, returning ErrNoRecord
+// otherwise.
+func (m *APITokenModel) Delete(userID, id int) error {
+	stmt := `DELETE FROM api_tokens WHERE id = ? AND user_id = ?`
+
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func scanAPIToken(row interface{ Scan(dest ...any) error }) (APIToken, error) {
+	var (
+		t        APIToken
+		lastUsed sql.NullTime
+	)
+
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Created, &t.Expires, &lastUsed)
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	t.LastUsed = lastUsed.Time
+
+	return t, nil
+}
@@ -0,0 +1,169 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type AuditLogModelInterface interface {
+	Insert(event AuditEvent) error
+	List(filter AuditFilter, limit int) ([]AuditEvent, error)
+}
+
+// Actions recorded in the audit log.
+const (
+	AuditLogin             = "login"
+	AuditLoginFailed       = "login.failed"
+	AuditLogout            = "logout"
+	AuditSignup            = "signup"
+	AuditPasswordChange    = "password.change"
+	AuditPasswordReset     = "password.reset"
+	AuditEmailChange       = "email.change"
+	AuditAccountDelete     = "account.delete"
+	AuditSnippetCreate     = "snippet.create"
+	AuditSnippetDelete     = "snippet.delete"
+	AuditAdminUserDisable  = "admin.user.disable"
+	AuditAdminUserEnable   = "admin.user.enable"
+	AuditAdminUserUnlock   = "admin.user.unlock"
+	AuditAdminUserRole     = "admin.user.role"
+	AuditAdminInviteCreate = "admin.invite.create"
+	AuditAdminInviteDelete = "admin.invite.delete"
+)
+
+// AuditActions lists every action, in the order the audit log's filter offers
+// them.
+var AuditActions = []string{
+	AuditLogin, AuditLoginFailed, AuditLogout, AuditSignup, AuditPasswordChange, AuditPasswordReset,
+	AuditEmailChange, AuditAccountDelete, AuditSnippetCreate, AuditSnippetDelete, AuditAdminUserDisable,
+	AuditAdminUserEnable, AuditAdminUserUnlock, AuditAdminUserRole, AuditAdminInviteCreate, AuditAdminInviteDelete,
+}
+
+// AuditEvent records a security-relevant action. ActorID is the user who took
+// it, or 0 if that isn't known, as with failed logins. TargetID is the user it
+// was taken on, if it wasn't the actor themselves, such as the account a failed
+// login was for or the user an admin disabled.
+type AuditEvent struct {
+	ID        int
+	Created   time.Time
+	Action    string
+	ActorID   int
+	TargetID  int
+	IP        string
+	UserAgent string
+	Details   map[string]string
+	// ActorEmail and TargetEmail are filled in by List with the users' current
+	// email addresses.
+	ActorEmail  string
+	TargetEmail string
+}
+
+// AuditFilter narrows down the events List returns. Empty fields match every
+// event.
+type AuditFilter struct {
+	Action string
+	// User matches part of the actor's or target's email address.
+	User string
+	IP   string
+	// From and Until bound when the event happened. Until is exclusive.
+	From  time.Time
+	Until time.Time
+}
+
+// AuditLogModel appends to the audit log. Nothing can change or remove events
+// once they're recorded: the table refuses updates and deletes.
+type AuditLogModel struct {
+	DB *sql.DB
+}
+
+func (m *AuditLogModel) Insert(event AuditEvent) error {
+	if event.Details == nil {
+		event.Details = map[string]string{}
+	}
+
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO audit_log (created, action, actor_id, target_id, ip, user_agent, details)
+	VALUES (strftime('%Y-%m-%d %H:%M:%S', 'now'), ?, NULLIF(?, 0), NULLIF(?, 0), ?, ?, ?)`
+
+	_, err = m.DB.Exec(stmt, event.Action, event.ActorID, event.TargetID, event.IP, event.UserAgent, string(details))
+
+	return err
+}
+
+// List returns the most recent events which match the filter, newest first.
+func (m *AuditLogModel) List(filter AuditFilter, limit int) ([]AuditEvent, error) {
+	stmt := `SELECT a.id, a.created, a.action, a.actor_id, a.target_id, a.ip, a.user_agent, a.details,
+	actor.email, target.email
+	FROM audit_log a
+	LEFT JOIN users actor ON actor.id = a.actor_id
+	LEFT JOIN users target ON target.id = a.target_id
+	WHERE (:action = '' OR a.action = :action)
+	AND (:user = '' OR actor.email LIKE '%' || :user || '%' OR target.email LIKE '%' || :user || '%')
+	AND (:ip = '' OR a.ip = :ip)
+	AND (:from = '' OR a.created >= :from)
+	AND (:until = '' OR a.created < :until)
+	ORDER BY a.id DESC LIMIT :limit`
+
+	rows, err := m.DB.Query(stmt,
+		sql.Named("action", filter.Action),
+		sql.Named("user", filter.User),
+		sql.Named("ip", filter.IP),
+		sql.Named("from", auditTime(filter.From)),
+		sql.Named("until", auditTime(filter.Until)),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var events []AuditEvent
+
+	for rows.Next() {
+		var (
+			e                       AuditEvent
+			actorID, targetID       sql.NullInt64
+			details                 string
+			actorEmail, targetEmail sql.NullString
+		)
+
+		err = rows.Scan(&e.ID, &e.Created, &e.Action, &actorID, &targetID, &e.IP, &e.UserAgent, &details,
+			&actorEmail, &targetEmail)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(details), &e.Details)
+		if err != nil {
+			return nil, err
+		}
+
+		e.ActorID = int(actorID.Int64)
+		e.TargetID = int(targetID.Int64)
+		e.ActorEmail = actorEmail.String
+		e.TargetEmail = targetEmail.String
+
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// auditTime formats t the way times are stored, so that they compare in
+// order, or returns an empty string for the zero time.
+func auditTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.DateTime)
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// AvatarModelInterface stores the PNGs of users' uploaded avatars, one for each
+// size they're served at.
+type AvatarModelInterface interface {
+	Save(userID int, images map[int][]byte) error
+	Get(userID, size int) ([]byte, error)
+	Delete(userID int) error
+}
+
+// AvatarModel keeps avatars in the database.
+type AvatarModel struct {
+	DB *sql.DB
+}
+
+// Save replaces all of the user's avatar images.
+func (m *AvatarModel) Save(userID int, images map[int][]byte) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.Exec(`DELETE FROM avatars WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `INSERT INTO avatars (user_id, size, image) VALUES (?, ?, ?)`
+
+	for size, image := range images {
+		_, err = tx.Exec(stmt, userID, size, image)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *AvatarModel) Get(userID, size int) ([]byte, error) {
+	stmt := `SELECT image FROM avatars WHERE user_id = ? AND size = ?`
+
+	var image []byte
+
+	err := m.DB.QueryRow(stmt, userID, size).Scan(&image)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+
+		return nil, err
+	}
+
+	return image, nil
+}
+
+func (m *AvatarModel) Delete(userID int) error {
+	stmt := `DELETE FROM avatars WHERE user_id = ?`
+
+	_, err := m.DB.Exec(stmt, userID)
+
+	return err
+}
+
+// AvatarFileModel keeps avatars as files in a directory instead, named after
+// the user and size, such as "12-256.png".
+type AvatarFileModel struct {
+	Dir string
+}
+
+func (m *AvatarFileModel) path(userID, size int) string {
+	return filepath.Join(m.Dir, fmt.Sprintf("%d-%d.png", userID, size))
+}
+
+// Save writes each image to a temporary file before renaming it into place, so
+// that an avatar is never served half written.
+func (m *AvatarFileModel) Save(userID int, images map[int][]byte) error {
+	err := m.Delete(userID)
+	if err != nil {
+		return err
+	}
+
+	for size, image := range images {
+		tmp, err := os.CreateTemp(m.Dir, "*.tmp")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+
+		_, err = tmp.Write(image)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+
+		err = os.Rename(tmp.Name(), m.path(userID, size))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *AvatarFileModel) Get(userID, size int) ([]byte, error) {
+	image, err := os.ReadFile(m.path(userID, size))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNoRecord
+	}
+
+	return image, err
+}
+
+func (m *AvatarFileModel) Delete(userID int) error {
+	paths, err := filepath.Glob(filepath.Join(m.Dir, fmt.Sprintf("%d-*.png", userID)))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type CollectionModelInterface interface {
+	Insert(userID int, title, description string) (int, error)
+	Get(id int) (Collection, error)
+	ForUser(userID int) ([]Collection, error)
+	AddSnippet(collectionID, snippetID int) error
+	RemoveSnippet(collectionID, snippetID int) error
+	Reorder(collectionID int, snippetIDs []int) error
+}
+
+type Collection struct {
+	ID          int
+	UserID      int
+	Title       string
+	Description string
+	Created     time.Time
+	Snippets    []Snippet
+}
+
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+func (m *CollectionModel) Insert(userID int, title, description string) (int, error) {
+	stmt := `INSERT INTO collections (user_id, title, description, created)
+	VALUES (?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'))`
+
+	result, err := m.DB.Exec(stmt, userID, title, description)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get returns the collection with its member snippets in order. Members are
+// filtered with the same rules SnippetModel.Get applies and organization only
+// snippets are left out, so a collection never exposes a snippet which can't
+// be viewed on its own.
+func (m *CollectionModel) Get(id int) (Collection, error) {
+	stmt := `SELECT id, user_id, title, description, created FROM collections WHERE id = ?`
+
+	var c Collection
+
+	err := m.DB.QueryRow(stmt, id).Scan(&c.ID, &c.UserID, &c.Title, &c.Description, &c.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Collection{}, ErrNoRecord
+		}
+
+		return Collection{}, err
+	}
+
+	stmt = `SELECT s.id, s.title, s.content, s.created, s.expires FROM snippets s
+	INNER JOIN collection_snippets cs ON cs.snippet_id = s.id
+	WHERE cs.collection_id = ? AND s.expires > current_timestamp AND s.visibility = 'public'
+	ORDER BY cs.position, s.id`
+
+	rows, err := m.DB.Query(stmt, id)
+	if err != nil {
+		return Collection{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var s Snippet
+
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		if err != nil {
+			return Collection{}, err
+		}
+
+		c.Snippets = append(c.Snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return Collection{}, err
+	}
+
+	return c, nil
+}
+
+func (m *CollectionModel) ForUser(userID int) ([]Collection, error) {
+	stmt := `SELECT id, user_id, title, description, created FROM collections
+	WHERE user_id = ? ORDER BY id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var collections []Collection
+
+	for rows.Next() {
+		var c Collection
+
+		err = rows.Scan(&c.ID, &c.UserID, &c.Title, &c.Description, &c.Created)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// AddSnippet appends the snippet to the end of the collection. Adding a snippet
+// which is already a member is a no-op.
+func (m *CollectionModel) AddSnippet(collectionID, snippetID int) error {
+	stmt := `INSERT OR IGNORE INTO collection_snippets (collection_id, snippet_id, position)
+	SELECT ?, ?, COALESCE(MAX(position), 0) + 1 FROM collection_snippets WHERE collection_id = ?`
+
+	_, err := m.DB.Exec(stmt, collectionID, snippetID, collectionID)
+
+	return err
+}
+
+func (m *CollectionModel) RemoveSnippet(collectionID, snippetID int) error {
+	stmt := `DELETE FROM collection_snippets WHERE collection_id = ? AND snippet_id = ?`
+
+	_, err := m.DB.Exec(stmt, collectionID, snippetID)
+
+	return err
+}
+
+// Reorder sets the position of each snippet to its index in snippetIDs. IDs
+// which aren't members of the collection are ignored.
+func (m *CollectionModel) Reorder(collectionID int, snippetIDs []int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := `UPDATE collection_snippets SET position = ? WHERE collection_id = ? AND snippet_id = ?`
+
+	for i, snippetID := range snippetIDs {
+		_, err = tx.Exec(stmt, i+1, collectionID, snippetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
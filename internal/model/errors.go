@@ -8,4 +8,6 @@ var (
 	ErrNoRecord           = errors.New("model: no matching record found")
 	ErrInvalidCredentials = errors.New("model: invalid credentials")
 	ErrDuplicateEmail     = errors.New("model: duplicate email")
+	ErrDuplicateMember    = errors.New("model: duplicate member")
+	ErrDuplicateUsername  = errors.New("model: duplicate username")
 )
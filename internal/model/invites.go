@@ -0,0 +1,209 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+type InviteModelInterface interface {
+	Insert(email string, createdBy int, ttl time.Duration) (string, error)
+	InsertForOrganization(orgID int, role, email string, createdBy int, ttl time.Duration) (string, error)
+	Get(plaintext string) (Invite, error)
+	GetByEmail(email string) (Invite, error)
+	GetForOrganization(plaintext string) (Invite, error)
+	MarkUsed(id, userID int) error
+	List() ([]Invite, error)
+	Delete(id int) error
+}
+
+// Invite lets someone sign up when signup is invite-only, or, if it has an
+// OrganizationID, lets someone with an account join that organization with
+// OrganizationRole. It can only be used once, and only for the email address
+// it was issued for. Organization invites never let anyone sign up.
+type Invite struct {
+	ID               int
+	Email            string
+	CreatedBy        int
+	Created          time.Time
+	Expires          time.Time
+	UsedBy           int
+	Used             time.Time
+	OrganizationID   int
+	OrganizationRole string
+}
+
+// InviteModel stores invites. As with TokenModel, only a SHA-256 hash of each
+// invite code is kept.
+type InviteModel struct {
+	DB *sql.DB
+}
+
+// Insert issues an invite for email which is valid for ttl and returns its
+// code, which is the only time it's available.
+func (m *InviteModel) Insert(email string, createdBy int, ttl time.Duration) (string, error) {
+	return m.insert(0, "", email, createdBy, ttl)
+}
+
+// InsertForOrganization is Insert for an invite to join the organization with
+// role.
+func (m *InviteModel) InsertForOrganization(orgID int, role, email string, createdBy int, ttl time.Duration) (string, error) {
+	return m.insert(orgID, role, email, createdBy, ttl)
+}
+
+func (m *InviteModel) insert(orgID int, role, email string, createdBy int, ttl time.Duration) (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `INSERT INTO invites (hash, email, created_by, created, expires, organization_id, organization_role)
+	VALUES (?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'), datetime(strftime('%Y-%m-%d %H:%M:%S', 'now'), '+' || ? || ' seconds'),
+	NULLIF(?, 0), ?)`
+
+	_, err = m.DB.Exec(stmt, hash[:], email, createdBy, int(ttl.Seconds()), orgID, role)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+const inviteColumns = `id, email, created_by, created, expires, used_by, used, organization_id, organization_role`
+
+// Get returns the signup invite with the given code. ErrNoRecord is returned
+// if it doesn't exist, has expired or has already been used.
+func (m *InviteModel) Get(plaintext string) (Invite, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `SELECT ` + inviteColumns + ` FROM invites
+	WHERE hash = ? AND organization_id IS NULL AND used IS NULL AND expires > current_timestamp`
+
+	return m.getBy(stmt, hash[:])
+}
+
+// GetForOrganization is Get for invites to join an organization.
+func (m *InviteModel) GetForOrganization(plaintext string) (Invite, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `SELECT ` + inviteColumns + ` FROM invites
+	WHERE hash = ? AND organization_id IS NOT NULL AND used IS NULL AND expires > current_timestamp`
+
+	return m.getBy(stmt, hash[:])
+}
+
+// GetByEmail returns an outstanding signup invite for the email address, for when
+// someone signs up without a code, such as through single sign-on.
+// ErrNoRecord is returned if there isn't one.
+func (m *InviteModel) GetByEmail(email string) (Invite, error) {
+	stmt := `SELECT ` + inviteColumns + ` FROM invites
+	WHERE email = ? COLLATE NOCASE AND organization_id IS NULL AND used IS NULL AND expires > current_timestamp
+	ORDER BY expires DESC LIMIT 1`
+
+	return m.getBy(stmt, email)
+}
+
+func (m *InviteModel) getBy(stmt string, args ...any) (Invite, error) {
+	i, err := scanInvite(m.DB.QueryRow(stmt, args...))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Invite{}, ErrNoRecord
+		}
+
+		return Invite{}, err
+	}
+
+	return i, nil
+}
+
+// MarkUsed records that the user signed up with the invite. ErrNoRecord is
+// returned if it has already been used or has been deleted.
+func (m *InviteModel) MarkUsed(id, userID int) error {
+	stmt := `UPDATE invites SET used_by = ?, used = strftime('%Y-%m-%d %H:%M:%S', 'now')
+	WHERE id = ? AND used IS NULL`
+
+	return m.exec(stmt, userID, id)
+}
+
+// List returns the most recent signup invites, including used and expired
+// ones.
+func (m *InviteModel) List() ([]Invite, error) {
+	stmt := `SELECT ` + inviteColumns + ` FROM invites WHERE organization_id IS NULL
+	ORDER BY created DESC, id DESC LIMIT 100`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var invites []Invite
+
+	for rows.Next() {
+		i, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		invites = append(invites, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// Delete revokes an invite, returning ErrNoRecord if it doesn't exist.
+func (m *InviteModel) Delete(id int) error {
+	return m.exec(`DELETE FROM invites WHERE id = ?`, id)
+}
+
+// exec runs a statement which should affect exactly one invite.
+func (m *InviteModel) exec(stmt string, args ...any) error {
+	result, err := m.DB.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func scanInvite(row interface{ Scan(dest ...any) error }) (Invite, error) {
+	var (
+		i      Invite
+		usedBy sql.NullInt64
+		used   sql.NullTime
+		orgID  sql.NullInt64
+	)
+
+	err := row.Scan(&i.ID, &i.Email, &i.CreatedBy, &i.Created, &i.Expires, &usedBy, &used, &orgID, &i.OrganizationRole)
+	if err != nil {
+		return Invite{}, err
+	}
+
+	i.UsedBy = int(usedBy.Int64)
+	i.Used = used.Time
+	i.OrganizationID = int(orgID.Int64)
+
+	return i, nil
+}
@@ -0,0 +1,79 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// APITokenModel keeps tokens in memory so that tests can create a token and
+// then call the API with it. Plaintexts are kept instead of hashes.
+type APITokenModel struct {
+	mu     sync.Mutex
+	nextID int
+	tokens []apiToken
+}
+
+type apiToken struct {
+	model.APIToken
+	plaintext string
+}
+
+func (m *APITokenModel) Insert(userID int, name, scope string, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	plaintext := "sbx_MOCKTOKEN" + strconv.Itoa(m.nextID)
+
+	m.tokens = append(m.tokens, apiToken{
+		APIToken: model.APIToken{
+			ID:      m.nextID,
+			UserID:  userID,
+			Name:    name,
+			Scope:   scope,
+			Created: time.Now(),
+			Expires: time.Now().Add(ttl),
+		},
+		plaintext: plaintext,
+	})
+	return plaintext, nil
+}
+func (m *APITokenModel) Authenticate(plaintext string) (model.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, t := range m.tokens {
+		if t.plaintext == plaintext && time.Now().Before(t.Expires) {
+			m.tokens[i].LastUsed = time.Now()
+			return m.tokens[i].APIToken, nil
+		}
+	}
+	return model.APIToken{}, model.ErrNoRecord
+}
+func (m *APITokenModel) ForUser(userID int) ([]model.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []model.APIToken
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t.APIToken)
+		}
+	}
+	return tokens, nil
+}
+func (m *APITokenModel) Delete(userID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, t := range m.tokens {
+		if t.ID == id && t.UserID == userID {
+			m.tokens = slices.Delete(m.tokens, i, i+1)
+			return nil
+		}
+	}
+	return model.ErrNoRecord
+}
@@ -0,0 +1,68 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditLogModel keeps events in memory so that tests can check what was
+// recorded through the admin area.
+type AuditLogModel struct {
+	mu     sync.Mutex
+	events []model.AuditEvent
+}
+
+func (m *AuditLogModel) Insert(event model.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = len(m.events) + 1
+	event.Created = time.Now()
+	event.ActorEmail = mockEmail(event.ActorID)
+	event.TargetEmail = mockEmail(event.TargetID)
+
+	m.events = append(m.events, event)
+	return nil
+}
+func (m *AuditLogModel) List(filter model.AuditFilter, limit int) ([]model.AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []model.AuditEvent
+	for _, e := range slices.Backward(m.events) {
+		if filter.Action != "" && e.Action != filter.Action {
+			continue
+		}
+		if filter.User != "" && !(e.ActorEmail != "" && strings.Contains(e.ActorEmail, filter.User) ||
+			e.TargetEmail != "" && strings.Contains(e.TargetEmail, filter.User)) {
+			continue
+		}
+		if filter.IP != "" && e.IP != filter.IP {
+			continue
+		}
+		if !filter.From.IsZero() && e.Created.Before(filter.From) || !filter.Until.IsZero() && !e.Created.Before(filter.Until) {
+			continue
+		}
+		if len(events) == limit {
+			break
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// mockEmail returns the email address of one of the mock users, or an empty
+// string for anyone else.
+func mockEmail(id int) string {
+	switch id {
+	case mockUser.ID:
+		return mockUser.Email
+	case mockUnverifiedUser.ID:
+		return mockUnverifiedUser.Email
+	default:
+		return ""
+	}
+}
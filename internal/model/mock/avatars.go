@@ -0,0 +1,41 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"maps"
+	"sync"
+)
+
+// AvatarModel keeps uploaded avatars in memory.
+type AvatarModel struct {
+	mu      sync.Mutex
+	avatars map[int]map[int][]byte
+}
+
+func (m *AvatarModel) Save(userID int, images map[int][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.avatars == nil {
+		m.avatars = make(map[int]map[int][]byte)
+	}
+	m.avatars[userID] = maps.Clone(images)
+	return nil
+}
+func (m *AvatarModel) Get(userID, size int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	image, ok := m.avatars[userID][size]
+	if !ok {
+		return nil, model.ErrNoRecord
+	}
+	return image, nil
+}
+func (m *AvatarModel) Delete(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.avatars, userID)
+	return nil
+}
@@ -0,0 +1,44 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"time"
+)
+
+var mockCollection = model.Collection{
+	ID:          1,
+	UserID:      1,
+	Title:       "Haiku",
+	Description: "Short poems about ponds.",
+	Created:     time.Now(),
+	Snippets:    []model.Snippet{mockSnippet},
+}
+
+type CollectionModel struct{}
+
+func (m *CollectionModel) Insert(userID int, title, description string) (int, error) {
+	return 2, nil
+}
+func (m *CollectionModel) Get(id int) (model.Collection, error) {
+	switch id {
+	case 1:
+		return mockCollection, nil
+	default:
+		return model.Collection{}, model.ErrNoRecord
+	}
+}
+func (m *CollectionModel) ForUser(userID int) ([]model.Collection, error) {
+	if userID == mockCollection.UserID {
+		return []model.Collection{mockCollection}, nil
+	}
+	return nil, nil
+}
+func (m *CollectionModel) AddSnippet(collectionID, snippetID int) error {
+	return nil
+}
+func (m *CollectionModel) RemoveSnippet(collectionID, snippetID int) error {
+	return nil
+}
+func (m *CollectionModel) Reorder(collectionID int, snippetIDs []int) error {
+	return nil
+}
@@ -0,0 +1,109 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InviteModel keeps invites in memory so that tests can issue an invite and
+// then sign up with it. Codes are kept instead of hashes.
+type InviteModel struct {
+	mu      sync.Mutex
+	nextID  int
+	invites []invite
+}
+
+type invite struct {
+	model.Invite
+	plaintext string
+}
+
+func (m *InviteModel) Insert(email string, createdBy int, ttl time.Duration) (string, error) {
+	return m.insert(0, "", email, createdBy, ttl)
+}
+func (m *InviteModel) InsertForOrganization(orgID int, role, email string, createdBy int, ttl time.Duration) (string, error) {
+	return m.insert(orgID, role, email, createdBy, ttl)
+}
+func (m *InviteModel) insert(orgID int, role, email string, createdBy int, ttl time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	plaintext := "MOCKINVITE" + strconv.Itoa(m.nextID)
+
+	m.invites = append(m.invites, invite{
+		Invite: model.Invite{
+			ID:        m.nextID,
+			Email:     email,
+			CreatedBy: createdBy,
+			Created:   time.Now(),
+			Expires:   time.Now().Add(ttl),
+
+			OrganizationID:   orgID,
+			OrganizationRole: role,
+		},
+		plaintext: plaintext,
+	})
+	return plaintext, nil
+}
+func (m *InviteModel) Get(plaintext string) (model.Invite, error) {
+	return m.find(func(i invite) bool { return i.plaintext == plaintext && i.OrganizationID == 0 })
+}
+func (m *InviteModel) GetByEmail(email string) (model.Invite, error) {
+	return m.find(func(i invite) bool { return strings.EqualFold(i.Email, email) && i.OrganizationID == 0 })
+}
+func (m *InviteModel) GetForOrganization(plaintext string) (model.Invite, error) {
+	return m.find(func(i invite) bool { return i.plaintext == plaintext && i.OrganizationID != 0 })
+}
+func (m *InviteModel) find(match func(invite) bool) (model.Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, i := range m.invites {
+		if match(i) && i.Used.IsZero() && time.Now().Before(i.Expires) {
+			return i.Invite, nil
+		}
+	}
+	return model.Invite{}, model.ErrNoRecord
+}
+func (m *InviteModel) MarkUsed(id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.invites {
+		if m.invites[i].ID == id && m.invites[i].Used.IsZero() {
+			m.invites[i].UsedBy = userID
+			m.invites[i].Used = time.Now()
+			return nil
+		}
+	}
+	return model.ErrNoRecord
+}
+func (m *InviteModel) List() ([]model.Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var invites []model.Invite
+	for _, i := range m.invites {
+		if i.OrganizationID == 0 {
+			invites = append(invites, i.Invite)
+		}
+	}
+	return invites, nil
+}
+func (m *InviteModel) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, inv := range m.invites {
+		if inv.ID == id {
+			m.invites = slices.Delete(m.invites, i, i+1)
+			return nil
+		}
+	}
+	return model.ErrNoRecord
+}
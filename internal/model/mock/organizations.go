@@ -0,0 +1,127 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"slices"
+	"sync"
+	"time"
+)
+
+// OrganizationModel keeps organizations and their members in memory so that
+// tests can create a team and share snippets within it.
+type OrganizationModel struct {
+	mu      sync.Mutex
+	orgs    []model.Organization
+	members map[int][]model.OrganizationMember
+}
+
+func (m *OrganizationModel) Insert(name string, ownerID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := len(m.orgs) + 1
+	m.orgs = append(m.orgs, model.Organization{ID: id, Name: name, Created: time.Now()})
+
+	if m.members == nil {
+		m.members = make(map[int][]model.OrganizationMember)
+	}
+	m.members[id] = []model.OrganizationMember{newMember(ownerID, model.OrgRoleOwner)}
+	return id, nil
+}
+
+// newMember fills in the user's details from the mock users.
+func newMember(userID int, role string) model.OrganizationMember {
+	member := model.OrganizationMember{UserID: userID, Role: role, Joined: time.Now()}
+
+	for _, user := range []model.User{mockUser, mockUnverifiedUser} {
+		if user.ID == userID {
+			member.FullName = user.FullName
+			member.Email = user.Email
+		}
+	}
+	return member
+}
+
+func (m *OrganizationModel) Get(id int) (model.Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, o := range m.orgs {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return model.Organization{}, model.ErrNoRecord
+}
+func (m *OrganizationModel) ForUser(userID int) ([]model.Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var orgs []model.Organization
+	for _, o := range m.orgs {
+		for _, member := range m.members[o.ID] {
+			if member.UserID == userID {
+				o.Role = member.Role
+				orgs = append(orgs, o)
+			}
+		}
+	}
+	return orgs, nil
+}
+func (m *OrganizationModel) Role(id, userID int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, member := range m.members[id] {
+		if member.UserID == userID {
+			return member.Role, nil
+		}
+	}
+	return "", model.ErrNoRecord
+}
+func (m *OrganizationModel) Members(id int) ([]model.OrganizationMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.members[id]), nil
+}
+func (m *OrganizationModel) AddMember(id, userID int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, member := range m.members[id] {
+		if member.UserID == userID {
+			return model.ErrDuplicateMember
+		}
+	}
+
+	if m.members == nil {
+		m.members = make(map[int][]model.OrganizationMember)
+	}
+	m.members[id] = append(m.members[id], newMember(userID, role))
+	return nil
+}
+func (m *OrganizationModel) SetMemberRole(id, userID int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, member := range m.members[id] {
+		if member.UserID == userID {
+			m.members[id][i].Role = role
+			return nil
+		}
+	}
+	return model.ErrNoRecord
+}
+func (m *OrganizationModel) RemoveMember(id, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, member := range m.members[id] {
+		if member.UserID == userID {
+			m.members[id] = slices.Delete(m.members[id], i, i+1)
+			return nil
+		}
+	}
+	return model.ErrNoRecord
+}
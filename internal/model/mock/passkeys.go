@@ -0,0 +1,78 @@
+package mock
+
+import (
+	"bytes"
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PasskeyModel keeps state in memory so that tests can register a passkey and
+// then log in with it.
+type PasskeyModel struct {
+	mu       sync.Mutex
+	passkeys []model.Passkey
+}
+
+func (m *PasskeyModel) Insert(userID int, name string, id, publicKey []byte, signCount uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.passkeys = append(m.passkeys, model.Passkey{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		PublicKey: publicKey,
+		SignCount: signCount,
+		Created:   time.Now(),
+	})
+	return nil
+}
+func (m *PasskeyModel) Get(id []byte) (model.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.passkeys {
+		if bytes.Equal(p.ID, id) {
+			return p, nil
+		}
+	}
+	return model.Passkey{}, model.ErrNoRecord
+}
+func (m *PasskeyModel) ForUser(userID int) ([]model.Passkey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var passkeys []model.Passkey
+	for _, p := range m.passkeys {
+		if p.UserID == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+	return passkeys, nil
+}
+func (m *PasskeyModel) UpdateSignCount(id []byte, signCount uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.passkeys {
+		if bytes.Equal(m.passkeys[i].ID, id) {
+			m.passkeys[i].SignCount = signCount
+			m.passkeys[i].LastUsed = time.Now()
+		}
+	}
+	return nil
+}
+func (m *PasskeyModel) Delete(userID int, id []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, p := range m.passkeys {
+		if bytes.Equal(p.ID, id) && p.UserID == userID {
+			m.passkeys = slices.Delete(m.passkeys, i, i+1)
+			return nil
+		}
+	}
+	return model.ErrNoRecord
+}
@@ -0,0 +1,103 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"slices"
+	"sync"
+	"time"
+)
+
+// UserSessionModel keeps state in memory so that tests can list and revoke
+// the sessions they log in with. Unlike the real model it doesn't know when
+// sessions expire.
+type UserSessionModel struct {
+	mu       sync.Mutex
+	sessions []model.UserSession
+	nextID   int
+}
+
+func (m *UserSessionModel) Insert(token string, userID int, userAgent, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	m.sessions = append(m.sessions, model.UserSession{
+		ID:        m.nextID,
+		Token:     token,
+		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        ip,
+		Created:   time.Now(),
+		LastSeen:  time.Now(),
+	})
+	return nil
+}
+func (m *UserSessionModel) ForUser(userID int) ([]model.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []model.UserSession
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+func (m *UserSessionModel) Touch(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.sessions {
+		if m.sessions[i].Token == token {
+			m.sessions[i].LastSeen = time.Now()
+		}
+	}
+	return nil
+}
+func (m *UserSessionModel) Rekey(oldToken, newToken string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.sessions {
+		if m.sessions[i].Token == oldToken {
+			m.sessions[i].Token = newToken
+		}
+	}
+	return nil
+}
+func (m *UserSessionModel) Delete(userID, id int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, s := range m.sessions {
+		if s.ID == id && s.UserID == userID {
+			m.sessions = slices.Delete(m.sessions, i, i+1)
+			return s.Token, nil
+		}
+	}
+	return "", model.ErrNoRecord
+}
+func (m *UserSessionModel) DeleteByToken(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions = slices.DeleteFunc(m.sessions, func(s model.UserSession) bool {
+		return s.Token == token
+	})
+	return nil
+}
+func (m *UserSessionModel) DeleteOthers(userID int, keepToken string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var tokens []string
+	m.sessions = slices.DeleteFunc(m.sessions, func(s model.UserSession) bool {
+		if s.UserID == userID && s.Token != keepToken {
+			tokens = append(tokens, s.Token)
+			return true
+		}
+		return false
+	})
+	return tokens, nil
+}
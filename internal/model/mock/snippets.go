@@ -2,30 +2,172 @@ package mock
 
 import (
 	"github.com/thisisjab/snippetbox-go/internal/model"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
 var mockSnippet = model.Snippet{
-	ID:      1,
-	Title:   "An old silent pond",
-	Content: "An old silent pond...",
-	Created: time.Now(),
-	Expires: time.Now(),
+	ID:             1,
+	UserID:         1,
+	Author:         "Alice",
+	AuthorUsername: "alice",
+	Title:          "An old silent pond",
+	Content:        "An old silent pond...",
+	Created:        time.Now(),
+	Expires:        time.Now(),
+	Visibility:     model.VisibilityPublic,
 }
 
-type SnippetModel struct{}
+// SnippetModel starts out holding mockSnippet and keeps the snippets tests
+// create, edit or delete in memory.
+type SnippetModel struct {
+	mu       sync.Mutex
+	nextID   int
+	snippets []model.Snippet
+}
+
+// load fills in the starting state the first time it's needed. The caller
+// must hold the lock.
+func (m *SnippetModel) load() {
+	if m.nextID == 0 {
+		m.nextID = 2
+		m.snippets = []model.Snippet{mockSnippet}
+	}
+}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	return 2, nil
+func (m *SnippetModel) Insert(userID, orgID int, title string, content string, expires int, visibility string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	id := m.nextID
+	m.nextID++
+
+	m.snippets = append(m.snippets, model.Snippet{
+		ID:         id,
+		UserID:     userID,
+		Title:      title,
+		Content:    content,
+		Created:    time.Now(),
+		Expires:    time.Now().AddDate(0, 0, expires),
+		OrgID:      orgID,
+		Visibility: visibility,
+	})
+	return id, nil
 }
 func (m *SnippetModel) Get(id int) (model.Snippet, error) {
-	switch id {
-	case 1:
-		return mockSnippet, nil
-	default:
-		return model.Snippet{}, model.ErrNoRecord
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	for _, s := range m.snippets {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return model.Snippet{}, model.ErrNoRecord
+}
+func (m *SnippetModel) Update(id int, title, content, visibility string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	for i := range m.snippets {
+		if m.snippets[i].ID == id {
+			m.snippets[i].Title = title
+			m.snippets[i].Content = content
+			m.snippets[i].Visibility = visibility
+			return nil
+		}
+	}
+	return model.ErrNoRecord
+}
+func (m *SnippetModel) ForOrganization(orgID int, includePrivate bool, limit int) ([]model.Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	var snippets []model.Snippet
+	for _, s := range m.snippets {
+		if s.OrgID == orgID && (includePrivate || s.Public()) {
+			snippets = append(snippets, s)
+		}
+	}
+	return snippets, nil
+}
+func (m *SnippetModel) ForUser(userID, limit int) ([]model.Snippet, error) {
+	var snippets []model.Snippet
+	for _, s := range m.public() {
+		if s.UserID == userID {
+			snippets = append(snippets, s)
+		}
 	}
+	return snippets, nil
+}
+func (m *SnippetModel) AllForUser(userID int) ([]model.Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	var snippets []model.Snippet
+	for _, s := range m.snippets {
+		if s.UserID == userID {
+			snippets = append(snippets, s)
+		}
+	}
+	return snippets, nil
 }
 func (m *SnippetModel) Latest(limit int) ([]model.Snippet, error) {
-	return []model.Snippet{mockSnippet}, nil
+	return m.public(), nil
+}
+func (m *SnippetModel) Popular(days, limit int) ([]model.Snippet, error) {
+	return m.public(), nil
+}
+func (m *SnippetModel) public() []model.Snippet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	var snippets []model.Snippet
+	for _, s := range m.snippets {
+		if s.Public() {
+			snippets = append(snippets, s)
+		}
+	}
+	return snippets
+}
+func (m *SnippetModel) AddViews(views map[int]int) error {
+	return nil
+}
+func (m *SnippetModel) List(filter model.SnippetFilter, limit int) ([]model.Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	var snippets []model.Snippet
+	for _, s := range m.snippets {
+		if filter.Query != "" && !strings.Contains(s.Title, filter.Query) && !strings.Contains(s.Content, filter.Query) {
+			continue
+		}
+		if filter.Author != "" && !strings.Contains(s.Author, filter.Author) {
+			continue
+		}
+		snippets = append(snippets, s)
+	}
+	return snippets, nil
+}
+func (m *SnippetModel) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.load()
+
+	for i, s := range m.snippets {
+		if s.ID == id {
+			m.snippets = slices.Delete(m.snippets, i, i+1)
+			return nil
+		}
+	}
+	return model.ErrNoRecord
 }
@@ -0,0 +1,17 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+)
+
+type StatsModel struct{}
+
+func (m *StatsModel) Get() (model.Stats, error) {
+	return model.Stats{
+		Users:          2,
+		Snippets:       1,
+		ActiveSnippets: 1,
+		Sessions:       1,
+		DatabaseSize:   64 * 1024,
+	}, nil
+}
@@ -0,0 +1,48 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"sync"
+	"time"
+)
+
+const MockToken = "VALIDTOKEN"
+
+// TokenModel hands out MockToken for user 1. It remembers which scopes
+// DeleteAllForUser has revoked it for until New hands it out again.
+type TokenModel struct {
+	mu      sync.Mutex
+	revoked map[string]bool
+}
+
+func (m *TokenModel) New(userID int, ttl time.Duration, scope string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.revoked, scope)
+	return MockToken, nil
+}
+func (m *TokenModel) UserID(plaintext, scope string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if plaintext == MockToken && !m.revoked[scope] {
+		return 1, nil
+	}
+	return 0, model.ErrNoRecord
+}
+func (m *TokenModel) Consume(plaintext, scope string) (int, error) {
+	return m.UserID(plaintext, scope)
+}
+func (m *TokenModel) DeleteAllForUser(userID int, scope string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if userID == 1 {
+		if m.revoked == nil {
+			m.revoked = make(map[string]bool)
+		}
+		m.revoked[scope] = true
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package mock
+
+import (
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"sync"
+)
+
+// TwoFactorModel keeps state in memory so that tests can enroll a user and
+// then log in with a code.
+type TwoFactorModel struct {
+	mu            sync.Mutex
+	secrets       map[int]string
+	lastSteps     map[int]int64
+	recoveryCodes map[int][]string
+}
+
+func (m *TwoFactorModel) Secret(userID int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	secret, ok := m.secrets[userID]
+	if !ok {
+		return "", model.ErrNoRecord
+	}
+	return secret, nil
+}
+func (m *TwoFactorModel) Enable(userID int, secret string, recoveryCodes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.secrets == nil {
+		m.secrets = make(map[int]string)
+		m.lastSteps = make(map[int]int64)
+		m.recoveryCodes = make(map[int][]string)
+	}
+	m.secrets[userID] = secret
+	m.lastSteps[userID] = 0
+	m.recoveryCodes[userID] = recoveryCodes
+	return nil
+}
+func (m *TwoFactorModel) Disable(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.secrets, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+func (m *TwoFactorModel) UseStep(userID int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lastSteps[userID] >= step {
+		return false, nil
+	}
+	m.lastSteps[userID] = step
+	return true, nil
+}
+func (m *TwoFactorModel) UseRecoveryCode(userID int, code string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, c := range m.recoveryCodes[userID] {
+		if c == code {
+			m.recoveryCodes[userID] = append(m.recoveryCodes[userID][:i], m.recoveryCodes[userID][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -1,28 +1,306 @@
 package mock
 
-import "github.com/thisisjab/snippetbox-go/internal/model"
+import (
+	"fmt"
+	"github.com/thisisjab/snippetbox-go/internal/model"
+	"strings"
+	"sync"
+	"time"
+)
 
-type UserModel struct{}
+var mockUser = model.User{
+	ID:          1,
+	FullName:    "Alice Jones",
+	Email:       "alice@example.com",
+	Created:     time.Now(),
+	Verified:    true,
+	Role:        model.RoleAdmin,
+	Username:    "alice",
+	Bio:         "Writes haiku about ponds.",
+	HasPassword: true,
+}
 
-func (m *UserModel) Insert(name, email, password string) error {
-	switch email {
-	case "dupe@example.com":
-		return model.ErrDuplicateEmail
+var mockUnverifiedUser = model.User{
+	ID:          2,
+	FullName:    "Bob Smith",
+	Email:       "bob@example.com",
+	Created:     time.Now(),
+	Role:        model.RoleUser,
+	Username:    "bob",
+	HasPassword: true,
+}
+
+// UserModel remembers failed logins, lockouts, roles, verifications, profile
+// and email changes, avatars, disabled and deleted accounts so that tests can
+// exercise them.
+type UserModel struct {
+	mu          sync.Mutex
+	failures    map[int]int
+	lockedUntil map[int]time.Time
+	identities  map[string]int
+	roles       map[int]string
+	disabled    map[int]bool
+	verified    map[int]bool
+	profiles    map[int]model.User
+	avatars     map[int]time.Time
+	deleted     map[int]bool
+	emails      map[int]string
+}
+
+// withState fills in the state the mock has recorded for user.
+func (m *UserModel) withState(user model.User) model.User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user.FailedLogins = m.failures[user.ID]
+	user.LockedUntil = m.lockedUntil[user.ID]
+	user.AvatarUpdated = m.avatars[user.ID]
+	if role, ok := m.roles[user.ID]; ok {
+		user.Role = role
+	}
+	user.Disabled = m.disabled[user.ID]
+	user.Verified = user.Verified || m.verified[user.ID]
+	if profile, ok := m.profiles[user.ID]; ok {
+		user.FullName = profile.FullName
+		user.Username = profile.Username
+		user.Bio = profile.Bio
+	}
+	if email, ok := m.emails[user.ID]; ok {
+		user.Email = email
+		user.Verified = true
+	}
+	if m.deleted[user.ID] {
+		user = model.User{ID: user.ID, FullName: "Deleted user", Email: fmt.Sprintf("deleted-%d@deleted.invalid", user.ID),
+			Created: user.Created, Role: model.RoleUser, Disabled: true}
+	}
+	return user
+}
+
+func (m *UserModel) Insert(name, username, email, password string) (int, error) {
+	switch {
+	case email == "dupe@example.com":
+		return 0, model.ErrDuplicateEmail
+	case username == mockUser.Username || username == mockUnverifiedUser.Username:
+		return 0, model.ErrDuplicateUsername
 	default:
-		return nil
+		return 3, nil
 	}
 }
 func (m *UserModel) Authenticate(email, password string) (int, error) {
-	if email == "alice@example.com" && password == "pa$$word" {
-		return 1, nil
+	user, err := m.GetByEmail(email)
+	if err != nil || password != "pa$$word" {
+		return 0, model.ErrInvalidCredentials
 	}
-	return 0, model.ErrInvalidCredentials
+	return user.ID, nil
 }
 func (m *UserModel) Exists(id int) (bool, error) {
 	switch id {
-	case 1:
+	case 1, 2:
 		return true, nil
 	default:
 		return false, nil
 	}
 }
+func (m *UserModel) Get(id int) (model.User, error) {
+	switch id {
+	case 1:
+		return m.withState(mockUser), nil
+	case 2:
+		return m.withState(mockUnverifiedUser), nil
+	default:
+		return model.User{}, model.ErrNoRecord
+	}
+}
+func (m *UserModel) GetByEmail(email string) (model.User, error) {
+	for _, id := range []int{mockUser.ID, mockUnverifiedUser.ID} {
+		user, _ := m.Get(id)
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return model.User{}, model.ErrNoRecord
+}
+func (m *UserModel) GetByUsername(username string) (model.User, error) {
+	for _, id := range []int{mockUser.ID, mockUnverifiedUser.ID} {
+		user, _ := m.Get(id)
+		if user.Username != "" && user.Username == username {
+			return user, nil
+		}
+	}
+	return model.User{}, model.ErrNoRecord
+}
+func (m *UserModel) UpdateProfile(id int, fullName, username, bio string) error {
+	if username != "" {
+		other, err := m.GetByUsername(username)
+		if err == nil && other.ID != id {
+			return model.ErrDuplicateUsername
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.profiles == nil {
+		m.profiles = make(map[int]model.User)
+	}
+	m.profiles[id] = model.User{FullName: fullName, Username: username, Bio: bio}
+	return nil
+}
+func (m *UserModel) UpdateEmail(id int, oldEmail, newEmail string) error {
+	user, err := m.Get(id)
+	if err != nil || user.Email != oldEmail {
+		return model.ErrNoRecord
+	}
+
+	other, err := m.GetByEmail(newEmail)
+	if newEmail == "dupe@example.com" || err == nil && other.ID != id {
+		return model.ErrDuplicateEmail
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.emails == nil {
+		m.emails = make(map[int]string)
+	}
+	m.emails[id] = newEmail
+	return nil
+}
+func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+	if id == 1 && currentPassword == "pa$$word" {
+		return nil
+	}
+	return model.ErrInvalidCredentials
+}
+func (m *UserModel) PasswordSet(id int, newPassword string) error {
+	return nil
+}
+func (m *UserModel) SetVerified(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.verified == nil {
+		m.verified = make(map[int]bool)
+	}
+	m.verified[id] = true
+	return nil
+}
+func (m *UserModel) SetVerificationSent(id int) error {
+	return nil
+}
+func (m *UserModel) RecordLoginFailure(id int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failures == nil {
+		m.failures = make(map[int]int)
+	}
+	m.failures[id]++
+	return m.failures[id], nil
+}
+func (m *UserModel) Lock(id int, d time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lockedUntil == nil {
+		m.lockedUntil = make(map[int]time.Time)
+	}
+	m.lockedUntil[id] = time.Now().Add(d)
+	return nil
+}
+func (m *UserModel) ResetLoginFailures(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failures, id)
+	delete(m.lockedUntil, id)
+	return nil
+}
+func (m *UserModel) GetByIdentity(issuer, subject string) (model.User, error) {
+	m.mu.Lock()
+	id, ok := m.identities[issuer+" "+subject]
+	m.mu.Unlock()
+
+	if !ok {
+		return model.User{}, model.ErrNoRecord
+	}
+	return m.Get(id)
+}
+func (m *UserModel) LinkIdentity(id int, issuer, subject string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.identities == nil {
+		m.identities = make(map[string]int)
+	}
+	m.identities[issuer+" "+subject] = id
+	return nil
+}
+func (m *UserModel) InsertFromIdentity(fullName, email string, verified bool, issuer, subject string) (int, error) {
+	switch email {
+	case "dupe@example.com", mockUser.Email, mockUnverifiedUser.Email:
+		return 0, model.ErrDuplicateEmail
+	}
+
+	return 3, m.LinkIdentity(3, issuer, subject)
+}
+func (m *UserModel) List(filter model.UserFilter, limit int) ([]model.User, error) {
+	var users []model.User
+	for _, user := range []model.User{m.withState(mockUnverifiedUser), m.withState(mockUser)} {
+		if filter.Query != "" && !strings.Contains(user.FullName, filter.Query) && !strings.Contains(user.Email, filter.Query) {
+			continue
+		}
+		if filter.Role != "" && user.Role != filter.Role {
+			continue
+		}
+		if filter.Disabled && !user.Disabled {
+			continue
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+func (m *UserModel) SetRole(id int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.roles == nil {
+		m.roles = make(map[int]string)
+	}
+	m.roles[id] = role
+	return nil
+}
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.disabled == nil {
+		m.disabled = make(map[int]bool)
+	}
+	m.disabled[id] = disabled
+	return nil
+}
+func (m *UserModel) SetAvatar(id int, uploaded bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.avatars == nil {
+		m.avatars = make(map[int]time.Time)
+	}
+	if uploaded {
+		m.avatars[id] = time.Now()
+	} else {
+		delete(m.avatars, id)
+	}
+	return nil
+}
+func (m *UserModel) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.deleted == nil {
+		m.deleted = make(map[int]bool)
+	}
+	m.deleted[id] = true
+	return nil
+}
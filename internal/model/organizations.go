@@ -0,0 +1,240 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/mattn/go-sqlite3"
+	"time"
+)
+
+// Roles within an organization. Owners manage its members; every member can
+// edit its snippets.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleMember = "member"
+)
+
+type OrganizationModelInterface interface {
+	Insert(name string, ownerID int) (int, error)
+	Get(id int) (Organization, error)
+	ForUser(userID int) ([]Organization, error)
+	Role(id, userID int) (string, error)
+	Members(id int) ([]OrganizationMember, error)
+	AddMember(id, userID int, role string) error
+	SetMemberRole(id, userID int, role string) error
+	RemoveMember(id, userID int) error
+}
+
+// Organization is a team which shares ownership of snippets.
+type Organization struct {
+	ID      int
+	Name    string
+	Created time.Time
+	// Role is the user's role in the organization when it's returned by
+	// ForUser.
+	Role string
+}
+
+type OrganizationMember struct {
+	UserID   int
+	FullName string
+	Email    string
+	Role     string
+	Joined   time.Time
+}
+
+type OrganizationModel struct {
+	DB *sql.DB
+}
+
+// Insert creates an organization with the user as its first owner.
+func (m *OrganizationModel) Insert(name string, ownerID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := `INSERT INTO organizations (name, created) VALUES (?, strftime('%Y-%m-%d %H:%M:%S', 'now'))`
+
+	result, err := tx.Exec(stmt, name)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `INSERT INTO organization_members (organization_id, user_id, role, created)
+	VALUES (?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'))`
+
+	_, err = tx.Exec(stmt, id, ownerID, OrgRoleOwner)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), tx.Commit()
+}
+
+func (m *OrganizationModel) Get(id int) (Organization, error) {
+	stmt := `SELECT id, name, created FROM organizations WHERE id = ?`
+
+	var o Organization
+
+	err := m.DB.QueryRow(stmt, id).Scan(&o.ID, &o.Name, &o.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Organization{}, ErrNoRecord
+		}
+
+		return Organization{}, err
+	}
+
+	return o, nil
+}
+
+// ForUser returns the organizations the user belongs to, with their role in
+// each.
+func (m *OrganizationModel) ForUser(userID int) ([]Organization, error) {
+	stmt := `SELECT o.id, o.name, o.created, om.role FROM organizations o
+	INNER JOIN organization_members om ON om.organization_id = o.id
+	WHERE om.user_id = ? ORDER BY o.name, o.id`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var orgs []Organization
+
+	for rows.Next() {
+		var o Organization
+
+		err = rows.Scan(&o.ID, &o.Name, &o.Created, &o.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		orgs = append(orgs, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// Role returns the user's role in the organization, or ErrNoRecord if they
+// aren't a member.
+func (m *OrganizationModel) Role(id, userID int) (string, error) {
+	stmt := `SELECT role FROM organization_members WHERE organization_id = ? AND user_id = ?`
+
+	var role string
+
+	err := m.DB.QueryRow(stmt, id, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+
+		return "", err
+	}
+
+	return role, nil
+}
+
+// Members returns the organization's members, owners first.
+func (m *OrganizationModel) Members(id int) ([]OrganizationMember, error) {
+	stmt := `SELECT u.id, u.full_name, u.email, om.role, om.created FROM organization_members om
+	INNER JOIN users u ON u.id = om.user_id
+	WHERE om.organization_id = ? ORDER BY om.role = 'owner' DESC, u.full_name, u.id`
+
+	rows, err := m.DB.Query(stmt, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var members []OrganizationMember
+
+	for rows.Next() {
+		var om OrganizationMember
+
+		err = rows.Scan(&om.UserID, &om.FullName, &om.Email, &om.Role, &om.Joined)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, om)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// AddMember adds the user to the organization. ErrDuplicateMember is returned
+// if they already belong to it.
+func (m *OrganizationModel) AddMember(id, userID int, role string) error {
+	stmt := `INSERT INTO organization_members (organization_id, user_id, role, created)
+	VALUES (?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'))`
+
+	_, err := m.DB.Exec(stmt, id, userID, role)
+	if err != nil {
+		var sqlite3Error sqlite3.Error
+
+		if errors.As(err, &sqlite3Error) && sqlite3Error.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return ErrDuplicateMember
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// SetMemberRole changes a member's role, returning ErrNoRecord if the user
+// isn't a member.
+func (m *OrganizationModel) SetMemberRole(id, userID int, role string) error {
+	stmt := `UPDATE organization_members SET role = ? WHERE organization_id = ? AND user_id = ?`
+
+	return m.exec(stmt, role, id, userID)
+}
+
+// RemoveMember takes the user out of the organization, returning ErrNoRecord
+// if they aren't a member. The snippets they created for it stay with it.
+func (m *OrganizationModel) RemoveMember(id, userID int) error {
+	stmt := `DELETE FROM organization_members WHERE organization_id = ? AND user_id = ?`
+
+	return m.exec(stmt, id, userID)
+}
+
+// exec runs a statement which should affect exactly one membership.
+func (m *OrganizationModel) exec(stmt string, args ...any) error {
+	result, err := m.DB.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
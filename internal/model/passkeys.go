@@ -0,0 +1,132 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type PasskeyModelInterface interface {
+	Insert(userID int, name string, id, publicKey []byte, signCount uint32) error
+	Get(id []byte) (Passkey, error)
+	ForUser(userID int) ([]Passkey, error)
+	UpdateSignCount(id []byte, signCount uint32) error
+	Delete(userID int, id []byte) error
+}
+
+// Passkey is a WebAuthn credential a user can log in with. PublicKey is COSE
+// encoded, as the authenticator sent it.
+type Passkey struct {
+	ID        []byte
+	UserID    int
+	Name      string
+	PublicKey []byte
+	SignCount uint32
+	Created   time.Time
+	LastUsed  time.Time
+}
+
+type PasskeyModel struct {
+	DB *sql.DB
+}
+
+func (m *PasskeyModel) Insert(userID int, name string, id, publicKey []byte, signCount uint32) error {
+	stmt := `INSERT INTO passkeys (id, user_id, name, public_key, sign_count, created)
+	VALUES (?, ?, ?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'))`
+
+	_, err := m.DB.Exec(stmt, id, userID, name, publicKey, signCount)
+
+	return err
+}
+
+func (m *PasskeyModel) Get(id []byte) (Passkey, error) {
+	stmt := `SELECT id, user_id, name, public_key, sign_count, created, last_used FROM passkeys WHERE id = ?`
+
+	p, err := scanPasskey(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Passkey{}, ErrNoRecord
+		}
+
+		return Passkey{}, err
+	}
+
+	return p, nil
+}
+
+func (m *PasskeyModel) ForUser(userID int) ([]Passkey, error) {
+	stmt := `SELECT id, user_id, name, public_key, sign_count, created, last_used FROM passkeys
+	WHERE user_id = ? ORDER BY created`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var passkeys []Passkey
+
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		passkeys = append(passkeys, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+// UpdateSignCount stores the signature counter reported by a successful login
+// and records when the passkey was last used.
+func (m *PasskeyModel) UpdateSignCount(id []byte, signCount uint32) error {
+	stmt := `UPDATE passkeys SET sign_count = ?, last_used = strftime('%Y-%m-%d %H:%M:%S', 'now') WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, signCount, id)
+
+	return err
+}
+
+// Delete removes the passkey if it belongs to the user, returning ErrNoRecord
+// otherwise.
+func (m *PasskeyModel) Delete(userID int, id []byte) error {
+	stmt := `DELETE FROM passkeys WHERE id = ? AND user_id = ?`
+
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func scanPasskey(row interface{ Scan(dest ...any) error }) (Passkey, error) {
+	var (
+		p        Passkey
+		lastUsed sql.NullTime
+	)
+
+	err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.PublicKey, &p.SignCount, &p.Created, &lastUsed)
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	p.LastUsed = lastUsed.Time
+
+	return p, nil
+}
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type UserSessionModelInterface interface {
+	Insert(token string, userID int, userAgent, ip string) error
+	ForUser(userID int) ([]UserSession, error)
+	Touch(token string) error
+	Rekey(oldToken, newToken string) error
+	Delete(userID, id int) (string, error)
+	DeleteByToken(token string) error
+	DeleteOthers(userID int, keepToken string) ([]string, error)
+}
+
+// UserSession describes a logged in session so that users can recognise their
+// devices. Token is the session manager's token and must never be shown.
+type UserSession struct {
+	ID        int
+	Token     string
+	UserID    int
+	UserAgent string
+	IP        string
+	Created   time.Time
+	LastSeen  time.Time
+}
+
+// UserSessionModel keeps metadata about the sessions users are logged in
+// with, alongside the session data the session store keeps.
+type UserSessionModel struct {
+	DB *sql.DB
+}
+
+// Insert records a new session. It also clears out metadata of sessions which
+// have long since expired, as the session store doesn't know about it.
+func (m *UserSessionModel) Insert(token string, userID int, userAgent, ip string) error {
+	stmt := `DELETE FROM user_sessions WHERE last_seen < datetime('now', '-1 day')
+	AND token NOT IN (SELECT token FROM sessions WHERE julianday('now') < expiry)`
+
+	_, err := m.DB.Exec(stmt)
+	if err != nil {
+		return err
+	}
+
+	stmt = `INSERT INTO user_sessions (token, user_id, user_agent, ip, created, last_seen)
+	VALUES (?, ?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'), strftime('%Y-%m-%d %H:%M:%S', 'now'))`
+
+	_, err = m.DB.Exec(stmt, token, userID, userAgent, ip)
+
+	return err
+}
+
+// ForUser returns the user's sessions, most recently seen first. Sessions
+// which have expired in the session store are left out.
+func (m *UserSessionModel) ForUser(userID int) ([]UserSession, error) {
+	stmt := `SELECT us.id, us.token, us.user_id, us.user_agent, us.ip, us.created, us.last_seen
+	FROM user_sessions us JOIN sessions s ON s.token = us.token
+	WHERE us.user_id = ? AND julianday('now') < s.expiry
+	ORDER BY us.last_seen DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var sessions []UserSession
+
+	for rows.Next() {
+		var s UserSession
+
+		err = rows.Scan(&s.ID, &s.Token, &s.UserID, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Touch records that the session was just used.
+func (m *UserSessionModel) Touch(token string) error {
+	stmt := `UPDATE user_sessions SET last_seen = strftime('%Y-%m-%d %H:%M:%S', 'now') WHERE token = ?`
+
+	_, err := m.DB.Exec(stmt, token)
+
+	return err
+}
+
+// Rekey moves the session's metadata to a new token after the session manager
+// has renewed it.
+func (m *UserSessionModel) Rekey(oldToken, newToken string) error {
+	stmt := `UPDATE user_sessions SET token = ? WHERE token = ?`
+
+	_, err := m.DB.Exec(stmt, newToken, oldToken)
+
+	return err
+}
+
+// Delete removes the session if it belongs to the user and returns its token,
+// so that the caller can also remove it from the session store. ErrNoRecord is
+// returned if there's no such session.
+func (m *UserSessionModel) Delete(userID, id int) (string, error) {
+	stmt := `DELETE FROM user_sessions WHERE id = ? AND user_id = ? RETURNING token`
+
+	var token string
+
+	err := m.DB.QueryRow(stmt, id, userID).Scan(&token)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (m *UserSessionModel) DeleteByToken(token string) error {
+	stmt := `DELETE FROM user_sessions WHERE token = ?`
+
+	_, err := m.DB.Exec(stmt, token)
+
+	return err
+}
+
+// DeleteOthers removes all of the user's sessions except the one with
+// keepToken and returns the tokens of those it removed.
+func (m *UserSessionModel) DeleteOthers(userID int, keepToken string) ([]string, error) {
+	stmt := `DELETE FROM user_sessions WHERE user_id = ? AND token != ? RETURNING token`
+
+	rows, err := m.DB.Query(stmt, userID, keepToken)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var tokens []string
+
+	for rows.Next() {
+		var token string
+
+		err = rows.Scan(&token)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
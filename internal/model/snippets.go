@@ -7,28 +7,72 @@ import (
 )
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
+	Insert(userID, orgID int, title string, content string, expires int, visibility string) (int, error)
 	Get(id int) (Snippet, error)
+	Update(id int, title, content, visibility string) error
+	ForOrganization(orgID int, includePrivate bool, limit int) ([]Snippet, error)
+	ForUser(userID, limit int) ([]Snippet, error)
+	AllForUser(userID int) ([]Snippet, error)
 	Latest(limit int) ([]Snippet, error)
+	Popular(days, limit int) ([]Snippet, error)
+	AddViews(views map[int]int) error
+	List(filter SnippetFilter, limit int) ([]Snippet, error)
+	Delete(id int) error
 }
 
+// SnippetFilter narrows down the snippets List returns. Empty fields match
+// every snippet.
+type SnippetFilter struct {
+	// Query matches part of the title or content.
+	Query string
+	// Author matches part of the author's name or email address.
+	Author string
+	// Expired includes expired snippets as well.
+	Expired bool
+}
+
+// Snippet visibilities. Organization snippets can only be seen by the members
+// of the organization they belong to.
+const (
+	VisibilityPublic       = "public"
+	VisibilityOrganization = "organization"
+)
+
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID     int
+	UserID int
+	Author string
+	// AuthorUsername names the author's profile. It's empty if they don't
+	// have one.
+	AuthorUsername string
+	Title          string
+	Content        string
+	Created        time.Time
+	Expires        time.Time
+	Views          int
+	// OrgID is the organization the snippet was created under, or zero if it
+	// belongs to its author alone.
+	OrgID      int
+	OrgName    string
+	Visibility string
+}
+
+// Public reports whether anyone may view the snippet.
+func (s Snippet) Public() bool {
+	return s.Visibility != VisibilityOrganization
 }
 
 type SnippetModel struct {
 	DB *sql.DB
 }
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-	VALUES (?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'), datetime(strftime('%Y-%m-%d %H:%M:%S', 'now'), '+' || ? || ' days'))`
+// Insert creates a snippet by the user. If orgID isn't zero the snippet is
+// created under that organization.
+func (m *SnippetModel) Insert(userID, orgID int, title string, content string, expires int, visibility string) (int, error) {
+	stmt := `INSERT INTO snippets (user_id, organization_id, title, content, created, expires, visibility)
+	VALUES (?, NULLIF(?, 0), ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'), datetime(strftime('%Y-%m-%d %H:%M:%S', 'now'), '+' || ? || ' days'), ?)`
 
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	result, err := m.DB.Exec(stmt, userID, orgID, title, content, expires, visibility)
 	if err != nil {
 		return 0, err
 	}
@@ -42,12 +86,16 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 }
 
 func (m *SnippetModel) Get(id int) (Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > current_timestamp AND id = ?`
+	stmt := `SELECT s.id, COALESCE(s.user_id, 0), COALESCE(u.full_name, ''), COALESCE(u.username, ''), s.title, s.content,
+	s.created, s.expires, (SELECT COALESCE(SUM(views), 0) FROM snippet_views WHERE snippet_id = s.id),
+	COALESCE(s.organization_id, 0), COALESCE(o.name, ''), s.visibility
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id LEFT JOIN organizations o ON o.id = s.organization_id
+	WHERE s.expires > current_timestamp AND s.id = ?`
 
 	var s Snippet
 
-	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.UserID, &s.Author, &s.AuthorUsername, &s.Title, &s.Content, &s.Created,
+		&s.Expires, &s.Views, &s.OrgID, &s.OrgName, &s.Visibility)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -62,7 +110,7 @@ func (m *SnippetModel) Get(id int) (Snippet, error) {
 
 func (m *SnippetModel) Latest(limit int) ([]Snippet, error) {
 	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > strftime('%Y-%m-%d %H:%M:%S', 'now') ORDER BY id DESC LIMIT ?`
+	WHERE expires > strftime('%Y-%m-%d %H:%M:%S', 'now') AND visibility = 'public' ORDER BY id DESC LIMIT ?`
 
 	rows, err := m.DB.Query(stmt, limit)
 	if err != nil {
@@ -91,3 +139,268 @@ func (m *SnippetModel) Latest(limit int) ([]Snippet, error) {
 
 	return snippets, nil
 }
+
+// Popular returns the unexpired snippets with the most views over the last
+// given number of days, most viewed first. Views holds the count for that
+// period rather than the all-time total.
+func (m *SnippetModel) Popular(days, limit int) ([]Snippet, error) {
+	stmt := `SELECT s.id, COALESCE(s.user_id, 0), s.title, s.content, s.created, s.expires, SUM(v.views) AS total
+	FROM snippets s INNER JOIN snippet_views v ON v.snippet_id = s.id
+	WHERE s.expires > current_timestamp AND s.visibility = 'public' AND v.day > date('now', '-' || ? || ' days')
+	GROUP BY s.id ORDER BY total DESC, s.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, days, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		var s Snippet
+
+		err = rows.Scan(&s.ID, &s.UserID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Views)
+		if err != nil {
+			return nil, err
+		}
+
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Update changes the snippet's title, content and visibility, returning
+// ErrNoRecord if it doesn't exist or has expired.
+func (m *SnippetModel) Update(id int, title, content, visibility string) error {
+	stmt := `UPDATE snippets SET title = ?, content = ?, visibility = ? WHERE id = ? AND expires > current_timestamp`
+
+	result, err := m.DB.Exec(stmt, title, content, visibility, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// ForOrganization returns up to limit of the organization's unexpired
+// snippets, newest first. Snippets only its members may see are left out
+// unless includePrivate is true.
+func (m *SnippetModel) ForOrganization(orgID int, includePrivate bool, limit int) ([]Snippet, error) {
+	stmt := `SELECT s.id, COALESCE(s.user_id, 0), COALESCE(u.full_name, ''), s.title, s.content, s.created, s.expires, s.visibility
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE s.organization_id = ? AND s.expires > current_timestamp AND (? OR s.visibility = 'public')
+	ORDER BY s.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, orgID, includePrivate, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		s := Snippet{OrgID: orgID}
+
+		err = rows.Scan(&s.ID, &s.UserID, &s.Author, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Visibility)
+		if err != nil {
+			return nil, err
+		}
+
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// ForUser returns up to limit of the public, unexpired snippets the user
+// created, newest first.
+func (m *SnippetModel) ForUser(userID, limit int) ([]Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires FROM snippets
+	WHERE user_id = ? AND visibility = 'public' AND expires > current_timestamp
+	ORDER BY id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		s := Snippet{UserID: userID, Visibility: VisibilityPublic}
+
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// AllForUser returns every snippet the user created, oldest first, including
+// expired and organization only ones. It's meant for exporting their data.
+func (m *SnippetModel) AllForUser(userID int) ([]Snippet, error) {
+	stmt := `SELECT s.id, s.title, s.content, s.created, s.expires,
+	(SELECT COALESCE(SUM(views), 0) FROM snippet_views WHERE snippet_id = s.id),
+	COALESCE(s.organization_id, 0), COALESCE(o.name, ''), s.visibility
+	FROM snippets s LEFT JOIN organizations o ON o.id = s.organization_id
+	WHERE s.user_id = ? ORDER BY s.id`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		s := Snippet{UserID: userID}
+
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.Views, &s.OrgID, &s.OrgName, &s.Visibility)
+		if err != nil {
+			return nil, err
+		}
+
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// AddViews adds the given number of views, keyed by snippet ID, to today's
+// counters in a single transaction.
+func (m *SnippetModel) AddViews(views map[int]int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := `INSERT INTO snippet_views (snippet_id, day, views) VALUES (?, date('now'), ?)
+	ON CONFLICT (snippet_id, day) DO UPDATE SET views = views + excluded.views`
+
+	for id, n := range views {
+		_, err = tx.Exec(stmt, id, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// List returns up to limit snippets matching filter, newest first.
+func (m *SnippetModel) List(filter SnippetFilter, limit int) ([]Snippet, error) {
+	stmt := `SELECT s.id, COALESCE(s.user_id, 0), COALESCE(u.full_name, ''), s.title, s.content, s.created, s.expires
+	FROM snippets s LEFT JOIN users u ON u.id = s.user_id
+	WHERE (? = '' OR s.title LIKE '%' || ? || '%' OR s.content LIKE '%' || ? || '%')
+	AND (? = '' OR u.full_name LIKE '%' || ? || '%' OR u.email LIKE '%' || ? || '%')
+	AND (? OR s.expires > current_timestamp)
+	ORDER BY s.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, filter.Query, filter.Query, filter.Query, filter.Author, filter.Author, filter.Author,
+		filter.Expired, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var snippets []Snippet
+
+	for rows.Next() {
+		var s Snippet
+
+		err = rows.Scan(&s.ID, &s.UserID, &s.Author, &s.Title, &s.Content, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Delete removes the snippet along with its views and its place in any
+// collections. ErrNoRecord is returned if it doesn't exist.
+func (m *SnippetModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, stmt := range []string{
+		`DELETE FROM snippet_views WHERE snippet_id = ?`,
+		`DELETE FROM collection_snippets WHERE snippet_id = ?`,
+	} {
+		_, err = tx.Exec(stmt, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`DELETE FROM snippets WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return tx.Commit()
+}
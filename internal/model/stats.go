@@ -0,0 +1,46 @@
+package model
+
+import (
+	"database/sql"
+)
+
+type StatsModelInterface interface {
+	Get() (Stats, error)
+}
+
+// Stats give administrators an overview of the site.
+type Stats struct {
+	Users         int
+	DisabledUsers int
+	Snippets      int
+	// ActiveSnippets haven't expired yet.
+	ActiveSnippets int
+	// Sessions counts sessions which haven't expired, whether or not anyone
+	// is logged in to them.
+	Sessions int
+	// DatabaseSize is in bytes.
+	DatabaseSize int64
+}
+
+type StatsModel struct {
+	DB *sql.DB
+}
+
+func (m *StatsModel) Get() (Stats, error) {
+	var s Stats
+
+	stmt := `SELECT
+	(SELECT COUNT(*) FROM users),
+	(SELECT COUNT(*) FROM users WHERE disabled),
+	(SELECT COUNT(*) FROM snippets),
+	(SELECT COUNT(*) FROM snippets WHERE expires > current_timestamp),
+	(SELECT COUNT(*) FROM sessions WHERE julianday('now') < expiry),
+	(SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size())`
+
+	err := m.DB.QueryRow(stmt).Scan(&s.Users, &s.DisabledUsers, &s.Snippets, &s.ActiveSnippets, &s.Sessions, &s.DatabaseSize)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return s, nil
+}
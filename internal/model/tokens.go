@@ -0,0 +1,104 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+const (
+	ScopePasswordReset = "password-reset"
+)
+
+type TokenModelInterface interface {
+	New(userID int, ttl time.Duration, scope string) (string, error)
+	UserID(plaintext, scope string) (int, error)
+	Consume(plaintext, scope string) (int, error)
+	DeleteAllForUser(userID int, scope string) error
+}
+
+// TokenModel stores single-use secrets which are handed out in links, such as
+// password reset tokens. Only a SHA-256 hash of each token is kept, so a copy
+// of the database can't be used to take over accounts.
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// New generates a token for the user which is valid for ttl and returns its
+// plaintext, which is the only time it's available.
+func (m *TokenModel) New(userID int, ttl time.Duration, scope string) (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES (?, ?, datetime(strftime('%Y-%m-%d %H:%M:%S', 'now'), '+' || ? || ' seconds'), ?)`
+
+	_, err = m.DB.Exec(stmt, hash[:], userID, int(ttl.Seconds()), scope)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// UserID returns the ID of the user the token belongs to without using it up.
+// ErrNoRecord is returned if the token doesn't exist or has expired.
+func (m *TokenModel) UserID(plaintext, scope string) (int, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `SELECT user_id FROM tokens WHERE hash = ? AND scope = ? AND expiry > current_timestamp`
+
+	var userID int
+
+	err := m.DB.QueryRow(stmt, hash[:], scope).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Consume deletes the token and returns the ID of the user it belonged to.
+// Deleting and reading happen in one statement, so a token can't be used twice
+// even by concurrent requests. ErrNoRecord is returned if the token doesn't
+// exist or has expired.
+func (m *TokenModel) Consume(plaintext, scope string) (int, error) {
+	hash := sha256.Sum256([]byte(plaintext))
+
+	stmt := `DELETE FROM tokens WHERE hash = ? AND scope = ? AND expiry > current_timestamp RETURNING user_id`
+
+	var userID int
+
+	err := m.DB.QueryRow(stmt, hash[:], scope).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+func (m *TokenModel) DeleteAllForUser(userID int, scope string) error {
+	stmt := `DELETE FROM tokens WHERE user_id = ? AND scope = ?`
+
+	_, err := m.DB.Exec(stmt, userID, scope)
+
+	return err
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+type TwoFactorModelInterface interface {
+	Secret(userID int) (string, error)
+	Enable(userID int, secret string, recoveryCodes []string) error
+	Disable(userID int) error
+	UseStep(userID int, step int64) (bool, error)
+	UseRecoveryCode(userID int, code string) (bool, error)
+}
+
+// TwoFactorModel stores users' TOTP secrets and their one-time recovery codes.
+// Recovery codes are stored as hashes, like passwords.
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// Secret returns the user's TOTP secret, or ErrNoRecord if they haven't
+// enabled two-factor authentication.
+func (m *TwoFactorModel) Secret(userID int) (string, error) {
+	var secret string
+
+	stmt := `SELECT secret FROM two_factor WHERE user_id = ?`
+
+	err := m.DB.QueryRow(stmt, userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// Enable turns on two-factor authentication for the user, replacing any
+// secret and recovery codes they had before.
+func (m *TwoFactorModel) Enable(userID int, secret string, recoveryCodes []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := `INSERT INTO two_factor (user_id, secret, created) VALUES (?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'))
+	ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, last_step = 0, created = excluded.created`
+
+	_, err = tx.Exec(stmt, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		hash := hashRecoveryCode(code)
+
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, hash) VALUES (?, ?)`, userID, hash[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *TwoFactorModel) Disable(userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM two_factor WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that a code from the given TOTP step was used. It reports
+// false if a code from that step or a later one was already used, so that an
+// intercepted code can't be replayed.
+func (m *TwoFactorModel) UseStep(userID int, step int64) (bool, error) {
+	stmt := `UPDATE two_factor SET last_step = ? WHERE user_id = ? AND last_step < ?`
+
+	result, err := m.DB.Exec(stmt, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// UseRecoveryCode deletes the recovery code if the user has it, reporting
+// whether they did.
+func (m *TwoFactorModel) UseRecoveryCode(userID int, code string) (bool, error) {
+	hash := hashRecoveryCode(code)
+
+	stmt := `DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`
+
+	result, err := m.DB.Exec(stmt, userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// hashRecoveryCode hashes the code ignoring case and separators, so that it's
+// accepted however the user types it.
+func hashRecoveryCode(code string) [32]byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+
+	return sha256.Sum256([]byte(code))
+}
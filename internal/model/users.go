@@ -1,18 +1,55 @@
 package model
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"errors"
 	"github.com/mattn/go-sqlite3"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/thisisjab/snippetbox-go/internal/password"
 	"strings"
 	"time"
 )
 
+// Roles users can have. Each role can do everything the ones before it can.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRanks = map[string]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
 type UserModelInterface interface {
-	Insert(name, email, password string) error
+	Insert(name, username, email, password string) (int, error)
 	Authenticate(email, password string) (int, error)
 	Exists(id int) (bool, error)
+	Get(id int) (User, error)
+	GetByEmail(email string) (User, error)
+	GetByUsername(username string) (User, error)
+	UpdateProfile(id int, fullName, username, bio string) error
+	UpdateEmail(id int, oldEmail, newEmail string) error
+	PasswordUpdate(id int, currentPassword, newPassword string) error
+	PasswordSet(id int, newPassword string) error
+	SetVerified(id int) error
+	SetVerificationSent(id int) error
+	RecordLoginFailure(id int) (int, error)
+	Lock(id int, d time.Duration) error
+	ResetLoginFailures(id int) error
+	GetByIdentity(issuer, subject string) (User, error)
+	LinkIdentity(id int, issuer, subject string) error
+	InsertFromIdentity(fullName, email string, verified bool, issuer, subject string) (int, error)
+	List(filter UserFilter, limit int) ([]User, error)
+	SetRole(id int, role string) error
+	SetDisabled(id int, disabled bool) error
+	SetAvatar(id int, uploaded bool) error
+	Delete(id int) error
 }
 
 type User struct {
@@ -21,37 +58,108 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	// Verified reports whether the user has confirmed they own Email.
+	Verified bool
+	// VerificationSent is when the last verification email was sent, or the
+	// zero time if none has been.
+	VerificationSent time.Time
+	// FailedLogins counts failed logins since the last successful one.
+	FailedLogins int
+	// LockedUntil is when a lockout after too many failed logins ends. It's in
+	// the past, or the zero time, if the account isn't locked.
+	LockedUntil time.Time
+	Role        string
+	// Disabled users can't log in or use their API tokens.
+	Disabled bool
+	// Username names the user's public profile. It's empty for users who
+	// haven't picked one, such as those who signed up through single sign-on.
+	Username string
+	Bio      string
+	// AvatarUpdated is when the user last uploaded an avatar, or the zero time
+	// if they're shown an identicon.
+	AvatarUpdated time.Time
+	// HasPassword is false for users who signed up through single sign-on and
+	// haven't set a password since, so they have none to confirm changes with.
+	HasPassword bool
+}
+
+// HasRole reports whether the user's role is role or a more powerful one.
+func (u User) HasRole(role string) bool {
+	rank, ok := roleRanks[role]
+	userRank, userOK := roleRanks[u.Role]
+	return ok && userOK && userRank >= rank
+}
+
+// UserFilter narrows down the users List returns. Empty fields match
+// everyone.
+type UserFilter struct {
+	// Query matches part of the user's name or email address.
+	Query string
+	Role  string
+	// Disabled only matches disabled users.
+	Disabled bool
 }
 
 type UserModel struct {
 	DB *sql.DB
+	// PasswordParams are used to hash new passwords. Stored hashes made with
+	// weaker parameters are upgraded when their user logs in. If it's the zero
+	// value, password.DefaultParams is used.
+	PasswordParams password.Params
 }
 
-func (m *UserModel) Insert(fullName, email, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+func (m *UserModel) passwordParams() password.Params {
+	if m.PasswordParams == (password.Params{}) {
+		return password.DefaultParams
+	}
+
+	return m.PasswordParams
+}
+
+func (m *UserModel) Insert(fullName, username, email, plaintext string) (int, error) {
+	hashedPassword, err := password.Hash(plaintext, m.passwordParams())
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	stmt := `INSERT INTO users (full_name, email, hashed_password, created)
-	VALUES (?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'))`
+	stmt := `INSERT INTO users (full_name, username, email, hashed_password, created)
+	VALUES (?, NULLIF(?, ''), ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'))`
 
-	_, err = m.DB.Exec(stmt, fullName, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, fullName, username, email, hashedPassword)
 	if err != nil {
-		var sqlite3Error sqlite3.Error
+		return 0, duplicateUserError(err)
+	}
 
-		if errors.As(err, &sqlite3Error) {
-			if sqlite3Error.Code == sqlite3.ErrConstraint && strings.Contains(sqlite3Error.Error(), "users.email") {
-				return ErrDuplicateEmail
-			}
-		}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
 
-		return err
+	return int(id), nil
+}
+
+// duplicateUserError translates a violation of the unique email or username
+// constraints into ErrDuplicateEmail or ErrDuplicateUsername, and returns any
+// other error unchanged.
+func duplicateUserError(err error) error {
+	var sqlite3Error sqlite3.Error
+
+	if errors.As(err, &sqlite3Error) && sqlite3Error.Code == sqlite3.ErrConstraint {
+		switch {
+		case strings.Contains(sqlite3Error.Error(), "users.email"):
+			return ErrDuplicateEmail
+		case strings.Contains(sqlite3Error.Error(), "users.username"):
+			return ErrDuplicateUsername
+		}
 	}
-	return nil
+
+	return err
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+// Authenticate returns the ID of the user with the given email and password.
+// If their password was hashed with an older algorithm or weaker parameters,
+// it's rehashed with the current ones.
+func (m *UserModel) Authenticate(email, plaintext string) (int, error) {
 	var userID int
 	var hashedPassword string
 
@@ -67,15 +175,31 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		return 0, err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	needsRehash, err := password.Compare(hashedPassword, plaintext, m.passwordParams())
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		if errors.Is(err, password.ErrMismatch) {
 			return 0, ErrInvalidCredentials
 		}
 
 		return 0, err
 	}
 
+	if needsRehash {
+		newHashedPassword, err := password.Hash(plaintext, m.passwordParams())
+		if err != nil {
+			return 0, err
+		}
+
+		// The old hash is matched as well, so that a password changed since it
+		// was read isn't overwritten.
+		stmt = `UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?`
+
+		_, err = m.DB.Exec(stmt, newHashedPassword, userID, hashedPassword)
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	return userID, nil
 }
 
@@ -86,3 +210,392 @@ func (m *UserModel) Exists(id int) (bool, error) {
 
 	return exists, err
 }
+
+func (m *UserModel) Get(id int) (User, error) {
+	return m.getBy("id", id)
+}
+
+func (m *UserModel) GetByEmail(email string) (User, error) {
+	return m.getBy("email", email)
+}
+
+func (m *UserModel) GetByUsername(username string) (User, error) {
+	return m.getBy("username", username)
+}
+
+// UpdateProfile changes what the user's public profile shows. An empty
+// username is stored as NULL, so that any number of users can go without one.
+// ErrDuplicateUsername is returned if someone else has the username.
+func (m *UserModel) UpdateProfile(id int, fullName, username, bio string) error {
+	stmt := `UPDATE users SET full_name = ?, username = NULLIF(?, ''), bio = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, fullName, username, bio, id)
+
+	return duplicateUserError(err)
+}
+
+// UpdateEmail changes the user's email address from oldEmail to newEmail,
+// which they've confirmed they own, so it's marked as verified. ErrNoRecord is
+// returned if their address is no longer oldEmail, and ErrDuplicateEmail if
+// another account has newEmail.
+func (m *UserModel) UpdateEmail(id int, oldEmail, newEmail string) error {
+	stmt := `UPDATE users SET email = ?, verified = TRUE WHERE id = ? AND email = ?`
+
+	result, err := m.DB.Exec(stmt, newEmail, id, oldEmail)
+	if err != nil {
+		return duplicateUserError(err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// getBy returns the user whose column equals value. column must be a trusted
+// column name, never user input.
+func (m *UserModel) getBy(column string, value any) (User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE ` + column + ` = ?`
+
+	user, err := scanUser(m.DB.QueryRow(stmt, value))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		}
+
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+const userColumns = `id, full_name, email, created, verified, verification_sent, failed_logins, locked_until, role, disabled,
+	COALESCE(username, ''), bio, avatar_updated, has_password`
+
+func scanUser(row interface{ Scan(dest ...any) error }) (User, error) {
+	var user User
+	var verificationSent, lockedUntil, avatarUpdated sql.NullTime
+
+	err := row.Scan(&user.ID, &user.FullName, &user.Email, &user.Created, &user.Verified,
+		&verificationSent, &user.FailedLogins, &lockedUntil, &user.Role, &user.Disabled, &user.Username, &user.Bio,
+		&avatarUpdated, &user.HasPassword)
+	if err != nil {
+		return User{}, err
+	}
+
+	user.VerificationSent = verificationSent.Time
+	user.LockedUntil = lockedUntil.Time
+	user.AvatarUpdated = avatarUpdated.Time
+
+	return user, nil
+}
+
+// List returns up to limit users matching filter, newest first.
+func (m *UserModel) List(filter UserFilter, limit int) ([]User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users
+	WHERE (? = '' OR full_name LIKE '%' || ? || '%' OR email LIKE '%' || ? || '%')
+	AND (? = '' OR role = ?)
+	AND (NOT ? OR disabled)
+	ORDER BY id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, filter.Query, filter.Query, filter.Query, filter.Role, filter.Role, filter.Disabled, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var users []User
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (m *UserModel) SetRole(id int, role string) error {
+	stmt := `UPDATE users SET role = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, role, id)
+
+	return err
+}
+
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	stmt := `UPDATE users SET disabled = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, disabled, id)
+
+	return err
+}
+
+// SetAvatar records that the user has just uploaded an avatar, or if uploaded
+// is false, that they've gone back to their identicon.
+func (m *UserModel) SetAvatar(id int, uploaded bool) error {
+	stmt := `UPDATE users SET avatar_updated = CASE WHEN ? THEN strftime('%Y-%m-%d %H:%M:%S', 'now') END
+	WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, uploaded, id)
+
+	return err
+}
+
+// Delete erases the user's account. Everything which is only theirs goes:
+// their personal snippets, collections, credentials, sessions, avatar and
+// organization memberships, along with any organization nobody else belongs to.
+// Snippets they wrote for organizations which remain stay with them, so the
+// user row itself is kept but stripped of their details, and it can never be
+// logged into again.
+func (m *UserModel) Delete(id int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// soleOrgs selects the organizations the user is the only member of, and
+	// doomed the snippets which go with the account.
+	const (
+		soleOrgs = `SELECT organization_id FROM organization_members WHERE user_id = :id
+		AND organization_id NOT IN (SELECT organization_id FROM organization_members WHERE user_id <> :id)`
+		doomed = `SELECT id FROM snippets WHERE (user_id = :id AND organization_id IS NULL)
+		OR organization_id IN (` + soleOrgs + `)`
+	)
+
+	for _, stmt := range []string{
+		`DELETE FROM snippet_views WHERE snippet_id IN (` + doomed + `)`,
+		`DELETE FROM collection_snippets WHERE snippet_id IN (` + doomed + `)`,
+		`DELETE FROM snippets WHERE id IN (` + doomed + `)`,
+		`DELETE FROM invites WHERE organization_id IN (` + soleOrgs + `)
+		OR (created_by = :id AND organization_id IS NOT NULL AND used IS NULL)`,
+		`DELETE FROM organizations WHERE id IN (` + soleOrgs + `)`,
+		`DELETE FROM organization_members WHERE user_id = :id`,
+		`DELETE FROM collection_snippets WHERE collection_id IN (SELECT id FROM collections WHERE user_id = :id)`,
+		`DELETE FROM collections WHERE user_id = :id`,
+		`DELETE FROM tokens WHERE user_id = :id`,
+		`DELETE FROM api_tokens WHERE user_id = :id`,
+		`DELETE FROM two_factor WHERE user_id = :id`,
+		`DELETE FROM recovery_codes WHERE user_id = :id`,
+		`DELETE FROM passkeys WHERE user_id = :id`,
+		`DELETE FROM user_identities WHERE user_id = :id`,
+		`DELETE FROM user_sessions WHERE user_id = :id`,
+		`DELETE FROM avatars WHERE user_id = :id`,
+		`DELETE FROM invites WHERE used_by = :id`,
+		`UPDATE users SET full_name = 'Deleted user', email = 'deleted-' || id || '@deleted.invalid', username = NULL,
+		bio = '', hashed_password = '', verified = FALSE, verification_sent = NULL, failed_logins = 0,
+		locked_until = NULL, role = 'user', disabled = TRUE, avatar_updated = NULL, has_password = FALSE WHERE id = :id`,
+	} {
+		_, err = tx.Exec(stmt, sql.Named("id", id))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PasswordUpdate replaces the user's password after checking that
+// currentPassword matches the stored one. ErrInvalidCredentials is returned if
+// it doesn't.
+func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+	var currentHashedPassword string
+
+	stmt := `SELECT hashed_password FROM users WHERE id = ?`
+
+	err := m.DB.QueryRow(stmt, id).Scan(&currentHashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+
+		return err
+	}
+
+	_, err = password.Compare(currentHashedPassword, currentPassword, m.passwordParams())
+	if err != nil {
+		if errors.Is(err, password.ErrMismatch) {
+			return ErrInvalidCredentials
+		}
+
+		return err
+	}
+
+	return m.PasswordSet(id, newPassword)
+}
+
+// PasswordSet replaces the user's password without checking the current one.
+// Callers must have verified the user some other way, such as with a reset
+// token.
+func (m *UserModel) PasswordSet(id int, newPassword string) error {
+	newHashedPassword, err := password.Hash(newPassword, m.passwordParams())
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET hashed_password = ?, has_password = TRUE WHERE id = ?`
+
+	_, err = m.DB.Exec(stmt, newHashedPassword, id)
+
+	return err
+}
+
+func (m *UserModel) SetVerified(id int) error {
+	stmt := `UPDATE users SET verified = TRUE WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, id)
+
+	return err
+}
+
+// SetVerificationSent records that a verification email was just sent to the
+// user, so that resending can be throttled.
+func (m *UserModel) SetVerificationSent(id int) error {
+	stmt := `UPDATE users SET verification_sent = strftime('%Y-%m-%d %H:%M:%S', 'now') WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, id)
+
+	return err
+}
+
+// RecordLoginFailure counts a failed login for the user and returns how many
+// there have been since the last successful one.
+func (m *UserModel) RecordLoginFailure(id int) (int, error) {
+	stmt := `UPDATE users SET failed_logins = failed_logins + 1 WHERE id = ? RETURNING failed_logins`
+
+	var failures int
+
+	err := m.DB.QueryRow(stmt, id).Scan(&failures)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+
+		return 0, err
+	}
+
+	return failures, nil
+}
+
+// Lock stops the user from logging in for d.
+func (m *UserModel) Lock(id int, d time.Duration) error {
+	stmt := `UPDATE users SET locked_until = datetime(strftime('%Y-%m-%d %H:%M:%S', 'now'), '+' || ? || ' seconds')
+	WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, int(d.Seconds()), id)
+
+	return err
+}
+
+// ResetLoginFailures clears the user's failed login count and any lockout,
+// after a successful login or when an administrator unlocks the account.
+func (m *UserModel) ResetLoginFailures(id int) error {
+	stmt := `UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, id)
+
+	return err
+}
+
+// GetByIdentity returns the user an external identity provider's account is
+// linked to, or ErrNoRecord if it isn't linked.
+func (m *UserModel) GetByIdentity(issuer, subject string) (User, error) {
+	var id int
+
+	stmt := `SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?`
+
+	err := m.DB.QueryRow(stmt, issuer, subject).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		}
+
+		return User{}, err
+	}
+
+	return m.Get(id)
+}
+
+// LinkIdentity lets the user sign in with their account at an external
+// identity provider.
+func (m *UserModel) LinkIdentity(id int, issuer, subject string) error {
+	stmt := `INSERT INTO user_identities (issuer, subject, user_id, created)
+	VALUES (?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'))`
+
+	_, err := m.DB.Exec(stmt, issuer, subject, id)
+
+	return err
+}
+
+// InsertFromIdentity creates a user the first time they sign in through an
+// external identity provider and links the provider's account to it. They get
+// a random password, which they can replace through a password reset if they
+// ever want to log in without the provider.
+func (m *UserModel) InsertFromIdentity(fullName, email string, verified bool, issuer, subject string) (int, error) {
+	random := make([]byte, 32)
+
+	_, err := rand.Read(random)
+	if err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := password.Hash(base64.RawStdEncoding.EncodeToString(random), m.passwordParams())
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt := `INSERT INTO users (full_name, email, hashed_password, created, verified, has_password)
+	VALUES (?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'), ?, FALSE)`
+
+	result, err := tx.Exec(stmt, fullName, email, hashedPassword, verified)
+	if err != nil {
+		return 0, duplicateUserError(err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `INSERT INTO user_identities (issuer, subject, user_id, created)
+	VALUES (?, ?, ?, strftime('%Y-%m-%d %H:%M:%S', 'now'))`
+
+	_, err = tx.Exec(stmt, issuer, subject, id)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
@@ -0,0 +1,193 @@
+// Package ogimage renders the Open Graph preview images shown when a snippet is
+// shared on social sites and chat tools.
+package ogimage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	Width  = 1200
+	Height = 630
+
+	padding   = 60
+	maxLines  = 14
+	tabWidth  = 4
+	titleSize = 44
+	codeSize  = 26
+)
+
+var (
+	background  = color.RGBA{R: 0x34, G: 0x49, B: 0x5E, A: 0xFF}
+	codeColor   = color.RGBA{R: 0xF1, G: 0xF3, B: 0xF6, A: 0xFF}
+	accentColor = color.RGBA{R: 0x62, G: 0xCB, B: 0x31, A: 0xFF}
+)
+
+// Generator renders preview images and caches them in a directory, keyed by a
+// hash of what they show. Edited snippets therefore get a fresh image without
+// any explicit invalidation.
+type Generator struct {
+	cacheDir  string
+	titleFont *opentype.Font
+	codeFont  *opentype.Font
+}
+
+func New(cacheDir string) (*Generator, error) {
+	err := os.MkdirAll(cacheDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	titleFont, err := opentype.Parse(gomonobold.TTF)
+	if err != nil {
+		return nil, err
+	}
+
+	codeFont, err := opentype.Parse(gomono.TTF)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Generator{cacheDir: cacheDir, titleFont: titleFont, codeFont: codeFont}, nil
+}
+
+// Snippet returns a PNG showing the title and the first lines of the content,
+// rendering it only if it isn't already cached.
+func (g *Generator) Snippet(title, content string) ([]byte, error) {
+	sum := sha256.Sum256([]byte(title + "\x00" + content))
+	path := filepath.Join(g.cacheDir, hex.EncodeToString(sum[:])+".png")
+
+	b, err := os.ReadFile(path)
+	if err == nil {
+		return b, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	b, err = g.render(title, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first so that concurrent requests never read a
+	// partially written image.
+	tmp, err := os.CreateTemp(g.cacheDir, "*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (g *Generator) render(title, content string) ([]byte, error) {
+	// Faces aren't safe for concurrent use, so each render gets its own.
+	titleFace, err := opentype.NewFace(g.titleFont, &opentype.FaceOptions{Size: titleSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer titleFace.Close()
+
+	codeFace, err := opentype.NewFace(g.codeFont, &opentype.FaceOptions{Size: codeSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer codeFace.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, Width, 12), image.NewUniform(accentColor), image.Point{}, draw.Src)
+
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(accentColor), Face: titleFace}
+	y := padding + titleFace.Metrics().Ascent.Ceil()
+	d.Dot = fixed.P(padding, y)
+	d.DrawString(fit(title, columns(titleFace)))
+
+	d.Src = image.NewUniform(codeColor)
+	d.Face = codeFace
+	lineHeight := codeFace.Metrics().Height.Ceil() + 6
+	y += titleFace.Metrics().Descent.Ceil() + lineHeight + 18
+
+	width := columns(codeFace)
+	for _, line := range firstLines(content, maxLines) {
+		if y > Height-padding {
+			break
+		}
+
+		d.Dot = fixed.P(padding, y)
+		d.DrawString(fit(line, width))
+		y += lineHeight
+	}
+
+	var buf bytes.Buffer
+
+	err = png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// columns returns how many characters of a monospaced face fit on one line.
+func columns(face font.Face) int {
+	advance, ok := face.GlyphAdvance('M')
+	if !ok || advance <= 0 {
+		return 1
+	}
+
+	return (Width - 2*padding) / advance.Ceil()
+}
+
+func firstLines(content string, n int) []string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.ReplaceAll(content, "\t", strings.Repeat(" ", tabWidth))
+
+	lines := strings.SplitN(content, "\n", n+1)
+	if len(lines) > n {
+		lines = append(lines[:n-1], "…")
+	}
+
+	return lines
+}
+
+// fit shortens s to at most n characters, marking it with an ellipsis if
+// anything was cut off.
+func fit(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	return string([]rune(s)[:n-1]) + "…"
+}
@@ -0,0 +1,154 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+	"github.com/thisisjab/snippetbox-go/internal/oidc"
+	"github.com/thisisjab/snippetbox-go/internal/oidc/oidctest"
+)
+
+const redirectURL = "https://snippetbox.test/user/login/sso/callback"
+
+func newProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	fake, err := oidctest.New("snippetbox", "s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fake.Close)
+
+	fake.SetUser(oidctest.User{Subject: "1234", Email: "alice@example.com", EmailVerified: true, Name: "Alice Jones"})
+
+	return fake, oidc.New(fake.URL, fake.ClientID, fake.ClientSecret, fake.Client())
+}
+
+func TestFlow(t *testing.T) {
+	fake, provider := newProvider(t)
+	ctx := context.Background()
+
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, redirectURL, "state", "nonce", oidc.CodeChallenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := fake.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, location.Query().Get("state"), "state")
+
+	code := location.Query().Get("code")
+
+	// The code is bound to the verifier.
+	_, err = provider.Exchange(ctx, redirectURL, code, "wrong verifier")
+	if !errors.Is(err, oidc.ErrTokenExchange) {
+		t.Fatalf("got error %v; want %v", err, oidc.ErrTokenExchange)
+	}
+
+	res, err = client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	location, _ = url.Parse(res.Header.Get("Location"))
+
+	idToken, err := provider.Exchange(ctx, redirectURL, location.Query().Get("code"), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := provider.Verify(ctx, idToken, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, claims.Subject, "1234")
+	assert.Equal(t, claims.Email, "alice@example.com")
+	assert.Equal(t, claims.EmailVerified, true)
+	assert.Equal(t, claims.Issuer, fake.URL)
+}
+
+func TestVerify(t *testing.T) {
+	fake, provider := newProvider(t)
+	user := oidctest.User{Subject: "1234", Email: "alice@example.com"}
+
+	tests := []struct {
+		name   string
+		modify func(claims map[string]any)
+		token  func(token string) string
+	}{
+		{
+			name:   "Wrong nonce",
+			modify: func(c map[string]any) { c["nonce"] = "other" },
+		},
+		{
+			name:   "Wrong audience",
+			modify: func(c map[string]any) { c["aud"] = "someone-else" },
+		},
+		{
+			name:   "Wrong issuer",
+			modify: func(c map[string]any) { c["iss"] = "https://evil.test" },
+		},
+		{
+			name:   "Expired",
+			modify: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		},
+		{
+			name: "Tampered payload",
+			token: func(token string) string {
+				parts := strings.Split(token, ".")
+				forged := fake.SignIDToken(fake.Claims(oidctest.User{Subject: "admin"}, "nonce"))
+				return parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+			},
+		},
+		{
+			name: "Unsigned",
+			token: func(token string) string {
+				parts := strings.Split(token, ".")
+				return "eyJhbGciOiJub25lIn0." + parts[1] + "."
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := fake.Claims(user, "nonce")
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+
+			token := fake.SignIDToken(claims)
+			if tt.token != nil {
+				token = tt.token(token)
+			}
+
+			_, err := provider.Verify(context.Background(), token, "nonce")
+			if !errors.Is(err, oidc.ErrInvalidToken) {
+				t.Errorf("got error %v; want %v", err, oidc.ErrInvalidToken)
+			}
+		})
+	}
+}
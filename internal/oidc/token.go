@@ -0,0 +1,289 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far the provider's clock may be off from ours when checking
+// when a token was issued and expires.
+const clockSkew = time.Minute
+
+// Claims are the parts of a verified ID token the site uses.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type idTokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type idTokenClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      audience        `json:"aud"`
+	AuthorizedBy  string          `json:"azp"`
+	Expiry        int64           `json:"exp"`
+	IssuedAt      int64           `json:"iat"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified json.RawMessage `json:"email_verified"`
+	Name          string          `json:"name"`
+}
+
+// audience is the aud claim, which may be a single string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+
+	return nil
+}
+
+// Verify checks the ID token's signature against the provider's published
+// keys, and that it was issued by the provider for this client, hasn't
+// expired and carries nonce.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	_, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header idTokenHeader
+
+	err = decodeSegment(parts[0], &header)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	// Only accepting RS256 rules out "none" and algorithm confusion attacks.
+	if header.Alg != "RS256" {
+		return Claims{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	key, err := p.keys.get(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var c idTokenClaims
+
+	err = decodeSegment(parts[1], &c)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	now := time.Now()
+
+	switch {
+	case strings.TrimSuffix(c.Issuer, "/") != p.issuer:
+		return Claims{}, fmt.Errorf("%w: wrong issuer %q", ErrInvalidToken, c.Issuer)
+	case !c.Audience.contains(p.clientID):
+		return Claims{}, fmt.Errorf("%w: not issued for this client", ErrInvalidToken)
+	case len(c.Audience) > 1 && c.AuthorizedBy != p.clientID:
+		return Claims{}, fmt.Errorf("%w: not authorized for this client", ErrInvalidToken)
+	case now.After(time.Unix(c.Expiry, 0).Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case now.Add(clockSkew).Before(time.Unix(c.IssuedAt, 0)):
+		return Claims{}, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1 || nonce == "":
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	case c.Subject == "":
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+
+	return Claims{
+		Issuer:        p.issuer,
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: emailVerified(c.EmailVerified),
+		Name:          c.Name,
+	}, nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// emailVerified reads the email_verified claim, which some providers send as a
+// string rather than a boolean.
+func emailVerified(raw json.RawMessage) bool {
+	var b bool
+	if err := json.Unmarshal(raw, &b); err == nil {
+		return b
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s == "true"
+	}
+
+	return false
+}
+
+func decodeSegment(segment string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed segment", ErrInvalidToken)
+	}
+
+	err = json.Unmarshal(b, dst)
+	if err != nil {
+		return fmt.Errorf("%w: malformed segment", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// keyRefreshInterval limits how often the key set is refetched because a
+// token named a key we don't know, so that forged tokens can't make us hammer
+// the provider.
+const keyRefreshInterval = time.Minute
+
+// keySet caches the provider's signing keys, refetching them when a token is
+// signed with a key that isn't cached, as happens when the provider rotates
+// its keys.
+type keySet struct {
+	uri     string
+	getJSON func(ctx context.Context, url string, dst any) error
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func newKeySet(uri string, getJSON func(ctx context.Context, url string, dst any) error) *keySet {
+	return &keySet{uri: uri, getJSON: getJSON}
+}
+
+func (ks *keySet) get(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	if time.Since(ks.fetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+
+	err := ks.refresh(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := ks.lookup(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+}
+
+// lookup finds the key with the given ID. Tokens may leave the ID out when the
+// provider only has one key.
+func (ks *keySet) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+
+	key, ok := ks.keys[kid]
+
+	return key, ok
+}
+
+func (ks *keySet) refresh(ctx context.Context) error {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	ks.fetched = time.Now()
+
+	err := ks.getJSON(ctx, ks.uri, &jwks)
+	if err != nil {
+		return fmt.Errorf("oidc: fetching keys failed: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+		if key.N.BitLen() < 2048 {
+			continue
+		}
+
+		keys[k.Kid] = key
+	}
+
+	ks.keys = keys
+
+	return nil
+}
@@ -0,0 +1,147 @@
+// Package password hashes passwords with argon2id and checks them against
+// hashes made by this package or by bcrypt, which was used before. Hashes are
+// encoded in the PHC string format, such as
+// "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>", so they carry the algorithm
+// and parameters they were made with and can be upgraded later.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"runtime"
+	"strings"
+)
+
+var (
+	ErrMismatch    = errors.New("password: hash doesn't match password")
+	ErrInvalidHash = errors.New("password: invalid encoded hash")
+)
+
+// slots limits how many argon2id hashes are computed at once. Each one holds
+// Params.Memory for as long as it runs, so a burst of logins could otherwise
+// use up all of the server's memory; past one per CPU they only slow each other
+// down anyway.
+var slots = make(chan struct{}, runtime.NumCPU())
+
+// Params tune the cost of argon2id. Memory is in KiB.
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams are the second recommended option from RFC 9106, for when the
+// first option's 2 GiB of memory per hash is too much.
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Hash returns the encoded argon2id hash of password with a random salt.
+func Hash(password string, p Params) (string, error) {
+	salt := make([]byte, p.SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := idKey([]byte(password), salt, p)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Compare checks password against an encoded hash, returning ErrMismatch if
+// it's wrong. If it's right, needsRehash reports whether the hash should be
+// replaced with Hash(password, p), because it was made with another algorithm
+// or with parameters weaker than p.
+func Compare(encoded, password string, p Params) (needsRehash bool, err error) {
+	if isBcrypt(encoded) {
+		err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, ErrMismatch
+			}
+
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	hp, salt, key, err := decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := idKey([]byte(password), salt, hp)
+
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, ErrMismatch
+	}
+
+	needsRehash = hp.Memory < p.Memory ||
+		hp.Iterations < p.Iterations ||
+		hp.Parallelism < p.Parallelism ||
+		hp.SaltLength < p.SaltLength ||
+		hp.KeyLength < p.KeyLength
+
+	return needsRehash, nil
+}
+
+// idKey derives an argon2id key once a slot is free.
+func idKey(password, salt []byte, p Params) []byte {
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	return argon2.IDKey(password, salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func decode(encoded string) (p Params, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism)
+	if err != nil || p.Iterations == 0 || p.Parallelism == 0 {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Params{}, nil, nil, ErrInvalidHash
+	}
+
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
@@ -0,0 +1,122 @@
+package password
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep the tests fast; they're far too weak for real use.
+var testParams = Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHash(t *testing.T) {
+	hash, err := Hash("pa$$word", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), true)
+
+	other, err := Hash("pa$$word", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Salts are random, so the same password never hashes the same way twice.
+	assert.NotEqual(t, hash, other)
+
+	needsRehash, err := Compare(hash, "pa$$word", testParams)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, needsRehash, false)
+
+	_, err = Compare(hash, "wrong", testParams)
+	assert.Equal(t, err, ErrMismatch)
+}
+
+func TestCompareRehash(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("pa$$word"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	needsRehash, err := Compare(string(bcryptHash), "pa$$word", testParams)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, needsRehash, true)
+
+	_, err = Compare(string(bcryptHash), "wrong", testParams)
+	assert.Equal(t, err, ErrMismatch)
+
+	hash, err := Hash("pa$$word", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := testParams
+	stronger.Iterations = 2
+
+	needsRehash, err = Compare(hash, "pa$$word", stronger)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, needsRehash, true)
+
+	// A wrong password never asks for a rehash, even with weak parameters.
+	needsRehash, err = Compare(hash, "wrong", stronger)
+	assert.Equal(t, err, ErrMismatch)
+	assert.Equal(t, needsRehash, false)
+
+	weaker := testParams
+	weaker.Memory = 32
+
+	needsRehash, err = Compare(hash, "pa$$word", weaker)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, needsRehash, false)
+}
+
+func TestCompareInvalidHash(t *testing.T) {
+	tests := []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$",
+	}
+
+	for _, encoded := range tests {
+		_, err := Compare(encoded, "pa$$word", testParams)
+		assert.Equal(t, err, ErrInvalidHash)
+	}
+}
+
+func TestHashWaitsForSlot(t *testing.T) {
+	for range cap(slots) {
+		slots <- struct{}{}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		Hash("pa$$word", testParams)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("hashed while every slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	<-slots
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after a slot was freed")
+	}
+
+	for range cap(slots) - 1 {
+		<-slots
+	}
+}
@@ -0,0 +1,63 @@
+package signer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+func TestVerify(t *testing.T) {
+	s := New([]byte("0123456789abcdef0123456789abcdef"))
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+
+	token := s.Sign("reset", "1:alice@example.com", expires)
+
+	payload, err := s.Verify("reset", token, now)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, payload, "1:alice@example.com")
+
+	// Tokens work up to, but not at, the moment they expire.
+	_, err = s.Verify("reset", token, expires.Add(-time.Second))
+	assert.Equal(t, err, nil)
+
+	_, err = s.Verify("reset", token, expires)
+	assert.Equal(t, err, ErrExpired)
+
+	// A token signed for one purpose isn't accepted for another.
+	_, err = s.Verify("verify", token, now)
+	assert.Equal(t, err, ErrInvalidSignature)
+
+	_, err = New([]byte("another key")).Verify("reset", token, now)
+	assert.Equal(t, err, ErrInvalidSignature)
+}
+
+func TestVerifyTampered(t *testing.T) {
+	s := New([]byte("0123456789abcdef0123456789abcdef"))
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	token := s.Sign("reset", "1:alice@example.com", now.Add(time.Hour))
+	parts := strings.Split(token, ".")
+	forged := s.Sign("reset", "2:bob@example.com", now.Add(time.Hour))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "Empty", token: ""},
+		{name: "No signature", token: parts[0] + "." + parts[1]},
+		{name: "Changed payload", token: strings.Split(forged, ".")[0] + "." + parts[1] + "." + parts[2]},
+		{name: "Extended expiry", token: parts[0] + ".9999999999." + parts[2]},
+		{name: "Changed signature", token: parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))},
+		{name: "Bad encoding", token: parts[0] + "." + parts[1] + ".!!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Verify("reset", tt.token, now)
+			assert.Equal(t, err, ErrInvalidSignature)
+		})
+	}
+}
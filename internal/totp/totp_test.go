@@ -0,0 +1,64 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/go-playground/assert"
+)
+
+// The secret from the RFC 6238 test vectors, "12345678901234567890".
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B lists 8 digit SHA-1 codes; ours are the last 6.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, got, tt.want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	code, err := Code(rfcSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := Validate(rfcSecret, code, now)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, step, Step(now))
+
+	// Codes stay valid for one period either side to allow for clock drift.
+	_, ok = Validate(rfcSecret, code, now.Add(Period))
+	assert.Equal(t, ok, true)
+
+	_, ok = Validate(rfcSecret, code, now.Add(-Period))
+	assert.Equal(t, ok, true)
+
+	_, ok = Validate(rfcSecret, code, now.Add(3*Period))
+	assert.Equal(t, ok, false)
+
+	_, ok = Validate(rfcSecret, "000000", now)
+	assert.Equal(t, ok, false)
+
+	_, ok = Validate("not base32!", code, now)
+	assert.Equal(t, ok, false)
+}
@@ -0,0 +1,176 @@
+package validator
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	MinLength int
+	// MinEntropy is the least number of bits PasswordEntropy must estimate.
+	MinEntropy float64
+	// Breached lists passwords which have leaked and mustn't be used. A nil
+	// list isn't checked.
+	Breached *BreachedPasswords
+}
+
+// Check adds an error for key to v if password doesn't meet the policy.
+// personal holds details about the user, such as their name and email
+// address, which the password mustn't contain. An error is only returned if
+// the breached password list couldn't be read.
+func (p *PasswordPolicy) Check(v *Validator, key, password string, personal ...string) error {
+	v.CheckField(MinChars(password, p.MinLength), key, fmt.Sprintf("This field must be at least %d characters long", p.MinLength))
+	v.CheckField(NotContainsPersonal(password, personal...), key, "This field must not contain your name or email address")
+	v.CheckField(PasswordEntropy(password) >= p.MinEntropy, key, "This password is too easy to guess. Try a longer one, or mix in other kinds of characters")
+
+	if _, exists := v.FieldErrors[key]; exists || p.Breached == nil {
+		return nil
+	}
+
+	breached, err := p.Breached.Contains(password)
+	if err != nil {
+		return err
+	}
+
+	v.CheckField(!breached, key, "This password has appeared in a data breach, so it isn't safe to use")
+
+	return nil
+}
+
+// NotContainsPersonal reports whether password contains none of the given
+// details, nor any word of them which is at least three characters long,
+// ignoring case. Email addresses are split at the @.
+func NotContainsPersonal(password string, personal ...string) bool {
+	password = strings.ToLower(password)
+
+	for _, value := range personal {
+		words := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+			return unicode.IsSpace(r) || r == '@'
+		})
+
+		for _, word := range words {
+			if utf8.RuneCountInString(word) >= 3 && strings.Contains(password, word) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// PasswordEntropy estimates how many bits of entropy password has, from the
+// kinds of characters it uses and how long it is. Characters which repeat the
+// previous one or continue a run such as "abc" or "321" count for a single bit,
+// and ones which appeared earlier count for half.
+func PasswordEntropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+
+	if pool == 0 {
+		return 0
+	}
+
+	bitsPerChar := math.Log2(float64(pool))
+
+	var bits float64
+	var prev rune
+	seen := make(map[rune]bool)
+
+	for i, r := range password {
+		delta := r - prev
+
+		switch {
+		case i > 0 && delta >= -1 && delta <= 1:
+			bits++
+		case seen[r]:
+			bits += bitsPerChar / 2
+		default:
+			bits += bitsPerChar
+		}
+
+		prev = r
+		seen[r] = true
+	}
+
+	return bits
+}
+
+// BreachedPasswords looks passwords up in a local copy of the Pwned Passwords
+// list, stored the way its k-anonymity range API serves it so that no
+// password, or even its full hash, is sent anywhere. Each file is named after
+// the first five hex digits of SHA-1 hashes, such as "21BD1.txt", and has a
+// "SUFFIX:COUNT" line for each hash with that prefix.
+type BreachedPasswords struct {
+	fsys fs.FS
+}
+
+func NewBreachedPasswords(fsys fs.FS) *BreachedPasswords {
+	return &BreachedPasswords{fsys: fsys}
+}
+
+// Contains reports whether password is in the list. A missing prefix file
+// means none of its hashes have been breached, so partial lists work too.
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := b.fsys.Open(prefix + ".txt")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+
+		// The range API pads responses with made up hashes which have a
+		// count of zero.
+		n, err := strconv.Atoi(count)
+		return err != nil || n > 0, nil
+	}
+
+	return false, scanner.Err()
+}
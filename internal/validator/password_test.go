@@ -0,0 +1,101 @@
+package validator
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-playground/assert"
+)
+
+func TestPasswordEntropy(t *testing.T) {
+	tests := []struct {
+		password string
+		weak     bool
+	}{
+		{password: "", weak: true},
+		{password: "password", weak: true},
+		{password: "12345678", weak: true},
+		{password: "abcdefghijkl", weak: true},
+		{password: "aaaaaaaaaaaaaaaa", weak: true},
+		{password: "Tr0ub4dor&3", weak: false},
+		{password: "correcthorsebatterystaple", weak: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			assert.Equal(t, PasswordEntropy(tt.password) < 40, tt.weak)
+		})
+	}
+}
+
+func TestNotContainsPersonal(t *testing.T) {
+	assert.Equal(t, NotContainsPersonal("xxJONESxx!", "Alice Jones", "alice@example.com"), false)
+	assert.Equal(t, NotContainsPersonal("example.com!", "Alice Jones", "alice@example.com"), false)
+	// Words shorter than three characters are too likely to match by chance.
+	assert.Equal(t, NotContainsPersonal("BoLeyn", "Bo Li", "bo@li.example"), true)
+	assert.Equal(t, NotContainsPersonal("Tr0ub4dor&3", "Alice Jones", "alice@example.com"), true)
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	hash := fmt.Sprintf("%X", sha1.Sum([]byte("password")))
+	padding := fmt.Sprintf("%X", sha1.Sum([]byte("padding")))
+
+	breached := NewBreachedPasswords(fstest.MapFS{
+		hash[:5] + ".txt":    {Data: []byte("0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + hash[5:] + ":10434004\r\n")},
+		padding[:5] + ".txt": {Data: []byte(padding[5:] + ":0\r\n")},
+	})
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{password: "password", want: true},
+		{password: "padding", want: false},
+		{password: "Tr0ub4dor&3", want: false},
+	}
+
+	for _, tt := range tests {
+		got, err := breached.Contains(tt.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, got, tt.want)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	hash := fmt.Sprintf("%X", sha1.Sum([]byte("Tr0ub4dor&3")))
+
+	policy := &PasswordPolicy{
+		MinLength:  8,
+		MinEntropy: 40,
+		Breached:   NewBreachedPasswords(fstest.MapFS{hash[:5] + ".txt": {Data: []byte(hash[5:] + ":3\n")}}),
+	}
+
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{password: "tiny", valid: false},
+		{password: "AliceRocks!2024", valid: false},
+		{password: "abcdefgh1234", valid: false},
+		{password: "Tr0ub4dor&3", valid: false},
+		{password: "correcthorsebatterystaple", valid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			var v Validator
+
+			err := policy.Check(&v, "password", tt.password, "Alice Jones", "alice@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, v.Valid(), tt.valid)
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package validator
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// UsernameRX matches usernames: 3 to 30 lowercase letters, digits, hyphens or
+// underscores, starting with a letter or digit so that they read well in URLs.
+var UsernameRX = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{2,29}$`)
+
+// reservedUsernames can't be taken, because profiles with them could be
+// mistaken for the site's own pages or for its staff.
+var reservedUsernames = []string{
+	"about", "account", "admin", "administrator", "api", "collections", "help",
+	"login", "logout", "me", "moderator", "new", "orgs", "root", "security",
+	"settings", "signup", "snippetbox", "snippets", "static", "staff", "support",
+	"system", "u", "user", "users",
+}
+
+// NotReservedUsername reports whether username is free for users to take.
+func NotReservedUsername(username string) bool {
+	return !slices.Contains(reservedUsernames, strings.ToLower(username))
+}
@@ -0,0 +1,30 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/go-playground/assert"
+)
+
+func TestUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{username: "alice", valid: true},
+		{username: "bob_smith-2", valid: true},
+		{username: "ab", valid: false},
+		{username: "Alice", valid: false},
+		{username: "-alice", valid: false},
+		{username: "alice smith", valid: false},
+		{username: "admin", valid: false},
+		{username: "snippetbox", valid: false},
+		{username: "administrators", valid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			assert.Equal(t, Matches(tt.username, UsernameRX) && NotReservedUsername(tt.username), tt.valid)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// errCBOR is returned for any data the decoder can't make sense of.
+var errCBOR = errors.New("webauthn: malformed CBOR")
+
+// maxCBORDepth bounds how deeply nested arrays and maps may be, so that hostile
+// input can't exhaust the stack.
+const maxCBORDepth = 8
+
+// decodeCBOR decodes the first CBOR data item in b and returns it along with
+// the bytes which follow it. Only the subset WebAuthn uses is supported:
+// integers, byte and text strings, arrays, maps and the simple values true,
+// false and null. Integers decode to int64, maps to map[any]any.
+func decodeCBOR(b []byte) (any, []byte, error) {
+	return decodeItem(b, 0)
+}
+
+func decodeItem(b []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deeply", errCBOR)
+	}
+
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, b[1:], nil
+		case 21:
+			return true, b[1:], nil
+		case 22:
+			return nil, b[1:], nil
+		default:
+			return nil, nil, fmt.Errorf("%w: unsupported simple value %d", errCBOR, info)
+		}
+	}
+
+	arg, b, err := readArgument(info, b[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, nil, fmt.Errorf("%w: integer overflows int64", errCBOR)
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, nil, fmt.Errorf("%w: integer overflows int64", errCBOR)
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+		if major == 2 {
+			return b[:arg], b[arg:], nil
+		}
+		return string(b[:arg]), b[arg:], nil
+	case 4:
+		// Every item takes at least one byte, which caps the length of any
+		// well-formed array before allocating for it.
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+
+		items := make([]any, arg)
+		for i := range items {
+			items[i], b, err = decodeItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, b, nil
+	case 5:
+		if arg > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+		}
+
+		m := make(map[any]any, arg)
+		for range arg {
+			var key, value any
+
+			key, b, err = decodeItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key %T", errCBOR, key)
+			}
+
+			value, b, err = decodeItem(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			m[key] = value
+		}
+		return m, b, nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported major type %d", errCBOR, major)
+	}
+}
+
+// readArgument reads the argument which follows an initial byte with the given
+// additional information.
+func readArgument(info byte, b []byte) (uint64, []byte, error) {
+	var n int
+
+	switch {
+	case info < 24:
+		return uint64(info), b, nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	default:
+		// Indefinite lengths aren't allowed in the canonical CBOR authenticators
+		// produce.
+		return 0, nil, fmt.Errorf("%w: unsupported additional information %d", errCBOR, info)
+	}
+
+	if len(b) < n {
+		return 0, nil, fmt.Errorf("%w: unexpected end of data", errCBOR)
+	}
+
+	var arg uint64
+	switch n {
+	case 1:
+		arg = uint64(b[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(b))
+	case 8:
+		arg = binary.BigEndian.Uint64(b)
+	}
+
+	return arg, b[n:], nil
+}
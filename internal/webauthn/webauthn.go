@@ -0,0 +1,298 @@
+// Package webauthn implements the server side of passkey registration and
+// login as described in the Web Authentication spec. It supports what
+// passkeys need in practice and nothing more: ES256 credentials, and "none"
+// attestation, so authenticators are trusted on first use rather than checked
+// against a vendor's certificate.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// AlgES256 is the COSE identifier of ECDSA with P-256 and SHA-256, the only
+// signature algorithm supported.
+const AlgES256 = -7
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+var (
+	ErrInvalidResponse = errors.New("webauthn: invalid authenticator response")
+	ErrChallenge       = errors.New("webauthn: challenge mismatch")
+	ErrOrigin          = errors.New("webauthn: origin mismatch")
+	ErrRelyingParty    = errors.New("webauthn: relying party mismatch")
+	ErrUserPresence    = errors.New("webauthn: user wasn't present")
+	ErrUserVerified    = errors.New("webauthn: user wasn't verified")
+	ErrUnsupportedKey  = errors.New("webauthn: unsupported credential key")
+	ErrSignature       = errors.New("webauthn: invalid signature")
+	ErrSignCount       = errors.New("webauthn: signature counter went backwards, the credential may have been cloned")
+)
+
+// RelyingParty identifies the site credentials are created for. ID is the
+// site's domain and Origin the scheme, host and port pages are served from.
+type RelyingParty struct {
+	ID     string
+	Name   string
+	Origin string
+}
+
+// Credential is what needs to be stored after registration to verify logins.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+// NewChallenge returns a random challenge for a ceremony. It must be kept on
+// the server, for example in the session, until the response comes back.
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+
+	_, err := rand.Read(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// clientData is the part of the JSON the browser signs over which needs
+// checking.
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp RelyingParty) checkClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var cd clientData
+
+	err := json.Unmarshal(clientDataJSON, &cd)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	if cd.Type != ceremony {
+		return fmt.Errorf("%w: unexpected type %q", ErrInvalidResponse, cd.Type)
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(cd.Challenge)
+	if err != nil || len(challenge) == 0 || !bytes.Equal(got, challenge) {
+		return ErrChallenge
+	}
+
+	if cd.Origin != rp.Origin {
+		return ErrOrigin
+	}
+
+	return nil
+}
+
+// authenticatorData is the parsed binary structure authenticators sign.
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(b []byte) (authenticatorData, error) {
+	var ad authenticatorData
+
+	if len(b) < 37 {
+		return ad, fmt.Errorf("%w: authenticator data too short", ErrInvalidResponse)
+	}
+
+	ad.rpIDHash = b[:32]
+	ad.flags = b[32]
+	ad.signCount = binary.BigEndian.Uint32(b[33:37])
+
+	if ad.flags&flagAttested == 0 {
+		return ad, nil
+	}
+
+	rest := b[37:]
+	// A 16 byte AAGUID comes first, then the length of the credential ID.
+	if len(rest) < 18 {
+		return ad, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+	}
+
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return ad, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+	}
+
+	ad.credentialID = rest[:idLen]
+	rest = rest[idLen:]
+
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return ad, err
+	}
+	ad.publicKey = rest[:len(rest)-len(after)]
+
+	return ad, nil
+}
+
+func (rp RelyingParty) checkAuthenticatorData(ad authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return ErrRelyingParty
+	}
+
+	if ad.flags&flagUserPresent == 0 {
+		return ErrUserPresence
+	}
+
+	// A passkey stands in for both the password and the second factor, so the
+	// authenticator must have checked the user's PIN or biometric too.
+	if ad.flags&flagUserVerified == 0 {
+		return ErrUserVerified
+	}
+
+	return nil
+}
+
+// VerifyRegistration checks the response to a navigator.credentials.create()
+// call made with challenge and returns the new credential.
+func (rp RelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (Credential, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	v, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	attestation, ok := v.(map[any]any)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: attestation object isn't a map", ErrInvalidResponse)
+	}
+
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, fmt.Errorf("%w: missing authenticator data", ErrInvalidResponse)
+	}
+
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	err = rp.checkAuthenticatorData(ad)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if len(ad.credentialID) == 0 {
+		return Credential{}, fmt.Errorf("%w: missing attested credential", ErrInvalidResponse)
+	}
+
+	_, err = parsePublicKey(ad.publicKey)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	return Credential{
+		ID:        bytes.Clone(ad.credentialID),
+		PublicKey: bytes.Clone(ad.publicKey),
+		SignCount: ad.signCount,
+	}, nil
+}
+
+// VerifyLogin checks the response to a navigator.credentials.get() call made
+// with challenge against the stored credential, and returns the credential's
+// new signature counter, which should be stored in place of the old one.
+func (rp RelyingParty) VerifyLogin(challenge []byte, credential Credential, clientDataJSON, authData, signature []byte) (uint32, error) {
+	err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	ad, err := parseAuthenticatorData(authData)
+	if err != nil {
+		return 0, err
+	}
+
+	err = rp.checkAuthenticatorData(ad)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(bytes.Clone(authData), clientDataHash[:]...))
+
+	if !ecdsa.VerifyASN1(key, signed[:], signature) {
+		return 0, ErrSignature
+	}
+
+	// Authenticators which don't keep a counter always report zero. Otherwise
+	// it must increase, or the credential has been copied.
+	if (ad.signCount != 0 || credential.SignCount != 0) && ad.signCount <= credential.SignCount {
+		return 0, ErrSignCount
+	}
+
+	return ad.signCount, nil
+}
+
+// parsePublicKey decodes a COSE encoded ES256 public key.
+func parsePublicKey(b []byte) (*ecdsa.PublicKey, error) {
+	v, _, err := decodeCBOR(b)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, ErrUnsupportedKey
+	}
+
+	// The COSE key parameters: 1 is the key type, 3 the algorithm, -1 the
+	// curve and -2 and -3 the coordinates.
+	if m[int64(1)] != int64(2) || m[int64(3)] != int64(AlgES256) || m[int64(-1)] != int64(1) {
+		return nil, ErrUnsupportedKey
+	}
+
+	x, okX := m[int64(-2)].([]byte)
+	y, okY := m[int64(-3)].([]byte)
+	if !okX || !okY || len(x) != 32 || len(y) != 32 {
+		return nil, ErrUnsupportedKey
+	}
+
+	// Going through crypto/ecdh rejects points which aren't on the curve.
+	_, err = ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...))
+	if err != nil {
+		return nil, ErrUnsupportedKey
+	}
+
+	key := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+
+	return key, nil
+}
@@ -0,0 +1,157 @@
+package webauthn_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-playground/assert"
+	"github.com/thisisjab/snippetbox-go/internal/webauthn"
+	"github.com/thisisjab/snippetbox-go/internal/webauthn/webauthntest"
+)
+
+var rp = webauthn.RelyingParty{ID: "snippetbox.test", Name: "Snippetbox", Origin: "https://snippetbox.test"}
+
+func register(t *testing.T) (*webauthntest.Authenticator, webauthn.Credential) {
+	authenticator, err := webauthntest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientDataJSON, attestationObject, err := authenticator.Create(rp.ID, rp.Origin, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return authenticator, credential
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	authenticator, credential := register(t)
+
+	assert.Equal(t, credential.ID, authenticator.CredentialID())
+	assert.Equal(t, credential.SignCount, uint32(1))
+
+	tests := []struct {
+		name            string
+		rpID, origin    string
+		signedChallenge []byte
+		unverified      bool
+		wantErr         error
+	}{
+		{
+			name:            "Wrong challenge",
+			rpID:            rp.ID,
+			origin:          rp.Origin,
+			signedChallenge: []byte("something else"),
+			wantErr:         webauthn.ErrChallenge,
+		},
+		{
+			name:    "Wrong origin",
+			rpID:    rp.ID,
+			origin:  "https://phishing.test",
+			wantErr: webauthn.ErrOrigin,
+		},
+		{
+			name:    "Wrong relying party",
+			rpID:    "phishing.test",
+			origin:  rp.Origin,
+			wantErr: webauthn.ErrRelyingParty,
+		},
+		{
+			name:       "User not verified",
+			rpID:       rp.ID,
+			origin:     rp.Origin,
+			unverified: true,
+			wantErr:    webauthn.ErrUserVerified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			challenge, err := webauthn.NewChallenge()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signedChallenge := challenge
+			if tt.signedChallenge != nil {
+				signedChallenge = tt.signedChallenge
+			}
+
+			authenticator.SkipUserVerification = tt.unverified
+			defer func() { authenticator.SkipUserVerification = false }()
+
+			clientDataJSON, attestationObject, err := authenticator.Create(tt.rpID, tt.origin, signedChallenge)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyLogin(t *testing.T) {
+	authenticator, credential := register(t)
+
+	login := func(t *testing.T, credential webauthn.Credential) (uint32, error) {
+		challenge, err := webauthn.NewChallenge()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		clientDataJSON, authData, signature, err := authenticator.Get(rp.ID, rp.Origin, challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return rp.VerifyLogin(challenge, credential, clientDataJSON, authData, signature)
+	}
+
+	signCount, err := login(t, credential)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, signCount, uint32(2))
+	credential.SignCount = signCount
+
+	t.Run("Other credential's key", func(t *testing.T) {
+		_, other := register(t)
+		other.SignCount = credential.SignCount
+
+		_, err := login(t, other)
+		if !errors.Is(err, webauthn.ErrSignature) {
+			t.Errorf("got error %v; want %v", err, webauthn.ErrSignature)
+		}
+	})
+
+	t.Run("User not verified", func(t *testing.T) {
+		authenticator.SkipUserVerification = true
+		defer func() { authenticator.SkipUserVerification = false }()
+
+		_, err := login(t, credential)
+		if !errors.Is(err, webauthn.ErrUserVerified) {
+			t.Errorf("got error %v; want %v", err, webauthn.ErrUserVerified)
+		}
+	})
+
+	t.Run("Cloned authenticator", func(t *testing.T) {
+		authenticator.SignCount = 0
+
+		_, err := login(t, credential)
+		if !errors.Is(err, webauthn.ErrSignCount) {
+			t.Errorf("got error %v; want %v", err, webauthn.ErrSignCount)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+// Package webauthntest provides a software authenticator for testing passkey
+// registration and login without a browser or security key.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+)
+
+// Authenticator holds a single ES256 credential, the way a security key
+// registered with one site would.
+type Authenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+
+	// SignCount is the signature counter last reported. Tests can lower it to
+	// simulate a cloned authenticator.
+	SignCount uint32
+
+	// SkipUserVerification makes the authenticator report that it only checked
+	// the user was present, as a security key without a PIN would.
+	SkipUserVerification bool
+}
+
+func New() (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialID := make([]byte, 16)
+
+	_, err = rand.Read(credentialID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Authenticator{key: key, credentialID: credentialID, SignCount: 1}, nil
+}
+
+// CredentialID returns the ID of the authenticator's credential.
+func (a *Authenticator) CredentialID() []byte {
+	return a.credentialID
+}
+
+// Create answers a registration challenge the way navigator.credentials.create()
+// would, returning the client data JSON and the attestation object.
+func (a *Authenticator) Create(rpID, origin string, challenge []byte) (clientDataJSON, attestationObject []byte, err error) {
+	clientDataJSON, err = clientData("webauthn.create", origin, challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ecdhKey, err := a.key.PublicKey.ECDH()
+	if err != nil {
+		return nil, nil, err
+	}
+	point := ecdhKey.Bytes()
+
+	publicKey := encode(map[any]any{
+		int64(1):  int64(2),
+		int64(3):  int64(-7),
+		int64(-1): int64(1),
+		int64(-2): point[1:33],
+		int64(-3): point[33:],
+	})
+
+	authData := a.authData(rpID, 0x40)
+	authData = append(authData, make([]byte, 16)...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.credentialID)))
+	authData = append(authData, a.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestationObject = encode(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": authData,
+	})
+
+	return clientDataJSON, attestationObject, nil
+}
+
+// Get answers a login challenge the way navigator.credentials.get() would,
+// returning the client data JSON, the authenticator data and the signature.
+func (a *Authenticator) Get(rpID, origin string, challenge []byte) (clientDataJSON, authData, signature []byte, err error) {
+	clientDataJSON, err = clientData("webauthn.get", origin, challenge)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	a.SignCount++
+	authData = a.authData(rpID, 0)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err = ecdsa.SignASN1(rand.Reader, a.key, signed[:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return clientDataJSON, authData, signature, nil
+}
+
+func (a *Authenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	b := append([]byte{}, rpIDHash[:]...)
+	// The user is always present, and verified unless the test says not.
+	flags |= 0x01
+	if !a.SkipUserVerification {
+		flags |= 0x04
+	}
+
+	b = append(b, flags)
+	b = binary.BigEndian.AppendUint32(b, a.SignCount)
+
+	return b
+}
+
+func clientData(ceremony, origin string, challenge []byte) ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    origin,
+	})
+}
+
+// encode encodes the handful of types authenticators produce as CBOR. Map keys
+// are sorted canonically: shorter encodings first, then bytewise.
+func encode(v any) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[any]any:
+		type entry struct{ key, value []byte }
+
+		entries := make([]entry, 0, len(v))
+		for key, value := range v {
+			entries = append(entries, entry{encode(key), encode(value)})
+		}
+
+		sort.Slice(entries, func(i, j int) bool {
+			ki, kj := entries[i].key, entries[j].key
+			if len(ki) != len(kj) {
+				return len(ki) < len(kj)
+			}
+			return string(ki) < string(kj)
+		})
+
+		b := head(5, uint64(len(v)))
+		for _, e := range entries {
+			b = append(b, e.key...)
+			b = append(b, e.value...)
+		}
+		return b
+	default:
+		panic("webauthntest: can't encode value")
+	}
+}
+
+func head(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+	}
+}
@@ -5,4 +5,107 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+var copyButtons = document.querySelectorAll("button[data-copy]");
+for (var i = 0; i < copyButtons.length; i++) {
+	copyButtons[i].addEventListener("click", function (event) {
+		var button = event.currentTarget;
+		var source = document.getElementById(button.getAttribute("data-copy"));
+		navigator.clipboard.writeText(source.value).then(function () {
+			button.textContent = "Copied!";
+		});
+	});
+}
+
+function base64URLToBuffer(s) {
+	var binary = atob(s.replace(/-/g, "+").replace(/_/g, "/"));
+	var bytes = new Uint8Array(binary.length);
+	for (var i = 0; i < binary.length; i++) {
+		bytes[i] = binary.charCodeAt(i);
+	}
+	return bytes.buffer;
+}
+
+function bufferToBase64URL(buffer) {
+	var bytes = new Uint8Array(buffer);
+	var binary = "";
+	for (var i = 0; i < bytes.length; i++) {
+		binary += String.fromCharCode(bytes[i]);
+	}
+	return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+// postJSON sends data to url with the page's CSRF token and returns the parsed
+// response, rejecting with the server's error message if there is one.
+function postJSON(url, data) {
+	var token = document.querySelector("input[name=csrf_token]").value;
+	return fetch(url, {
+		method: "POST",
+		headers: {"Content-Type": "application/json", "X-CSRF-Token": token},
+		body: JSON.stringify(data || {}),
+	}).then(function (response) {
+		return response.json().catch(function () {
+			return {};
+		}).then(function (body) {
+			if (!response.ok) {
+				throw new Error(body.error || "Something went wrong. Please try again.");
+			}
+			return body;
+		});
+	});
+}
+
+function showPasskeyError(container, err) {
+	var el = container.querySelector("[data-passkey-error]");
+	el.textContent = err.message;
+	el.hidden = false;
+}
+
+var passkeyRegisterForm = document.querySelector("form[data-passkey-register]");
+if (passkeyRegisterForm && window.PublicKeyCredential) {
+	passkeyRegisterForm.addEventListener("submit", function (event) {
+		event.preventDefault();
+		postJSON("/account/passkeys/register/begin").then(function (options) {
+			options.challenge = base64URLToBuffer(options.challenge);
+			options.user.id = base64URLToBuffer(options.user.id);
+			options.excludeCredentials.forEach(function (c) {
+				c.id = base64URLToBuffer(c.id);
+			});
+			return navigator.credentials.create({publicKey: options});
+		}).then(function (credential) {
+			return postJSON("/account/passkeys/register/finish", {
+				name: passkeyRegisterForm.elements.name.value,
+				clientDataJSON: bufferToBase64URL(credential.response.clientDataJSON),
+				attestationObject: bufferToBase64URL(credential.response.attestationObject),
+			});
+		}).then(function (body) {
+			window.location = body.redirect;
+		}).catch(function (err) {
+			showPasskeyError(passkeyRegisterForm, err);
+		});
+	});
+}
+
+var passkeyLoginButton = document.querySelector("button[data-passkey-login]");
+if (passkeyLoginButton && window.PublicKeyCredential) {
+	passkeyLoginButton.hidden = false;
+	passkeyLoginButton.addEventListener("click", function () {
+		postJSON("/user/login/passkey/begin").then(function (options) {
+			options.challenge = base64URLToBuffer(options.challenge);
+			return navigator.credentials.get({publicKey: options});
+		}).then(function (credential) {
+			return postJSON("/user/login/passkey/finish", {
+				id: bufferToBase64URL(credential.rawId),
+				clientDataJSON: bufferToBase64URL(credential.response.clientDataJSON),
+				authenticatorData: bufferToBase64URL(credential.response.authenticatorData),
+				signature: bufferToBase64URL(credential.response.signature),
+				rememberMe: passkeyLoginButton.form.elements.rememberMe.checked,
+			});
+		}).then(function (body) {
+			window.location = body.redirect;
+		}).catch(function (err) {
+			showPasskeyError(passkeyLoginButton.form, err);
+		});
+	});
+}